@@ -0,0 +1,117 @@
+package encdec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// minAdaptiveChunkSize is the size of the first chunk written by
+// EncryptAdaptive.
+const minAdaptiveChunkSize = 1 << 12
+
+// EncryptAdaptive encrypts src into dst using a 256-bit key and the params,
+// like Encrypt, but with chunks of variable length, each one prefixed by its
+// length. The output can only be decrypted by DecryptAdaptive.
+//
+// It starts with small chunks, doubling their length while encrypting takes
+// longer than reading and writing them, so the larger chunks are only used
+// when the CPU is the bottleneck, while slow sources and sinks get data
+// flowing early.
+// The length of a chunk never exceeds params.ChunkSize.
+//
+// The params must use FormatV2 or later, as the earlier format can't tell
+// the last chunk apart.
+func EncryptAdaptive(key []byte, src io.Reader, dst io.Writer, params *Params) error {
+	if params == nil {
+		return ErrNilParams
+	}
+	err := params.checkFormatted()
+	if err != nil {
+		return err
+	}
+	err = params.checkStreamOnly()
+	if err != nil {
+		return err
+	}
+
+	w, err := newFrameWriter(key, params)
+	if err != nil {
+		return err
+	}
+
+	maxSize := w.cipher.chunkSize
+	size := min(minAdaptiveChunkSize, maxSize)
+	buff := make([]byte, maxSize)
+	for {
+		start := time.Now()
+		n, err := io.ReadFull(src, buff[:size])
+		last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !last {
+			return fmt.Errorf("ecryption: %w", err)
+		}
+		readTime := time.Since(start)
+
+		start = time.Now()
+		frame, err := w.sealFrame(buff[:n], last)
+		if err != nil {
+			return fmt.Errorf("ecryption: %w", err)
+		}
+		sealTime := time.Since(start)
+
+		start = time.Now()
+		_, err = dst.Write(frame)
+		if err != nil {
+			return fmt.Errorf("ecryption: %w", err)
+		}
+		writeTime := time.Since(start)
+		if last {
+			return nil
+		}
+
+		if size < maxSize && sealTime > readTime+writeTime {
+			size = min(2*size, maxSize)
+		}
+	}
+}
+
+// DecryptAdaptive decrypts src, written by EncryptAdaptive, into dst using
+// a 256-bit key and the params.
+func DecryptAdaptive(key []byte, src io.Reader, dst io.Writer, params *Params) error {
+	if params == nil {
+		return ErrNilParams
+	}
+	err := params.checkFormatted()
+	if err != nil {
+		return err
+	}
+	err = params.checkStreamOnly()
+	if err != nil {
+		return err
+	}
+
+	r, err := newFrameReader(key, src, params)
+	if err != nil {
+		return err
+	}
+
+	out, checkDigest := digestWriter(dst, params)
+	for {
+		plaintext, last, err := r.readFrame()
+		if err != nil {
+			return fmt.Errorf("decryption: %w", err)
+		}
+		_, err = out.Write(plaintext)
+		if err != nil {
+			return fmt.Errorf("decryption: %w", err)
+		}
+		if last {
+			err = checkDigest()
+			if err != nil {
+				return fmt.Errorf("decryption: %w", err)
+			}
+			return nil
+		}
+	}
+}
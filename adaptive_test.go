@@ -0,0 +1,66 @@
+package encdec
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestEncryptAdaptive(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := &Params{Salt: bytes.Repeat([]byte{1}, SaltSize)}
+	err := params.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, 1, minAdaptiveChunkSize, 1 << 20} {
+		plaintext := make([]byte, size)
+		for i := range plaintext {
+			plaintext[i] = byte(i)
+		}
+		var ciphertext, got bytes.Buffer
+		err = EncryptAdaptive(key, bytes.NewReader(plaintext), &ciphertext, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = DecryptAdaptive(key, &ciphertext, &got, params)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got.Bytes(), plaintext) {
+			t.Fatalf("%d bytes: decrypted data doesn't match the plaintext", size)
+		}
+	}
+}
+
+// BenchmarkEncryptAdaptive compares EncryptAdaptive against Encrypt, with
+// its chunks of the fixed default size, over a source and a sink that
+// never wait, so adaptive chunks grow as fast as they can.
+func BenchmarkEncryptAdaptive(b *testing.B) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := &Params{Salt: bytes.Repeat([]byte{1}, SaltSize)}
+	err := params.Check()
+	if err != nil {
+		b.Fatal(err)
+	}
+	plaintext := make([]byte, 16<<20)
+
+	for _, bench := range []struct {
+		name    string
+		encrypt func(key []byte, src io.Reader, dst io.Writer, params *Params) error
+	}{
+		{"fixed", Encrypt},
+		{"adaptive", EncryptAdaptive},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(int64(len(plaintext)))
+			for range b.N {
+				err := bench.encrypt(key, bytes.NewReader(plaintext), io.Discard, params)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package encdec
+
+import (
+	"fmt"
+	"io"
+)
+
+// AuditSalts parses the header of every reader and reports the groups of
+// readers sharing the same salt, by their indexes in readers. Streams
+// encrypted with the same salt and password share the same key, and so
+// the same nonces, which breaks the security of the cipher.
+//
+// The groups are in the order their first reader appears in readers, and
+// the indexes in each group are ascending. Only the header is read from
+// each reader.
+func AuditSalts(readers []io.Reader) (duplicates [][]int, err error) {
+	groups := make(map[string][]int)
+	var order []string
+	for i, r := range readers {
+		params, _, err := ParseHeaderRaw(r)
+		if err != nil {
+			return nil, fmt.Errorf("reader %d: %w", i, err)
+		}
+
+		salt := string(params.Salt)
+		if _, ok := groups[salt]; !ok {
+			order = append(order, salt)
+		}
+		groups[salt] = append(groups[salt], i)
+	}
+
+	for _, salt := range order {
+		if len(groups[salt]) > 1 {
+			duplicates = append(duplicates, groups[salt])
+		}
+	}
+	return duplicates, nil
+}
@@ -0,0 +1,79 @@
+package encdec
+
+import (
+	"io"
+)
+
+// WithBlockSize makes the Writer write to the underlying writer in blocks
+// of exactly size bytes, buffering the ciphertext across chunk boundaries,
+// except for the last write made by Close, which may be shorter. This
+// aligns the ciphertext to the blocks of a device, or to the parts of an
+// object store, sparing partial writes. A header written before to the
+// same writer isn't counted. A value of size <= 0 means no blocks.
+//
+// It can't be combined with WithChunkLog, as chunks written are held back
+// until their block is full.
+func WithBlockSize(size int) WriterOption {
+	return writerOptionFunc(func(c *writerConfig) {
+		c.blockSize = size
+	})
+}
+
+// blockWriter writes to dst in blocks of exactly len(buff) bytes,
+// until flushed.
+type blockWriter struct {
+	dst  io.Writer
+	buff []byte
+	n    int
+}
+
+func newBlockWriter(dst io.Writer, size int) *blockWriter {
+	return &blockWriter{dst: dst, buff: make([]byte, size)}
+}
+
+func (b *blockWriter) Write(p []byte) (int, error) {
+	var total int
+	for len(p) > 0 {
+		// Whole blocks are written right from p when nothing is buffered.
+		if b.n == 0 && len(p) >= len(b.buff) {
+			_, err := b.dst.Write(p[:len(b.buff)])
+			if err != nil {
+				return total, err
+			}
+			total += len(b.buff)
+			p = p[len(b.buff):]
+			continue
+		}
+
+		n := copy(b.buff[b.n:], p)
+		b.n += n
+		total += n
+		p = p[n:]
+		if b.n == len(b.buff) {
+			_, err := b.dst.Write(b.buff)
+			if err != nil {
+				return total, err
+			}
+			b.n = 0
+		}
+	}
+	return total, nil
+}
+
+// Flush writes the partial block buffered, if any, and flushes dst if it
+// has a Flush() error method.
+func (b *blockWriter) Flush() error {
+	if b.n > 0 {
+		_, err := b.dst.Write(b.buff[:b.n])
+		if err != nil {
+			return err
+		}
+		b.n = 0
+	}
+
+	flusher, ok := b.dst.(interface{ Flush() error })
+	if !ok {
+		return nil
+	}
+	return flusher.Flush()
+}
@@ -0,0 +1,62 @@
+package encdec
+
+import (
+	"bytes"
+	"testing"
+)
+
+// recordingWriter records the length of every write.
+type recordingWriter struct {
+	bytes.Buffer
+	writes []int
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, len(p))
+	return w.Buffer.Write(p)
+}
+
+func TestWithBlockSize(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+
+	for _, n := range []int{0, 10, 100, 1000} {
+		plaintext := bytes.Repeat([]byte{'x'}, n)
+		want, err := encryptWithKey(key, plaintext, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = want[bytes.IndexByte(want, '\n')+1:]
+
+		// Smaller, the same as, and larger than a sealed chunk of 80
+		// bytes, and larger than the whole ciphertext.
+		for _, size := range []int{1, 7, 80, 100, 4096} {
+			var out recordingWriter
+			w, err := NewWriter(key, &out, params, WithBlockSize(size))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Write in pieces, so chunks are sealed between writes.
+			for rest := plaintext; len(rest) > 0; {
+				m := min(33, len(rest))
+				w.Write(rest[:m])
+				rest = rest[m:]
+			}
+			err = w.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Fatalf("%d bytes, block size %d: ciphertext differs from the one without blocks", n, size)
+			}
+			for i, written := range out.writes {
+				last := i == len(out.writes)-1
+				if written == 0 || written > size || !last && written != size {
+					t.Fatalf("%d bytes, block size %d: got writes of %v bytes", n, size, out.writes)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package encdec
+
+import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrWrongKey is wrapped by the error of the first chunk of a stream failing
+// authentication, which almost always means the key, or the password it was
+// derived from, is wrong, as corrupted data usually fails a later chunk.
+var ErrWrongKey = errors.New("wrong key or corrupted data")
+
+// ErrShortChunk is returned for a chunk shorter than the overhead of the
+// cipher of its stream, which can't have been sealed by it, such as one
+// cut short or framed for a cipher with a different overhead.
+var ErrShortChunk = errors.New("chunk shorter than the cipher overhead")
+
+// chunkCipher encrypts and decrypts the chunks of a stream in order,
+// keeping track of the index of the next chunk.
+type chunkCipher struct {
+	aead   cipher.AEAD
+	format uint8
+	aad    []byte
+	header []byte
+	index  uint64
+	nonce  [nonceSize]byte
+	ad     []byte
+
+	// chunkSize and sealedSize are the lengths of a full chunk,
+	// before and after being encrypted.
+	chunkSize  int
+	sealedSize int
+}
+
+// newChunkCipher creates a chunkCipher for a stream encrypted with key and
+// params, whose chunks are authenticated along with aad.
+func newChunkCipher(key []byte, params *Params, aad []byte) (*chunkCipher, error) {
+	if params.Format >= FormatV4 {
+		key = deriveSubkeys(key, params.Salt).data
+	}
+	aead, err := newAEAD(key, params.Cipher)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &chunkCipher{
+		aead:   aead,
+		format: params.Format,
+		aad:    aad,
+	}
+	c.chunkSize, c.sealedSize, err = chunkSizes(params.ChunkSize, aead.Overhead())
+	if err != nil {
+		return nil, err
+	}
+
+	// The header is bound by the hash of its canonical form, rather than
+	// by the bytes read, as some fields have more than one accepted
+	// representation with the same meaning.
+	if params.Format >= FormatV3 {
+		err = params.checkFormatted()
+		if err != nil {
+			return nil, err
+		}
+		header, err := params.marshalHeader()
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(header)
+		c.header = sum[:]
+	}
+	return c, nil
+}
+
+// chunkSizes returns chunkSize and the length of a chunk of chunkSize
+// once encrypted, as ints. A chunk size whose encrypted length doesn't
+// fit in an int returns an error wrapping ErrChunkSizeTooLarge, instead
+// of wrapping around.
+func chunkSizes(chunkSize int64, overhead int) (int, int, error) {
+	if chunkSize > int64(math.MaxInt-overhead) {
+		return 0, 0, fmt.Errorf("%w: %d", ErrChunkSizeTooLarge, chunkSize)
+	}
+	return int(chunkSize), int(chunkSize) + overhead, nil
+}
+
+// additionalData returns the associated data of the chunk at index,
+// appended to dst. Since FormatV2 the index of the chunk and whether it is
+// the last one are authenticated, besides its nonce, so the chunks can't
+// be reordered, dropped or appended without failing authentication.
+// Since FormatV3 the hash of the header is also authenticated.
+func (c *chunkCipher) additionalData(dst []byte, index uint64, last bool) []byte {
+	dst = append(dst, c.aad...)
+	if c.format < FormatV2 {
+		return dst
+	}
+
+	dst = binary.BigEndian.AppendUint64(dst, index)
+	if last {
+		dst = append(dst, 1)
+	} else {
+		dst = append(dst, 0)
+	}
+	return append(dst, c.header...)
+}
+
+// seal encrypts the next chunk, appending the result to dst.
+func (c *chunkCipher) seal(dst []byte, plaintext []byte, last bool) ([]byte, error) {
+	return c.sealExtra(dst, plaintext, last, nil)
+}
+
+// sealExtra works like seal, also authenticating extra.
+func (c *chunkCipher) sealExtra(dst []byte, plaintext []byte, last bool, extra []byte) ([]byte, error) {
+	c.ad = append(c.additionalData(c.ad[:0], c.index, last), extra...)
+	ciphertext := c.aead.Seal(dst, c.nonce[:], plaintext, c.ad)
+	return ciphertext, c.next()
+}
+
+// open decrypts the next chunk, appending the result to dst.
+// Only a successful call moves to the next chunk.
+func (c *chunkCipher) open(dst []byte, ciphertext []byte, last bool) ([]byte, error) {
+	return c.openExtra(dst, ciphertext, last, nil)
+}
+
+// openExtra works like open, also authenticating extra.
+func (c *chunkCipher) openExtra(dst []byte, ciphertext []byte, last bool, extra []byte) ([]byte, error) {
+	err := c.checkLength(ciphertext, c.index)
+	if err != nil {
+		return nil, err
+	}
+
+	c.ad = append(c.additionalData(c.ad[:0], c.index, last), extra...)
+	plaintext, err := c.aead.Open(dst, c.nonce[:], ciphertext, c.ad)
+	if err != nil {
+		return nil, wrapOpenError(err, c.index)
+	}
+	return plaintext, c.next()
+}
+
+// openAt decrypts the chunk at index, appending the result to dst.
+// Unlike open it doesn't change c, so it is safe for concurrent use.
+func (c *chunkCipher) openAt(dst []byte, ciphertext []byte, index uint64, last bool) ([]byte, error) {
+	err := c.checkLength(ciphertext, index)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, nonceSize)
+	setNonce(nonce, index)
+	plaintext, err := c.aead.Open(dst, nonce, ciphertext, c.additionalData(nil, index, last))
+	if err != nil {
+		return nil, wrapOpenError(err, index)
+	}
+	return plaintext, nil
+}
+
+// checkLength returns an error wrapping ErrShortChunk if the chunk at
+// index is too short to hold the overhead of the cipher.
+func (c *chunkCipher) checkLength(ciphertext []byte, index uint64) error {
+	overhead := c.aead.Overhead()
+	if len(ciphertext) < overhead {
+		return fmt.Errorf("%w: chunk %d has %d bytes, less than %d", ErrShortChunk, index, len(ciphertext), overhead)
+	}
+	return nil
+}
+
+// wrapOpenError wraps err, of the chunk at index failing authentication,
+// in ErrWrongKey if it is the first chunk.
+func wrapOpenError(err error, index uint64) error {
+	if index != 0 {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrWrongKey, err)
+}
+
+// seek makes index the next chunk.
+func (c *chunkCipher) seek(index uint64) {
+	c.index = index
+	setNonce(c.nonce[:], index)
+}
+
+func (c *chunkCipher) next() error {
+	c.index++
+	return incNonce(c.nonce[:])
+}
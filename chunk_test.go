@@ -0,0 +1,136 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestChunkReordering(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	for _, format := range []uint8{FormatV2, FormatV3, FormatV4} {
+		params := testParams()
+		params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+		params.Format = format
+		plaintext := append(bytes.Repeat([]byte{'a'}, 64), bytes.Repeat([]byte{'b'}, 64)...)
+		plaintext = append(plaintext, 'c')
+		blob, err := encryptWithKey(key, plaintext, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = decryptWithKey(key, blob)
+		if err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+
+		header := bytes.IndexByte(blob, '\n') + 1
+		const sealed = 64 + 16
+		chunks := blob[header:]
+		first, second := chunks[:sealed], chunks[sealed:2*sealed]
+
+		swapped := append(bytes.Clone(blob[:header]), second...)
+		swapped = append(append(swapped, first...), chunks[2*sealed:]...)
+		_, err = decryptWithKey(key, swapped)
+		if err == nil {
+			t.Fatalf("format %d: swapped chunks were accepted", format)
+		}
+
+		dropped := append(bytes.Clone(blob[:header]), chunks[sealed:]...)
+		_, err = decryptWithKey(key, dropped)
+		if err == nil {
+			t.Fatalf("format %d: a dropped chunk was accepted", format)
+		}
+	}
+}
+
+func TestAdditionalDataIndex(t *testing.T) {
+	c, err := newChunkCipher(make([]byte, keySize), &Params{Format: FormatV2, Cipher: ChaCha20Poly1305, ChunkSize: 64}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(c.additionalData(nil, 0, false), c.additionalData(nil, 1, false)) {
+		t.Fatal("the index of the chunk isn't authenticated")
+	}
+	if bytes.Equal(c.additionalData(nil, 0, false), c.additionalData(nil, 0, true)) {
+		t.Fatal("the last chunk isn't authenticated")
+	}
+}
+
+func TestChunkSizes(t *testing.T) {
+	chunkSize, sealedSize, err := chunkSizes(math.MaxInt-16, 16)
+	if err != nil || chunkSize != math.MaxInt-16 || sealedSize != math.MaxInt {
+		t.Fatalf("got %d, %d, %v", chunkSize, sealedSize, err)
+	}
+	for _, size := range []int64{math.MaxInt - 15, math.MaxInt} {
+		_, _, err := chunkSizes(size, 16)
+		if !errors.Is(err, ErrChunkSizeTooLarge) {
+			t.Fatalf("chunk size %d: got error %v, want ErrChunkSizeTooLarge", size, err)
+		}
+	}
+
+	// A hostile chunk size from a header is refused before anything is
+	// allocated, by the streaming and the pipelined paths alike.
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	params.ChunkSize = math.MaxInt64
+	key := make([]byte, keySize)
+	_, err = NewReader(key, bytes.NewReader(nil), params)
+	if !errors.Is(err, ErrChunkSizeTooLarge) {
+		t.Fatalf("NewReader: got error %v, want ErrChunkSizeTooLarge", err)
+	}
+	_, err = NewWriter(key, io.Discard, params)
+	if !errors.Is(err, ErrChunkSizeTooLarge) {
+		t.Fatalf("NewWriter: got error %v, want ErrChunkSizeTooLarge", err)
+	}
+	err = Decrypt(key, bytes.NewReader(nil), io.Discard, params)
+	if !errors.Is(err, ErrChunkSizeTooLarge) {
+		t.Fatalf("Decrypt: got error %v, want ErrChunkSizeTooLarge", err)
+	}
+	_, err = CiphertextSize(math.MaxInt64-10, params)
+	if !errors.Is(err, ErrInputTooLarge) {
+		t.Fatalf("CiphertextSize: got error %v, want ErrInputTooLarge", err)
+	}
+}
+
+func TestShortChunk(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	for _, cipher := range []string{ChaCha20Poly1305, AES256GCM} {
+		params := testParams()
+		params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+		params.Cipher = cipher
+		var out bytes.Buffer
+		w, err := NewWriter(key, &out, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(bytes.Repeat([]byte{'x'}, 100))
+		err = w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Chunks take 64+16 bytes, so the second chunk is cut to fewer
+		// bytes than the tag, and an empty stream has an empty chunk.
+		for _, size := range []int{0, 80 + 1, 80 + 15} {
+			ciphertext := out.Bytes()[:size]
+			r, err := NewReader(key, bytes.NewReader(ciphertext), params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = io.ReadAll(r)
+			if !errors.Is(err, ErrShortChunk) {
+				t.Fatalf("%s, %d bytes: Reader returned %v, want ErrShortChunk", cipher, size, err)
+			}
+			err = Decrypt(key, bytes.NewReader(ciphertext), io.Discard, params)
+			if !errors.Is(err, ErrShortChunk) {
+				t.Fatalf("%s, %d bytes: Decrypt returned %v, want ErrShortChunk", cipher, size, err)
+			}
+			err = DecryptAt(key, bytes.NewReader(ciphertext), int64(size), io.Discard, params)
+			if !errors.Is(err, ErrShortChunk) {
+				t.Fatalf("%s, %d bytes: DecryptAt returned %v, want ErrShortChunk", cipher, size, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package encdec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// chunkRecordSize is the length of a record of the chunk log: the index of
+// the chunk, its length once encrypted, whether it is the last one, its
+// authentication tag and the CRC-32 of all the preceding.
+const chunkRecordSize = 8 + 4 + 1 + tagSize + 4
+
+var ErrChunkLog = errors.New("invalid chunk log")
+
+// ResumeFile is a file holding a partially written stream,
+// which can be cut at the last chunk known to be complete.
+type ResumeFile interface {
+	io.ReadWriteSeeker
+	Truncate(size int64) error
+}
+
+// chunkRecord records a chunk once written to the underlying writer.
+type chunkRecord struct {
+	index  uint64
+	length uint32
+	last   bool
+	tag    [tagSize]byte
+}
+
+func (r *chunkRecord) marshal() []byte {
+	b := make([]byte, 0, chunkRecordSize)
+	b = binary.BigEndian.AppendUint64(b, r.index)
+	b = binary.BigEndian.AppendUint32(b, r.length)
+	if r.last {
+		b = append(b, 1)
+	} else {
+		b = append(b, 0)
+	}
+	b = append(b, r.tag[:]...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+}
+
+func parseChunkRecord(b []byte) (*chunkRecord, bool) {
+	sum := binary.BigEndian.Uint32(b[chunkRecordSize-4:])
+	if crc32.ChecksumIEEE(b[:chunkRecordSize-4]) != sum || b[12] > 1 {
+		return nil, false
+	}
+
+	r := &chunkRecord{
+		index:  binary.BigEndian.Uint64(b),
+		length: binary.BigEndian.Uint32(b[8:]),
+		last:   b[12] == 1,
+	}
+	copy(r.tag[:], b[13:])
+	return r, true
+}
+
+// WithChunkLog makes the Writer append a record to log after every chunk
+// written to the underlying writer, so an interrupted encryption can be
+// resumed by ResumeWriter instead of started over.
+//
+// If the underlying writer or log have a Sync() error method, such as an
+// *os.File, the chunk is synced before its record is written, and the
+// record is synced after, so the log never claims a chunk that could be
+// lost in a crash. This costs two syncs for every chunk.
+func WithChunkLog(log io.Writer) WriterOption {
+	return writerOptionFunc(func(c *writerConfig) {
+		c.chunkLog = log
+	})
+}
+
+// logChunk records the chunk at index, after syncing it.
+func (w *Writer) logChunk(index uint64, ciphertext []byte, last bool) error {
+	err := syncWriter(w.dst)
+	if err != nil {
+		return err
+	}
+
+	record := chunkRecord{index: index, length: uint32(len(ciphertext)), last: last}
+	copy(record.tag[:], ciphertext[len(ciphertext)-tagSize:])
+	_, err = w.config.chunkLog.Write(record.marshal())
+	if err != nil {
+		return fmt.Errorf("writing chunk log: %w", err)
+	}
+	return syncWriter(w.config.chunkLog)
+}
+
+func syncWriter(v any) error {
+	syncer, ok := v.(interface{ Sync() error })
+	if !ok {
+		return nil
+	}
+	return syncer.Sync()
+}
+
+// readChunkLog returns the records in log up to the first invalid one,
+// which is left by a crash while writing it.
+func readChunkLog(log io.Reader) ([]*chunkRecord, error) {
+	var records []*chunkRecord
+	b := make([]byte, chunkRecordSize)
+	for {
+		_, err := io.ReadFull(log, b)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		record, ok := parseChunkRecord(b)
+		if !ok || record.index != uint64(len(records)) {
+			return records, nil
+		}
+		records = append(records, record)
+	}
+}
+
+// ResumeWriter creates a Writer resuming the encryption of a stream into f
+// interrupted by a crash, using a 256-bit key and the log written by
+// WithChunkLog. The header of f must have already been read by
+// ParseHeader, so f is positioned at the beginning of the encrypted data.
+//
+// The last chunk recorded by the log is checked to be in f, which is cut
+// right after it, removing any chunk not recorded. The returned offset is
+// where the plaintext must be written from, which must be the same as
+// before: encrypting different data after resuming reuses the nonces of
+// the chunks cut off.
+//
+// Streams with a Trailer, Compress, Frames or Erasure can't be resumed, and
+// neither can those whose last chunk was recorded, as they are complete.
+func ResumeWriter(key []byte, f ResumeFile, log io.Reader, params *Params, opts ...WriterOption) (*Writer, int64, error) {
+	w, err := NewWriter(key, f, params, opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	if params.Trailer {
+		return nil, 0, errors.New("resuming is not supported with a trailer")
+	}
+	if params.Compress {
+		return nil, 0, errors.New("resuming is not supported with compression")
+	}
+	if params.Frames {
+		return nil, 0, errors.New("resuming is not supported with frames")
+	}
+	if params.Erasure != (Erasure{}) {
+		return nil, 0, errors.New("resuming is not supported with erasure coding")
+	}
+
+	records, err := readChunkLog(log)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading chunk log: %w", err)
+	}
+	sealedSize := int64(w.cipher.sealedSize)
+	for _, record := range records {
+		if record.last {
+			return nil, 0, fmt.Errorf("%w: the stream is complete", ErrChunkLog)
+		}
+		if int64(record.length) != sealedSize {
+			return nil, 0, fmt.Errorf("%w: chunk %d has length %d", ErrChunkLog, record.index, record.length)
+		}
+	}
+
+	start, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, err
+	}
+	index := int64(len(records))
+	end := start + index*sealedSize
+	if index > 0 {
+		err = w.checkChunk(f, records[index-1], end-sealedSize)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	err = f.Truncate(end)
+	if err != nil {
+		return nil, 0, err
+	}
+	_, err = f.Seek(end, io.SeekStart)
+	if err != nil {
+		return nil, 0, err
+	}
+	w.cipher.seek(uint64(index))
+	w.plaintextOffset = index * w.chunkSize
+	w.ciphertextOffset = index * sealedSize
+	return w, index * w.chunkSize, nil
+}
+
+// checkChunk checks that the chunk at offset in f is the one in record.
+func (w *Writer) checkChunk(f io.ReadSeeker, record *chunkRecord, offset int64) error {
+	_, err := f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	ciphertext := make([]byte, record.length)
+	_, err = io.ReadFull(f, ciphertext)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d is missing: %w", ErrChunkLog, record.index, err)
+	}
+
+	tag := ciphertext[len(ciphertext)-tagSize:]
+	if !bytes.Equal(tag, record.tag[:]) {
+		return fmt.Errorf("%w: chunk %d differs from the log", ErrChunkLog, record.index)
+	}
+	_, err = w.cipher.openAt(nil, ciphertext, record.index, false)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d: %w", ErrChunkLog, record.index, err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package encdec
+
+import (
+	"fmt"
+)
+
+// ChunkOffset locates a chunk written by a Writer, so an index of the
+// stream can be built as it is written. Offsets are counted from the start
+// of the encrypted data, not counting a header written before to the same
+// writer, and plaintext offsets from the start of the data written.
+type ChunkOffset struct {
+	// Index is the index of the chunk in the stream.
+	Index uint64
+
+	// PlaintextOffset and CiphertextOffset are where the chunk starts.
+	PlaintextOffset  int64
+	CiphertextOffset int64
+
+	// Length and CiphertextLength are the lengths of the chunk. The
+	// ciphertext is longer by the overhead of the chunk, or shorter if
+	// it was compressed.
+	Length           int
+	CiphertextLength int
+
+	// Last reports whether this is the last chunk of the stream.
+	Last bool
+}
+
+// WithChunkOffsets makes the Writer call fn with the offsets of every
+// chunk written, once it is handed to the underlying writer. An error
+// returned by fn stops the Writer, being returned by the Write or Close
+// writing the chunk.
+//
+// For streams with chunks of a fixed length, the offsets follow from the
+// chunk size, but those with Frames or Compress have chunks of varying
+// length, which can only be located by their offsets. The parity chunks
+// written with Erasure are counted in the ciphertext offsets, but aren't
+// reported themselves. With a Trailer, the last chunks also hold the
+// trailer, which is counted in their Length.
+func WithChunkOffsets(fn func(ChunkOffset) error) WriterOption {
+	return writerOptionFunc(func(c *writerConfig) {
+		c.chunkOffsets = fn
+	})
+}
+
+// reportChunk reports the chunk at index with its lengths to the callback
+// of WithChunkOffsets, if any, moving the offsets of w past it.
+func (w *Writer) reportChunk(index uint64, length int, ciphertextLength int, last bool) error {
+	offset := ChunkOffset{
+		Index:            index,
+		PlaintextOffset:  w.plaintextOffset,
+		CiphertextOffset: w.ciphertextOffset,
+		Length:           length,
+		CiphertextLength: ciphertextLength,
+		Last:             last,
+	}
+	w.plaintextOffset += int64(length)
+	w.ciphertextOffset += int64(ciphertextLength)
+	if w.config.chunkOffsets == nil {
+		return nil
+	}
+
+	err := w.config.chunkOffsets(offset)
+	if err != nil {
+		return fmt.Errorf("reporting chunk %d: %w", index, err)
+	}
+	return nil
+}
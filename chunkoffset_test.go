@@ -0,0 +1,127 @@
+package encdec
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestChunkOffsets(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	// Half of the plaintext compresses, and the other half doesn't.
+	plaintext := make([]byte, 3000)
+	rand.Read(plaintext[:1500])
+
+	for name, params := range map[string]*Params{
+		"fixed":    {ChunkSize: 256},
+		"frames":   {ChunkSize: 256, Frames: true},
+		"compress": {ChunkSize: 256, Compress: true},
+		"erasure":  {ChunkSize: 256, Erasure: Erasure{Data: 4, Parity: 2}},
+	} {
+		params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+		var offsets []ChunkOffset
+		var out bytes.Buffer
+		w, err := NewWriter(key, &out, params, WithChunkOffsets(func(offset ChunkOffset) error {
+			offsets = append(offsets, offset)
+			return nil
+		}))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		_, err = w.Write(plaintext[:1000])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if params.Frames {
+			// A short chunk in the middle of the stream.
+			err = w.FlushChunk()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err = w.Write(plaintext[1000:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ciphertext := out.Bytes()
+
+		// The chunks follow each other, without gaps, up to the end
+		// of the plaintext, and of the ciphertext except for the
+		// parity chunks of erasure coding.
+		var plaintextOffset, ciphertextOffset int64
+		for i, offset := range offsets {
+			if offset.Index != uint64(i) || offset.PlaintextOffset != plaintextOffset || offset.Last != (i == len(offsets)-1) {
+				t.Fatalf("%s: chunk %d reported as %+v", name, i, offset)
+			}
+			if params.Erasure == (Erasure{}) && offset.CiphertextOffset != ciphertextOffset {
+				t.Fatalf("%s: chunk %d at ciphertext offset %d, want %d", name, i, offset.CiphertextOffset, ciphertextOffset)
+			}
+			plaintextOffset += int64(offset.Length)
+			ciphertextOffset = offset.CiphertextOffset + int64(offset.CiphertextLength)
+		}
+		if plaintextOffset != int64(len(plaintext)) {
+			t.Fatalf("%s: chunks hold %d bytes, want %d", name, plaintextOffset, len(plaintext))
+		}
+		if params.Erasure != (Erasure{}) {
+			continue
+		}
+		if ciphertextOffset != int64(len(ciphertext)) {
+			t.Fatalf("%s: chunks end at %d, want %d", name, ciphertextOffset, len(ciphertext))
+		}
+
+		// Every chunk decrypts on its own at its offset, to the
+		// plaintext at its own offset.
+		for _, offset := range offsets {
+			chunk := ciphertext[offset.CiphertextOffset : offset.CiphertextOffset+int64(offset.CiphertextLength)]
+			got, err := openChunkAt(key, params, chunk, offset)
+			if err != nil {
+				t.Fatalf("%s: chunk %d: %v", name, offset.Index, err)
+			}
+			want := plaintext[offset.PlaintextOffset : offset.PlaintextOffset+int64(offset.Length)]
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%s: chunk %d doesn't decrypt to the plaintext at %d", name, offset.Index, offset.PlaintextOffset)
+			}
+		}
+	}
+
+	failure := errors.New("failure")
+	w, err := NewWriter(key, &bytes.Buffer{}, &Params{ChunkSize: 256, Salt: bytes.Repeat([]byte{1}, SaltSize)}, WithChunkOffsets(func(ChunkOffset) error {
+		return failure
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(plaintext)
+	if !errors.Is(err, failure) {
+		t.Fatalf("failing callback: got error %v, want its error", err)
+	}
+}
+
+// openChunkAt decrypts the chunk located by offset, as read from its
+// ciphertext offset without the chunks before it.
+func openChunkAt(key []byte, params *Params, chunk []byte, offset ChunkOffset) ([]byte, error) {
+	if !params.Frames && !params.Compress {
+		c, err := newChunkCipher(key, params, nil)
+		if err != nil {
+			return nil, err
+		}
+		return c.openAt(nil, chunk, offset.Index, offset.Last)
+	}
+
+	r, err := newFrameReader(key, bytes.NewReader(chunk), params)
+	if err != nil {
+		return nil, err
+	}
+	r.compressed = params.Compress
+	r.cipher.seek(offset.Index)
+	plaintext, flags, err := r.readFrameFlags()
+	if err != nil || flags&frameCompressed == 0 {
+		return plaintext, err
+	}
+	return newChunkDecompressor().decompress(make([]byte, params.ChunkSize+1), plaintext, int(params.ChunkSize))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// atomicFile is a temporary file that replaces the file at name once
+// committed, so the file at name is either completely written or untouched.
+type atomicFile struct {
+	*os.File
+	name string
+}
+
+// createAtomic creates an atomicFile for name with the permissions perm,
+// set regardless of the umask. The temporary file is created in the same
+// directory as name, so it can be renamed over it.
+func createAtomic(name string, perm os.FileMode) (*atomicFile, error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return nil, err
+	}
+	err = f.Chmod(perm)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return &atomicFile{File: f, name: name}, nil
+}
+
+// commit flushes the temporary file to disk and renames it to its final
+// name, flushing the directory so the rename itself is durable.
+func (f *atomicFile) commit() error {
+	err := f.Sync()
+	if err != nil {
+		f.abort()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = os.Rename(f.Name(), f.name)
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return syncDir(filepath.Dir(f.name))
+}
+
+// abort closes and removes the temporary file, leaving the file at name
+// untouched.
+func (f *atomicFile) abort() {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+func syncDir(name string) error {
+	// Directories can't be opened for syncing on Windows,
+	// where renames are durable once they return.
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	dir, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
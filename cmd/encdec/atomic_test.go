@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// checkDir fails unless dir holds exactly the files named.
+func checkDir(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Name())
+	}
+	if !slices.Equal(got, names) {
+		t.Fatalf("got files %q, want %q", got, names)
+	}
+}
+
+func TestAtomicFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "output")
+	err := os.WriteFile(name, []byte("old"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := createAtomic(name, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("new"))
+	checkFile(t, name, []byte("old"))
+	f.abort()
+	checkFile(t, name, []byte("old"))
+	checkDir(t, dir, "output")
+
+	f, err = createAtomic(name, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("new"))
+	err = f.commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, name, []byte("new"))
+	checkDir(t, dir, "output")
+
+	// Renaming over a directory fails, and the temporary file is removed.
+	sub := filepath.Join(dir, "sub")
+	err = os.Mkdir(sub, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err = createAtomic(sub, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.commit()
+	if err == nil {
+		t.Fatal("commit replaced a directory")
+	}
+	checkDir(t, dir, "output", "sub")
+}
+
+func TestDecryptAtomic(t *testing.T) {
+	dir := t.TempDir()
+	plaintext := bytes.Repeat([]byte("plaintext"), 20)
+	input := writeEncrypted(t, dir, "input.enc", plaintext)
+	output := filepath.Join(dir, "output")
+	err := os.WriteFile(output, []byte("old"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The last chunk fails once the others were written to the
+	// temporary file, which is removed, leaving the output untouched.
+	blob, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob[len(blob)-1] ^= 1
+	tampered := filepath.Join(dir, "tampered.enc")
+	err = os.WriteFile(tampered, blob, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = runCommand([]string{"decrypt", "-p", "password", tampered, output})
+	if err == nil {
+		t.Fatal("decrypted a tampered file")
+	}
+	checkFile(t, output, []byte("old"))
+	checkDir(t, dir, "input.enc", "output", "tampered.enc")
+
+	ok, err := runCommand([]string{"decrypt", "-p", "password", input, output})
+	if !ok || err != nil {
+		t.Fatalf("got %v, %v", ok, err)
+	}
+	checkFile(t, output, plaintext)
+	checkDir(t, dir, "input.enc", "output", "tampered.enc")
+}
@@ -0,0 +1,59 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestOutputPerm(t *testing.T) {
+	dir := t.TempDir()
+	input := writeEncrypted(t, dir, "input.enc", []byte("plaintext"))
+
+	// The permissions are set whatever the umask, neither widened by a
+	// permissive one nor narrowed by a strict one.
+	for _, tt := range []struct {
+		umask int
+		perm  string
+		want  os.FileMode
+	}{
+		{0, "", 0600},
+		{0077, "0640", 0640},
+		{0022, "0666", 0666},
+	} {
+		opts := options{perm: defaultPerm}
+		if tt.perm != "" {
+			var err error
+			opts.perm, err = parsePerm(tt.perm)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		output := filepath.Join(dir, "output"+tt.perm)
+
+		old := syscall.Umask(tt.umask)
+		err := decrypt([]byte("password"), input, output, &opts)
+		syscall.Umask(old)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != tt.want {
+			t.Fatalf("umask %04o, -perm %q: output has mode %04o, want %04o", tt.umask, tt.perm, info.Mode().Perm(), tt.want)
+		}
+	}
+
+	for _, perm := range []string{"", "rw", "0800", "01777"} {
+		_, err := parsePerm(perm)
+		if err == nil {
+			t.Fatalf("-perm %q was accepted", perm)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// errStopped is returned by batch.record for a failure stopping the batch.
+var errStopped = errors.New("stopped at the first failure")
+
+// batch tracks the files of a command run over many files, printing the
+// outcome of each one, and whether to go on after a file fails.
+type batch struct {
+	keepGoing bool
+	ok        int
+	failed    int
+	skipped   int
+}
+
+// record records the outcome of the file name, returning errStopped if
+// it failed and the batch doesn't keep going after failures.
+func (b *batch) record(name string, err error) error {
+	if err == nil {
+		fmt.Printf("OK   %s\n", name)
+		b.ok++
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "FAIL %s: %v\n", name, err)
+	b.failed++
+	if !b.keepGoing {
+		return errStopped
+	}
+	return nil
+}
+
+// skip records a file left out of the batch.
+func (b *batch) skip() {
+	b.skipped++
+}
+
+// summary prints the number of files of every outcome, returning an error
+// if any file failed, or if there were none to process.
+func (b *batch) summary() error {
+	fmt.Printf("%d OK, %d FAIL, %d skipped\n", b.ok, b.failed, b.skipped)
+	if b.failed > 0 {
+		if !b.keepGoing {
+			return fmt.Errorf("%w (-keep-going goes on past failures)", errStopped)
+		}
+		return fmt.Errorf("%d of %d files failed", b.failed, b.ok+b.failed)
+	}
+	if b.ok == 0 {
+		return errors.New("no files processed")
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	failure := errors.New("failure")
+
+	b := batch{}
+	err := b.record("good", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.record("bad", failure)
+	if err != errStopped {
+		t.Fatalf("failure without -keep-going: got error %v, want errStopped", err)
+	}
+	err = b.summary()
+	if !errors.Is(err, errStopped) {
+		t.Fatalf("summary without -keep-going: got error %v, want errStopped", err)
+	}
+
+	b = batch{keepGoing: true}
+	for _, err := range []error{nil, failure, nil, failure, nil} {
+		err = b.record("file", err)
+		if err != nil {
+			t.Fatalf("failure with -keep-going: got error %v", err)
+		}
+	}
+	b.skip()
+	if b.ok != 3 || b.failed != 2 || b.skipped != 1 {
+		t.Fatalf("counted %d OK, %d FAIL, %d skipped, want 3, 2, 1", b.ok, b.failed, b.skipped)
+	}
+	err = b.summary()
+	if err == nil || err.Error() != "2 of 5 files failed" {
+		t.Fatalf("summary with -keep-going: got error %v", err)
+	}
+
+	b = batch{keepGoing: true}
+	err = b.record("good", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.summary()
+	if err != nil {
+		t.Fatalf("summary without failures: got error %v", err)
+	}
+
+	b = batch{}
+	b.skip()
+	err = b.summary()
+	if err == nil {
+		t.Fatal("a batch without any file succeeded")
+	}
+}
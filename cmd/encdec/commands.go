@@ -0,0 +1,417 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bernardo1r/encdec"
+	"golang.org/x/term"
+)
+
+// commands are the subcommands of encdec, each one parsing its own flags
+// from the arguments following its name.
+var commands = map[string]func(args []string) error{
+	"encrypt": encryptCommand,
+	"decrypt": decryptCommand,
+	"info":    infoCommand,
+	"verify":  verifyCommand,
+}
+
+const commandsUsage = "Commands:\n\n" +
+	"    encdec encrypt [options...] INPUT_FILE OUTPUT_FILE\n" +
+	"    encdec decrypt [options...] INPUT_FILE OUTPUT_FILE\n" +
+	"    encdec decrypt -C DIR [options...] INPUT_FILE\n" +
+	"    encdec info [-encoding ENCODING] [-header FILE] INPUT_FILE\n" +
+	"    encdec verify [options...] INPUT_FILE\n" +
+	"    encdec verify -r [options...] DIR\n\n" +
+	"Run a command with -h to list its options.\n"
+
+// passwordFlags are the flags giving the password and the pepper.
+type passwordFlags struct {
+	pass      string
+	passFile  string
+	passFD    int
+	askPass   bool
+	pepperEnv string
+
+	// tty prompts for the password on the terminal rather than
+	// stdin, as the input is read from stdin.
+	tty bool
+
+	// typed is set once the password is typed on the terminal as
+	// stdin, so it can be asked for again.
+	typed bool
+}
+
+// maxPasswordAttempts is the number of times a password typed on the
+// terminal is asked for before a wrong one is given up on.
+const maxPasswordAttempts = 3
+
+func (p *passwordFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&p.pass, "p", "", "password, if not provided will be prompted")
+	fs.StringVar(&p.passFile, "p-file", "", "read the password from the first line of `FILE`, which may be /dev/fd/N")
+	fs.IntVar(&p.passFD, "p-fd", -1, "read the password from the first line of the open file descriptor `N`")
+	fs.BoolVar(&p.askPass, "ask-pass", false, "always prompt for the password")
+	fs.StringVar(&p.pepperEnv, "pepper-env", "", "read the pepper from the environment variable `NAME`")
+}
+
+// read returns the password given by the flags of fs, prompting for it,
+// twice if repeat is true, unless given by -p, and sets the pepper of opts.
+func (p *passwordFlags) read(fs *flag.FlagSet, repeat bool, opts *options) ([]byte, error) {
+	if p.pepperEnv != "" {
+		opts.pepper = []byte(os.Getenv(p.pepperEnv))
+		if len(opts.pepper) == 0 {
+			return nil, fmt.Errorf("pepper environment variable %s is not set", p.pepperEnv)
+		}
+	}
+
+	// An explicit -p, even if empty, is told apart from its absence,
+	// so an empty password can still be given on purpose.
+	var passSet, fdSet bool
+	var sources []string
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "p":
+			passSet = true
+		case "p-fd":
+			fdSet = true
+		case "p-file", "ask-pass":
+		default:
+			return
+		}
+		sources = append(sources, "-"+f.Name)
+	})
+	if len(sources) > 1 {
+		return nil, fmt.Errorf("%s can't be combined", strings.Join(sources, " and "))
+	}
+	switch {
+	case passSet:
+		return []byte(p.pass), nil
+	case fdSet:
+		// The input read from stdin would be taken as the password.
+		if p.tty && p.passFD == 0 {
+			return nil, errors.New("-p-fd 0 can't be used when reading stdin")
+		}
+		return readPasswordFD(p.passFD)
+	case p.passFile != "":
+		return readPasswordFile(p.passFile)
+	}
+
+	return p.prompt(repeat)
+}
+
+// prompt prompts for the password, twice if repeat is true.
+func (p *passwordFlags) prompt(repeat bool) ([]byte, error) {
+	var password []byte
+	var err error
+	if p.tty {
+		password, err = encdec.ReadPasswordTTY(passwordMessage, repeat)
+	} else {
+		password, err = encdec.ReadPasswordFrom(os.Stdin, os.Stderr, passwordMessage, repeat)
+		p.typed = term.IsTerminal(int(os.Stdin.Fd()))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password: %w", err)
+	}
+	if len(password) == 0 {
+		return nil, errors.New("password not provided")
+	}
+
+	return password, nil
+}
+
+// retry calls run with password, asking for the password again while run
+// returns ErrWrongKey, up to maxPasswordAttempts in all. Passwords that
+// weren't typed on the terminal are never asked for again, so scripts
+// fail at once instead of waiting for input. Neither are they when the
+// input is read from stdin, which can't be read twice.
+func (p *passwordFlags) retry(password []byte, run func(password []byte) error) error {
+	for attempt := 1; ; attempt++ {
+		err := run(password)
+		if !errors.Is(err, encdec.ErrWrongKey) || !p.typed || attempt == maxPasswordAttempts {
+			return err
+		}
+
+		fmt.Fprintln(os.Stderr, "Wrong password, try again.")
+		password, err = p.prompt(false)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// registerOptions registers the flags of opts shared by every command
+// reading or writing encrypted files.
+func registerOptions(fs *flag.FlagSet, opts *options) {
+	fs.BoolVar(&opts.parallel, "parallel", false, "use the pipelined implementation")
+	fs.StringVar(&opts.encoding, "encoding", "", "encoding of the encrypted file: base32, base64 or hex")
+	fs.StringVar(&opts.header, "header", "", "keep the header in `FILE` instead of the encrypted file")
+	fs.BoolVar(&opts.progress, "progress", false, "show the progress on stderr")
+	fs.Func("size", "hint of the input `SIZE`, in bytes or with a unit, such as 10G or 4GiB, to show the progress of piped input as a percentage", func(s string) error {
+		size, err := encdec.ParseSize(s)
+		opts.size = size
+		return err
+	})
+	opts.perm = defaultPerm
+	fs.Func("perm", "octal permissions `MODE` of the output file, 0600 by default, set regardless of the umask", func(s string) error {
+		perm, err := parsePerm(s)
+		opts.perm = perm
+		return err
+	})
+}
+
+// registerMaxArgon registers the flag bounding the key derivation of the
+// params read from a header in opts.
+func registerMaxArgon(fs *flag.FlagSet, opts *options) {
+	fs.DurationVar(&opts.maxArgon, "max-argon-time", defaultMaxArgon, "refuse headers whose key derivation is estimated to take longer than `DURATION`, 0 for no limit")
+}
+
+// registerChunkSize registers the flag setting the chunk size to encrypt
+// with in opts.
+func registerChunkSize(fs *flag.FlagSet, opts *options) {
+	fs.Func("b", "chunk `SIZE` in bytes or with a unit, such as 1MiB, 64KiB by default", func(s string) error {
+		chunk, err := encdec.ParseChunkSize(s)
+		opts.chunk = chunk
+		return err
+	})
+}
+
+// checkFiles returns an error if any of the files given is the same as
+// another, so no file is overwritten while being read.
+func checkFiles(inputFile string, outputFile string, headerFile string) error {
+	if inputFile == stdinName {
+		inputFile = ""
+	}
+	if sameFile(inputFile, outputFile) {
+		return errors.New("input and output are the same file")
+	}
+	if headerFile != "" && (sameFile(inputFile, headerFile) || sameFile(outputFile, headerFile)) {
+		return errors.New("header file is the same as the input or output file")
+	}
+	return nil
+}
+
+// parseArgs parses args with fs, returning the positional arguments,
+// which must be as many as names.
+func parseArgs(fs *flag.FlagSet, args []string, names ...string) ([]string, error) {
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	return checkArgs(fs, names...)
+}
+
+// checkArgs returns the positional arguments left by fs, which must be as
+// many as names.
+func checkArgs(fs *flag.FlagSet, names ...string) ([]string, error) {
+	if fs.NArg() != len(names) {
+		return nil, fmt.Errorf("usage: encdec %s [options...] %s", fs.Name(), strings.Join(names, " "))
+	}
+	return fs.Args(), nil
+}
+
+func encryptCommand(args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	var passFlags passwordFlags
+	var opts options
+	passFlags.register(fs)
+	registerOptions(fs, &opts)
+	fs.StringVar(&opts.label, "label", "", "store `TEXT` in the header, unencrypted")
+	registerChunkSize(fs, &opts)
+	fs.BoolVar(&opts.digest, "digest", false, "store the digest of the input in the header, to check it when decrypting")
+	fs.UintVar(&opts.threads, "threads", 0, "Argon2 threads, by default the number of CPUs up to 8")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "print the size of the output and the key derivations, without encrypting")
+	files, err := parseArgs(fs, args, "INPUT_FILE", "OUTPUT_FILE")
+	if err != nil {
+		return err
+	}
+	err = checkFiles(files[0], files[1], opts.header)
+	if err != nil {
+		return err
+	}
+	if opts.dryRun {
+		return estimate(files[0], &opts)
+	}
+
+	passFlags.tty = files[0] == stdinName
+	password, err := passFlags.read(fs, true, &opts)
+	if err != nil {
+		return err
+	}
+	err = encrypt(password, files[0], files[1], &opts)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt: %w", err)
+	}
+	return nil
+}
+
+func decryptCommand(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	var passFlags passwordFlags
+	var opts options
+	passFlags.register(fs)
+	registerOptions(fs, &opts)
+	fs.BoolVar(&opts.guard, "guard", false, "delay after repeated wrong passwords, counted in a file next to the input")
+	registerMaxArgon(fs, &opts)
+	extractDir := fs.String("C", "", "extract the tar archive, optionally gzip compressed, decrypted from INPUT_FILE into the new directory `DIR`")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	var files []string
+	if *extractDir != "" {
+		files, err = checkArgs(fs, "INPUT_FILE")
+		files = append(files, "")
+	} else {
+		files, err = checkArgs(fs, "INPUT_FILE", "OUTPUT_FILE")
+	}
+	if err != nil {
+		return err
+	}
+	err = checkFiles(files[0], files[1], opts.header)
+	if err != nil {
+		return err
+	}
+
+	passFlags.tty = files[0] == stdinName
+	password, err := passFlags.read(fs, false, &opts)
+	if err != nil {
+		return err
+	}
+	err = passFlags.retry(password, func(password []byte) error {
+		if *extractDir != "" {
+			return extract(password, files[0], *extractDir, &opts)
+		}
+		return decrypt(password, files[0], files[1], &opts)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return nil
+}
+
+// infoCommand prints the params in the header of an encrypted file,
+// which doesn't need the password.
+func infoCommand(args []string) error {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	var opts options
+	fs.StringVar(&opts.encoding, "encoding", "", "encoding of the encrypted file: base32, base64 or hex")
+	fs.StringVar(&opts.header, "header", "", "read the header from `FILE` instead of the encrypted file")
+	files, err := parseArgs(fs, args, "INPUT_FILE")
+	if err != nil {
+		return err
+	}
+
+	params, err := readParams(files[0], &opts)
+	if err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	fmt.Println(params)
+	return nil
+}
+
+// verifyCommand decrypts an encrypted file without writing the result,
+// checking that it is intact and the password is right. With -r it does
+// so for every encrypted file under a directory, with the same password.
+func verifyCommand(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	var passFlags passwordFlags
+	var opts options
+	passFlags.register(fs)
+	fs.StringVar(&opts.encoding, "encoding", "", "encoding of the encrypted file: base32, base64 or hex")
+	fs.StringVar(&opts.header, "header", "", "read the header from `FILE` instead of the encrypted file")
+	fs.BoolVar(&opts.guard, "guard", false, "delay after repeated wrong passwords, counted in a file next to the input")
+	registerMaxArgon(fs, &opts)
+	recursive := fs.Bool("r", false, "verify every encrypted file under the directory INPUT_FILE")
+	fs.BoolVar(&opts.keepGoing, "keep-going", false, "with -r, go on verifying the other files after one fails")
+	fs.StringVar(&opts.manifest, "manifest", "", "with -r, record every file verified in `FILE`, as JSON lines")
+	files, err := parseArgs(fs, args, "INPUT_FILE")
+	if err != nil {
+		return err
+	}
+	if *recursive && opts.header != "" {
+		return errors.New("-header can't be used with -r, as every file has its own header")
+	}
+	if !*recursive && (opts.keepGoing || opts.manifest != "") {
+		return errors.New("-keep-going and -manifest can only be used with -r")
+	}
+
+	password, err := passFlags.read(fs, false, &opts)
+	if err != nil {
+		return err
+	}
+	if *recursive {
+		return verifyTree(password, files[0], &opts)
+	}
+	err = verify(password, files[0], &opts)
+	if err != nil {
+		return fmt.Errorf("failed to verify: %w", err)
+	}
+	fmt.Fprintln(os.Stderr, "OK")
+	return nil
+}
+
+// readParams reads the params of the encrypted inputFile, from the header
+// file of opts if any.
+func readParams(inputFile string, opts *options) (*encdec.Params, error) {
+	if opts.header != "" {
+		return readHeaderFile(opts.header)
+	}
+
+	src, err := os.Open(inputFile)
+	if err != nil {
+		return nil, fmt.Errorf("input file: %w", err)
+	}
+	defer src.Close()
+
+	in, err := decodeReader(src, opts.encoding)
+	if err != nil {
+		return nil, err
+	}
+	return encdec.ParseHeader(in)
+}
+
+func verify(password []byte, inputFile string, opts *options) error {
+	var params *encdec.Params
+	var err error
+	if opts.header != "" {
+		params, err = readHeaderFile(opts.header)
+		if err != nil {
+			return fmt.Errorf("header file: %w", err)
+		}
+	}
+
+	src, err := os.Open(inputFile)
+	if err != nil {
+		return fmt.Errorf("input file: %w", err)
+	}
+	defer src.Close()
+
+	in, err := decodeReader(src, opts.encoding)
+	if err != nil {
+		return err
+	}
+	if params == nil {
+		params, err = encdec.ParseHeader(in)
+		if err != nil {
+			return err
+		}
+	}
+	return verifyStream(password, inputFile, in, params, opts)
+}
+
+// runCommand runs the command named by args[0], if there is one,
+// reporting whether it did.
+func runCommand(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		return false, nil
+	}
+	return true, command(args[1:])
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bernardo1r/encdec"
+)
+
+// TestMain runs main instead of the tests when ENCDEC_TEST_MAIN is set, so
+// the tests can run the command line as a process of its own.
+func TestMain(m *testing.M) {
+	if os.Getenv("ENCDEC_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the command line with args, returning its output, and
+// failing t if it fails.
+func runMain(t *testing.T, args ...string) []byte {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "ENCDEC_TEST_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("encdec %q: %v\n%s", args, err, out)
+	}
+	return out
+}
+
+// writeEncrypted writes plaintext encrypted with password "password" to
+// name in dir, returning its path. The key derivation is cheap enough
+// for tests, unlike the one of the params encrypt uses.
+func writeEncrypted(t *testing.T, dir string, name string, plaintext []byte) string {
+	t.Helper()
+	params := &encdec.Params{ArgonMemory: 64, ArgonThreads: 1, ChunkSize: 64}
+	blob, err := encdec.EncryptBytes([]byte("password"), plaintext, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	err = os.WriteFile(path, blob, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// checkFile fails t if the file at path doesn't hold want.
+func checkFile(t *testing.T, path string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("%s holds %q, want %q", path, got, want)
+	}
+}
+
+func TestPepperEnv(t *testing.T) {
+	parse := func(args ...string) (*passwordFlags, *flag.FlagSet) {
+		t.Helper()
+		var p passwordFlags
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		p.register(fs)
+		err := fs.Parse(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &p, fs
+	}
+
+	t.Setenv("ENCDEC_TEST_PEPPER", "pepper")
+	p, fs := parse("-p", "password", "-pepper-env", "ENCDEC_TEST_PEPPER")
+	var opts options
+	password, err := p.read(fs, false, &opts)
+	if err != nil || string(password) != "password" || string(opts.pepper) != "pepper" {
+		t.Fatalf("got password %q and pepper %q, %v", password, opts.pepper, err)
+	}
+
+	// A pepper missing from the environment is an error, rather than
+	// silently deriving the key without it.
+	p, fs = parse("-p", "password", "-pepper-env", "ENCDEC_TEST_NO_PEPPER")
+	_, err = p.read(fs, false, &options{})
+	if err == nil {
+		t.Fatal("a missing pepper was accepted")
+	}
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	plaintext := bytes.Repeat([]byte("plaintext"), 20)
+	input := writeEncrypted(t, dir, "input.enc", plaintext)
+
+	output := filepath.Join(dir, "output")
+	ok, err := runCommand([]string{"decrypt", "-p", "password", input, output})
+	if !ok || err != nil {
+		t.Fatalf("decrypt: got %v, %v", ok, err)
+	}
+	checkFile(t, output, plaintext)
+
+	ok, err = runCommand([]string{"verify", "-p", "password", input})
+	if !ok || err != nil {
+		t.Fatalf("verify: got %v, %v", ok, err)
+	}
+	_, err = runCommand([]string{"verify", "-p", "wrong", input})
+	if !errors.Is(err, encdec.ErrWrongKey) {
+		t.Fatalf("verify with a wrong password: got error %v, want ErrWrongKey", err)
+	}
+
+	// A wrong number of arguments is a usage error, rather than a file
+	// being taken for another.
+	_, err = runCommand([]string{"decrypt", "-p", "password", input})
+	if err == nil {
+		t.Fatal("decrypt without an output file succeeded")
+	}
+	_, err = runCommand([]string{"info", input, output})
+	if err == nil {
+		t.Fatal("info with two files succeeded")
+	}
+
+	// Anything else is left to the legacy flags.
+	for _, args := range [][]string{nil, {"-d"}, {"encrypted"}} {
+		ok, _ = runCommand(args)
+		if ok {
+			t.Fatalf("%q was run as a command", args)
+		}
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	dir := t.TempDir()
+	plaintext := []byte("plaintext")
+	input := writeEncrypted(t, dir, "input.enc", plaintext)
+
+	output := filepath.Join(dir, "legacy")
+	runMain(t, "-d", "-p", "password", input, output)
+	checkFile(t, output, plaintext)
+
+	// Decrypting is the default.
+	output = filepath.Join(dir, "default")
+	runMain(t, "-p", "password", input, output)
+	checkFile(t, output, plaintext)
+
+	output = filepath.Join(dir, "command")
+	runMain(t, "decrypt", "-p", "password", input, output)
+	checkFile(t, output, plaintext)
+
+	out := runMain(t, "info", input)
+	if !bytes.Contains(out, []byte(" chunk=64 B ")) {
+		t.Fatalf("info printed %q, without the chunk size of the header", out)
+	}
+}
+
+func TestPasswordFromStdin(t *testing.T) {
+	dir := t.TempDir()
+	plaintext := []byte("plaintext")
+	input := writeEncrypted(t, dir, "input.enc", plaintext)
+	output := filepath.Join(dir, "output")
+
+	// With stdin not a terminal, the password is read from it as a line,
+	// and the prompt goes to stderr, keeping stdout clean.
+	cmd := exec.Command(os.Args[0], "-d", input, output)
+	cmd.Env = append(os.Environ(), "ENCDEC_TEST_MAIN=1")
+	cmd.Stdin = strings.NewReader("password\n")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, stderr.Bytes())
+	}
+	checkFile(t, output, plaintext)
+	if stdout.Len() != 0 {
+		t.Fatalf("wrote %q to stdout", stdout.Bytes())
+	}
+	if !strings.Contains(stderr.String(), passwordMessage) {
+		t.Fatalf("no prompt on stderr, got %q", stderr.Bytes())
+	}
+}
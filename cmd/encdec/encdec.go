@@ -1,35 +1,152 @@
 package main
 
 import (
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
+	"runtime"
 	"runtime/debug"
+	"strconv"
+	"time"
 
 	"github.com/bernardo1r/encdec"
+	"golang.org/x/term"
 )
 
 var Version string
 
 const usage = "Usage: encdec [options...] [INPUT_FILE] [OUTPUT_FILE]\n" +
-	"Default option is to decrypt\n\n" +
+	"       encdec -d -C DIR [options...] [INPUT_FILE]\n" +
+	"Default option is to decrypt. An INPUT_FILE of - reads stdin,\n" +
+	"prompting for the password on the terminal. Otherwise the prompt is\n" +
+	"written to stderr, and if stdin is not a terminal, such as a pipe, the\n" +
+	"password is read from it as a line of text. A wrong password typed\n" +
+	"when decrypting is asked for again, up to 3 times in all\n\n" +
 	"Options:\n\n" +
 	"    -v    diplay version number\n" +
 	"    -p    password, if not provided will be prompted\n" +
+	"    -p-file FILE    read the password from the first line of FILE,\n" +
+	"                    which may be /dev/fd/N\n" +
+	"    -p-fd N    read the password from the first line of the open\n" +
+	"               file descriptor N\n" +
+	"    -ask-pass    always prompt for the password\n" +
 	"    -d    decrypt\n" +
-	"    -e    encrypt\n"
+	"    -e    encrypt\n" +
+	"    -C DIR    extract the tar archive, optionally gzip compressed,\n" +
+	"              decrypted from INPUT_FILE into the new directory DIR\n" +
+	"    -parallel    use the pipelined implementation\n" +
+	"    -encoding    encoding of the encrypted file: base32, base64 or hex\n" +
+	"    -header FILE    keep the header in FILE instead of the encrypted file\n" +
+	"    -label TEXT    store TEXT in the header, unencrypted, when encrypting\n" +
+	"    -b SIZE    chunk size when encrypting, such as 1MiB, 64KiB by default\n" +
+	"    -threads N    Argon2 threads when encrypting, by default the number\n" +
+	"                  of CPUs up to 8, stored in the header for decrypting\n" +
+	"    -digest    store the digest of the input in the header when encrypting,\n" +
+	"               to check it when decrypting\n" +
+	"    -pepper-env NAME    read the pepper from the environment variable NAME\n" +
+	"    -progress    show the progress on stderr\n" +
+	"    -perm MODE    octal permissions of OUTPUT_FILE, 0600 by default,\n" +
+	"                  set regardless of the umask\n" +
+	"    -guard    delay decrypting INPUT_FILE after repeated wrong passwords,\n" +
+	"              counted in a file next to it\n" +
+	"    -max-argon-time DURATION    refuse to decrypt files whose key derivation\n" +
+	"                                is estimated to take longer, 1m by default,\n" +
+	"                                0 for no limit\n" +
+	"    -size N    size hint of the input, such as 10G, to show the progress\n" +
+	"               of piped input as a percentage\n" +
+	"    -print-key    debugging: print the key of INPUT_FILE in hex to stderr,\n" +
+	"                  which must not be a terminal, without decrypting\n\n" +
+	"Sizes are in bytes, or with a unit: K, M, G and T for powers of 1000,\n" +
+	"KiB, MiB, GiB and TiB for powers of 1024\n\n" +
+	commandsUsage
 
 const passwordMessage = "Password: "
 
-func openFiles(inputFile string, outputFile string) (*os.File, *os.File, error) {
+// maxArgonThreads caps the Argon2 threads chosen from the number of CPUs,
+// as more threads only help while there is memory bandwidth to spare.
+const maxArgonThreads = 8
+
+// options holds the command line options affecting how files are
+// encrypted and decrypted.
+type options struct {
+	parallel bool
+	encoding string
+	pepper   []byte
+	header   string
+	label    string
+	chunk    int64
+	digest   bool
+	progress bool
+	size     int64
+	guard    bool
+	threads  uint
+	dryRun   bool
+	perm     os.FileMode
+
+	// keepGoing carries on with the other files of a batch after
+	// one of them fails.
+	keepGoing bool
+
+	// manifest is the file recording every file of a batch.
+	manifest string
+
+	// maxArgon bounds the estimated duration of the key derivation
+	// of the params read from a header, zero for no bound.
+	maxArgon time.Duration
+}
+
+// defaultMaxArgon is the default bound of options.maxArgon, far above
+// what the params chosen when encrypting take, while refusing headers
+// that would keep the derivation running for hours.
+const defaultMaxArgon = time.Minute
+
+// checkParams refuses params read from a header whose key derivation is
+// estimated to take longer than opts allow, before it is started.
+func checkParams(params *encdec.Params, opts *options) error {
+	policy := &encdec.Policy{MaxArgonDuration: opts.maxArgon}
+	return policy.CheckParams(params)
+}
+
+// defaultPerm is the permissions of the output files, only readable by
+// their owner, as they hold either secrets or their plaintext.
+const defaultPerm os.FileMode = 0600
+
+// parsePerm parses the octal permissions s, such as 0640.
+func parsePerm(s string) (os.FileMode, error) {
+	perm, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || perm > uint64(os.ModePerm) {
+		return 0, fmt.Errorf("invalid permissions %q", s)
+	}
+	return os.FileMode(perm), nil
+}
+
+// openInput opens inputFile, which is stdin if named stdinName.
+func openInput(inputFile string) (*os.File, error) {
+	if inputFile == stdinName {
+		return os.Stdin, nil
+	}
+
 	src, err := os.Open(inputFile)
 	if err != nil {
-		return nil, nil, fmt.Errorf("input file: %w", err)
+		return nil, fmt.Errorf("input file: %w", err)
+	}
+	return src, nil
+}
+
+// openFilesAtomic opens the input file and creates the output file with
+// the permissions perm, which is only replaced once committed.
+func openFilesAtomic(inputFile string, outputFile string, perm os.FileMode) (*os.File, *atomicFile, error) {
+	src, err := openInput(inputFile)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	dst, err := os.Create(outputFile)
+	dst, err := createAtomic(outputFile, perm)
 	if err != nil {
 		src.Close()
 		return nil, nil, fmt.Errorf("output file: %w", err)
@@ -38,8 +155,76 @@ func openFiles(inputFile string, outputFile string) (*os.File, *os.File, error)
 	return src, dst, nil
 }
 
-func encrypt(password []byte, inputFile string, outputFile string) (err error) {
-	src, dst, err := openFiles(inputFile, outputFile)
+// sameFile reports whether inputFile and outputFile are the same file,
+// following symlinks and comparing the file identity given by the OS, such
+// as device and inode on Unix, rather than the paths. A missing file is
+// never the same as another.
+func sameFile(inputFile string, outputFile string) bool {
+	inputInfo, err := os.Stat(inputFile)
+	if err != nil {
+		return false
+	}
+	outputInfo, err := os.Stat(outputFile)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(inputInfo, outputInfo)
+}
+
+// deriveKey derives the key from password and params. As it can take
+// several seconds, a spinner is shown meanwhile if stderr is a terminal.
+func deriveKey(password []byte, params *encdec.Params, opts *options) ([]byte, error) {
+	result := encdec.KeyWithPepperAsync(password, opts.pepper, params)
+	if !term.IsTerminal(int(os.Stderr.Fd())) {
+		r := <-result
+		return r.Key, r.Err
+	}
+
+	const spinner = `|/-\`
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		select {
+		case r := <-result:
+			fmt.Fprint(os.Stderr, "\r                \r")
+			return r.Key, r.Err
+		case <-ticker.C:
+			fmt.Fprintf(os.Stderr, "\rDeriving key %c", spinner[i%len(spinner)])
+		}
+	}
+}
+
+// argonThreads returns the Argon2 threads to encrypt with: threads if not
+// zero, or else the number of CPUs up to maxArgonThreads. Unlike the
+// default of the library, it depends on the machine, which is fine as
+// the threads are stored in the header.
+func argonThreads(threads uint) uint8 {
+	if threads == 0 {
+		threads = uint(min(runtime.NumCPU(), maxArgonThreads))
+	}
+	return uint8(min(threads, math.MaxUint8))
+}
+
+// encryptParams returns the params to encrypt with, as set by opts.
+func encryptParams(opts *options) encdec.Params {
+	// Passwords are normalized, so they can be typed on any system.
+	return encdec.Params{
+		Label:         opts.label,
+		ChunkSize:     opts.chunk,
+		Normalization: encdec.NormalizationNFC,
+		ArgonThreads:  argonThreads(opts.threads),
+	}
+}
+
+func encrypt(password []byte, inputFile string, outputFile string, opts *options) (err error) {
+	// The digest is computed in a pass of its own over the input,
+	// which can't be read twice from stdin.
+	if opts.digest && inputFile == stdinName {
+		return errors.New("-digest can't be used when reading stdin")
+	}
+
+	src, dst, err := openFilesAtomic(inputFile, outputFile, opts.perm)
 	if err != nil {
 		return err
 	}
@@ -50,18 +235,37 @@ func encrypt(password []byte, inputFile string, outputFile string) (err error) {
 			err = err2
 		}
 
-		err2 = dst.Close()
+		if err != nil {
+			dst.abort()
+			return
+		}
+		err = dst.commit()
+	}()
+
+	out, err := encodeWriter(dst, opts.encoding)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := out.Close()
 		if err2 != nil && err == nil {
 			err = err2
 		}
+	}()
 
+	params := encryptParams(opts)
+	if opts.digest {
+		params.Digest, err = encdec.Digest(src)
 		if err != nil {
-			os.Remove(outputFile)
+			return err
 		}
-	}()
+		_, err = src.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+	}
 
-	var params encdec.Params
-	key, err := encdec.Key(password, &params)
+	key, err := deriveKey(password, &params, opts)
 	if err != nil {
 		return err
 	}
@@ -71,12 +275,39 @@ func encrypt(password []byte, inputFile string, outputFile string) (err error) {
 		return err
 	}
 
-	_, err = dst.Write(header)
-	if err != nil {
-		return err
+	if opts.header != "" {
+		err = os.WriteFile(opts.header, header, 0600)
+		if err != nil {
+			return fmt.Errorf("header file: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				os.Remove(opts.header)
+			}
+		}()
+	} else {
+		_, err = out.Write(header)
+		if err != nil {
+			return err
+		}
+	}
+
+	in := io.Reader(src)
+	if opts.progress {
+		progress := newProgressReader(src, opts.size)
+		in = progress
+		defer func() {
+			if err == nil {
+				progress.finish()
+			}
+		}()
 	}
 
-	writer, err := encdec.NewWriter(key, dst, &params)
+	if opts.parallel {
+		return encdec.Encrypt(key, in, out, &params)
+	}
+
+	writer, err := encdec.NewWriter(key, out, &params)
 	if err != nil {
 		return err
 	}
@@ -87,12 +318,35 @@ func encrypt(password []byte, inputFile string, outputFile string) (err error) {
 		}
 	}()
 
-	_, err = io.Copy(writer, src)
+	_, err = io.Copy(writer, in)
 	return err
 }
 
-func decrypt(password []byte, inputFile string, outputFile string) (err error) {
-	src, dst, err := openFiles(inputFile, outputFile)
+// readHeaderFile parses the header kept in the file name.
+func readHeaderFile(name string) (*encdec.Params, error) {
+	header, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return encdec.ParseHeaderBytes(header)
+}
+
+func decrypt(password []byte, inputFile string, outputFile string, opts *options) (err error) {
+	// The header file is parsed before the output is created,
+	// so a bad header leaves no trace.
+	var params *encdec.Params
+	if opts.header != "" {
+		params, err = readHeaderFile(opts.header)
+		if err != nil {
+			return fmt.Errorf("header file: %w", err)
+		}
+	}
+
+	// The plaintext is only moved into place once the last chunk is
+	// authenticated, so a tampered or truncated file leaves no partial
+	// plaintext that could be mistaken for the whole.
+	src, dst, err := openFilesAtomic(inputFile, outputFile, opts.perm)
 	if err != nil {
 		return err
 	}
@@ -103,27 +357,67 @@ func decrypt(password []byte, inputFile string, outputFile string) (err error) {
 			err = err2
 		}
 
-		err2 = dst.Close()
-		if err2 != nil && err == nil {
-			err = err2
+		if err != nil {
+			dst.abort()
+			return
 		}
+		err = dst.commit()
+	}()
 
+	return decryptStream(password, inputFile, src, params, dst, opts)
+}
+
+// decryptStream decrypts src, read from inputFile, into dst. If params is
+// nil, they are parsed from the header at the start of src.
+func decryptStream(password []byte, inputFile string, src *os.File, params *encdec.Params, dst io.Writer, opts *options) (err error) {
+	input := io.Reader(src)
+	if opts.progress {
+		progress := newProgressReader(src, opts.size)
+		input = progress
+		defer func() {
+			if err == nil {
+				progress.finish()
+			}
+		}()
+	}
+
+	in, err := decodeReader(input, opts.encoding)
+	if err != nil {
+		return err
+	}
+
+	if params == nil {
+		params, err = encdec.ParseHeader(in)
 		if err != nil {
-			os.Remove(outputFile)
+			return err
 		}
-	}()
+	}
 
-	params, err := encdec.ParseHeader(src)
+	err = checkParams(params, opts)
 	if err != nil {
 		return err
 	}
 
-	key, err := encdec.Key(password, params)
+	if opts.guard {
+		var guard *attemptGuard
+		guard, err = loadGuard(inputFile, params.Salt)
+		if err != nil {
+			return err
+		}
+		guard.wait()
+		defer guard.done(&err)
+	}
+
+	key, err := deriveKey(password, params, opts)
 	if err != nil {
 		return err
 	}
 
-	reader, err := encdec.NewReader(key, src, params)
+	if opts.parallel {
+		return encdec.Decrypt(key, in, dst, params)
+	}
+
+	reader, err := encdec.NewReader(key, in, params)
 	if err != nil {
 		return err
 	}
@@ -132,20 +426,77 @@ func decrypt(password []byte, inputFile string, outputFile string) (err error) {
 	return err
 }
 
+// printKey derives the key of the encrypted inputFile and prints it in hex
+// to stderr, to compare it against other implementations. As the key
+// decrypts the file, it is never printed to a terminal, where it could be
+// seen or kept in the scrollback.
+func printKey(password []byte, inputFile string, opts *options) error {
+	if term.IsTerminal(int(os.Stderr.Fd())) {
+		return errors.New("refusing to print the key to a terminal, redirect stderr")
+	}
+
+	src, err := os.Open(inputFile)
+	if err != nil {
+		return fmt.Errorf("input file: %w", err)
+	}
+	defer src.Close()
+
+	in, err := decodeReader(src, opts.encoding)
+	if err != nil {
+		return err
+	}
+
+	params, err := encdec.ParseHeader(in)
+	if err != nil {
+		return err
+	}
+	err = checkParams(params, opts)
+	if err != nil {
+		return err
+	}
+
+	key, err := deriveKey(password, params, opts)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(os.Stderr, hex.EncodeToString(key))
+	return err
+}
+
 func main() {
 	log.SetFlags(0)
 
 	if len(os.Args) == 1 {
 		log.Fatalf("%s", usage)
 	}
+	ok, err := runCommand(os.Args[1:])
+	if ok {
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
 	flag.Usage = func() { fmt.Fprintf(os.Stderr, "%s", usage) }
 
-	var versionFlag, decFlag, encFlag bool
-	var pass string
+	var versionFlag, decFlag, encFlag, printKeyFlag bool
+	var extractDir string
+	var passFlags passwordFlags
+	var opts options
 	flag.BoolVar(&versionFlag, "v", false, "display version number")
-	flag.StringVar(&pass, "p", "", "encryption password")
+	passFlags.register(flag.CommandLine)
 	flag.BoolVar(&decFlag, "d", false, "encrypt the input")
 	flag.BoolVar(&encFlag, "e", false, "decrypt the input")
+	flag.StringVar(&extractDir, "C", "", "extract the decrypted tar archive into the new directory")
+	registerOptions(flag.CommandLine, &opts)
+	flag.StringVar(&opts.label, "label", "", "label stored in the header")
+	registerChunkSize(flag.CommandLine, &opts)
+	flag.UintVar(&opts.threads, "threads", 0, "Argon2 threads when encrypting")
+	flag.BoolVar(&opts.guard, "guard", false, "delay after repeated wrong passwords")
+	flag.BoolVar(&opts.digest, "digest", false, "store the digest of the input in the header")
+	flag.BoolVar(&printKeyFlag, "print-key", false, "print the key of the input file, for debugging")
+	registerMaxArgon(flag.CommandLine, &opts)
 	flag.Parse()
 
 	if versionFlag {
@@ -164,7 +515,7 @@ func main() {
 		return
 	}
 
-	if decFlag && encFlag {
+	if decFlag && encFlag || printKeyFlag && (decFlag || encFlag) || extractDir != "" && (encFlag || printKeyFlag) {
 		log.Fatalln("more than one option was passed")
 	}
 
@@ -172,37 +523,45 @@ func main() {
 	if inputFile = flag.Arg(0); inputFile == "" {
 		log.Fatalln("input file not specified")
 	}
-	if outputFile = flag.Arg(1); outputFile == "" {
+	if outputFile = flag.Arg(1); outputFile == "" && !printKeyFlag && extractDir == "" {
 		log.Fatalln("output file not specified")
 	}
-
-	var password []byte
-	var err error
-	if pass != "" {
-		password = []byte(pass)
-	} else {
-		if encFlag {
-			password, err = encdec.ReadPassword(passwordMessage, true)
-		} else {
-			password, err = encdec.ReadPassword(passwordMessage, false)
-		}
-		if err != nil {
-			log.Fatalf("failed to read password: %v\n", err)
-		}
+	if outputFile != "" && extractDir != "" {
+		log.Fatalln("output file can't be given with -C")
+	}
+	err = checkFiles(inputFile, outputFile, opts.header)
+	if err != nil {
+		log.Fatalln(err)
 	}
 
-	if len(password) == 0 {
-		log.Fatalln("password not provided")
+	passFlags.tty = inputFile == stdinName
+	password, err := passFlags.read(flag.CommandLine, encFlag, &opts)
+	if err != nil {
+		log.Fatalln(err)
 	}
 
 	switch {
+	case printKeyFlag:
+		err = printKey(password, inputFile, &opts)
+		if err != nil {
+			err = fmt.Errorf("failed to print key: %w", err)
+		}
 	case encFlag:
-		err = encrypt(password, inputFile, outputFile)
+		err = encrypt(password, inputFile, outputFile, &opts)
 		if err != nil {
 			err = fmt.Errorf("failed to encrypt: %w", err)
 		}
+	case extractDir != "":
+		err = passFlags.retry(password, func(password []byte) error {
+			return extract(password, inputFile, extractDir, &opts)
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to extract: %w", err)
+		}
 	default:
-		err = decrypt(password, inputFile, outputFile)
+		err = passFlags.retry(password, func(password []byte) error {
+			return decrypt(password, inputFile, outputFile, &opts)
+		})
 		if err != nil {
 			err = fmt.Errorf("failed to decrypt: %w", err)
 		}
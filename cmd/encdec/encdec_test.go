@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/bernardo1r/encdec"
+)
+
+func TestArgonThreadsHeader(t *testing.T) {
+	for _, tt := range []struct {
+		threads uint
+		want    uint8
+	}{
+		{0, uint8(min(runtime.NumCPU(), maxArgonThreads))},
+		{1, 1},
+		{3, 3},
+		{1000, 255},
+	} {
+		params := encryptParams(&options{threads: tt.threads})
+		params.Salt = make([]byte, encdec.SaltSize)
+		header, err := params.MarshalHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := encdec.ParseHeader(bytes.NewReader(header))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.ArgonThreads != tt.want {
+			t.Fatalf("-threads %d: header has %d threads, want %d", tt.threads, parsed.ArgonThreads, tt.want)
+		}
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("plaintext"), 50)
+	blob, err := os.ReadFile(writeEncrypted(t, t.TempDir(), "input.enc", plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Clone(blob)
+	tampered[len(tampered)-1] ^= 1
+
+	for name, input := range map[string][]byte{
+		"tampered":  tampered,
+		"truncated": blob[:len(blob)-100],
+	} {
+		for _, parallel := range []bool{false, true} {
+			dir := t.TempDir()
+			inputFile := filepath.Join(dir, "input.enc")
+			err := os.WriteFile(inputFile, input, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Neither a new output file nor an old one is
+			// touched, and no temporary file is left behind.
+			for _, old := range [][]byte{nil, []byte("old")} {
+				outputFile := filepath.Join(dir, "output")
+				if old != nil {
+					err = os.WriteFile(outputFile, old, 0600)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				err = decrypt([]byte("password"), inputFile, outputFile, &options{parallel: parallel})
+				if err == nil {
+					t.Fatalf("%s input, parallel %v: decrypted", name, parallel)
+				}
+				got, err := os.ReadFile(outputFile)
+				if old == nil && !os.IsNotExist(err) {
+					t.Fatalf("%s input, parallel %v: output file written, %v", name, parallel, err)
+				}
+				if old != nil && !bytes.Equal(got, old) {
+					t.Fatalf("%s input, parallel %v: old output file changed to %q, %v", name, parallel, got, err)
+				}
+				entries, err := os.ReadDir(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := 1
+				if old != nil {
+					want = 2
+				}
+				if len(entries) != want {
+					t.Fatalf("%s input, parallel %v: %d files left in the directory, want %d", name, parallel, len(entries), want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// crockford is the base32 alphabet by Douglas Crockford, which avoids
+// letters that are easily confused when transcribing by hand.
+var crockford = base32.NewEncoding("0123456789ABCDEFGHJKMNPQRSTVWXYZ").WithPadding(base32.NoPadding)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// encodeWriter wraps dst so everything written is encoded in the given
+// encoding. The returned writer must be closed to flush any partial block.
+func encodeWriter(dst io.Writer, encoding string) (io.WriteCloser, error) {
+	switch encoding {
+	case "":
+		return nopWriteCloser{dst}, nil
+	case "base32":
+		return base32.NewEncoder(crockford, dst), nil
+	case "base64":
+		return base64.NewEncoder(base64.StdEncoding, dst), nil
+	case "hex":
+		return nopWriteCloser{hex.NewEncoder(dst)}, nil
+	}
+
+	return nil, fmt.Errorf("unknown encoding %q", encoding)
+}
+
+// encodedLen returns the length of n bytes once encoded with encoding.
+func encodedLen(n int64, encoding string) int64 {
+	switch encoding {
+	case "base32":
+		return int64(crockford.EncodedLen(int(n)))
+	case "base64":
+		return int64(base64.StdEncoding.EncodedLen(int(n)))
+	case "hex":
+		return int64(hex.EncodedLen(int(n)))
+	}
+	return n
+}
+
+// decodeReader wraps src so everything read is decoded from the given
+// encoding.
+func decodeReader(src io.Reader, encoding string) (io.Reader, error) {
+	switch encoding {
+	case "":
+		return src, nil
+	case "base32":
+		return base32.NewDecoder(crockford, &transcribedReader{src: src, base32: true}), nil
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, src), nil
+	case "hex":
+		return hex.NewDecoder(&transcribedReader{src: src}), nil
+	}
+
+	return nil, fmt.Errorf("unknown encoding %q", encoding)
+}
+
+// isDecodeError reports whether err comes from data that isn't valid in
+// the encoding read by decodeReader, rather than from reading it.
+func isDecodeError(err error) bool {
+	var base32Err base32.CorruptInputError
+	var base64Err base64.CorruptInputError
+	var hexErr hex.InvalidByteError
+	return errors.As(err, &base32Err) ||
+		errors.As(err, &base64Err) ||
+		errors.As(err, &hexErr) ||
+		errors.Is(err, hex.ErrLength)
+}
+
+// transcribedReader makes text typed or printed by hand decodable,
+// removing whitespace and hyphens. For base32 it also maps lowercase letters
+// to uppercase and the letters O, I and L to the digits they stand for.
+type transcribedReader struct {
+	src    io.Reader
+	base32 bool
+}
+
+func (r *transcribedReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.src.Read(p)
+		j := 0
+		for _, c := range p[:n] {
+			if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '-' {
+				continue
+			}
+			if r.base32 {
+				if 'a' <= c && c <= 'z' {
+					c -= 'a' - 'A'
+				}
+				switch c {
+				case 'O':
+					c = '0'
+				case 'I', 'L':
+					c = '1'
+				}
+			}
+			p[j] = c
+			j++
+		}
+		if j > 0 || err != nil {
+			return j, err
+		}
+	}
+}
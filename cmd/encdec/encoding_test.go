@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncodingRoundTrip(t *testing.T) {
+	for _, encoding := range []string{"", "base32", "base64", "hex"} {
+		// Lengths that end on and off the blocks of base32 and base64.
+		for _, n := range []int{0, 1, 2, 3, 4, 5, 6, 100, 1000} {
+			data := make([]byte, n)
+			for i := range data {
+				data[i] = byte(i * 7)
+			}
+
+			var encoded bytes.Buffer
+			w, err := encodeWriter(&encoded, encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Write in pieces, so partial blocks are carried over.
+			for rest := data; len(rest) > 0; {
+				m := min(3, len(rest))
+				_, err = w.Write(rest[:m])
+				if err != nil {
+					t.Fatal(err)
+				}
+				rest = rest[m:]
+			}
+			err = w.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(encoded.Len()) != encodedLen(int64(n), encoding) {
+				t.Fatalf("%q, %d bytes: encoded to %d bytes, encodedLen returned %d", encoding, n, encoded.Len(), encodedLen(int64(n), encoding))
+			}
+
+			r, err := decodeReader(bytes.NewReader(encoded.Bytes()), encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("%q, %d bytes: decoded %d bytes, %v", encoding, n, len(got), err)
+			}
+		}
+	}
+
+	for _, encoding := range []string{"base58", "BASE64"} {
+		_, err := encodeWriter(io.Discard, encoding)
+		if err == nil {
+			t.Fatalf("encodeWriter accepted %q", encoding)
+		}
+		_, err = decodeReader(strings.NewReader(""), encoding)
+		if err == nil {
+			t.Fatalf("decodeReader accepted %q", encoding)
+		}
+	}
+}
+
+func TestEncodingTranscribed(t *testing.T) {
+	data := []byte("secret backup")
+	var encoded bytes.Buffer
+	w, _ := encodeWriter(&encoded, "base32")
+	w.Write(data)
+	w.Close()
+
+	// Lowercase, grouped by hyphens and lines, with O, I and L typed for
+	// the digits they look like.
+	s := strings.ToLower(encoded.String())
+	s = strings.NewReplacer("0", "o", "1", "l").Replace(s)
+	var transcribed strings.Builder
+	for i, c := range s {
+		if i > 0 && i%4 == 0 {
+			transcribed.WriteString("-")
+		}
+		if i > 0 && i%16 == 0 {
+			transcribed.WriteString("\r\n")
+		}
+		transcribed.WriteRune(c)
+	}
+	r, _ := decodeReader(strings.NewReader(transcribed.String()), "base32")
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("decoded %q from %q, %v", got, transcribed.String(), err)
+	}
+
+	r, _ = decodeReader(strings.NewReader("0123 4567 89ab cdef"), "hex")
+	got, err = io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}) {
+		t.Fatalf("decoded %x, %v", got, err)
+	}
+
+	for _, tt := range []struct{ encoding, data string }{
+		{"base32", "U0"},
+		{"base64", "!!!!"},
+		{"hex", "0g"},
+	} {
+		r, _ := decodeReader(strings.NewReader(tt.data), tt.encoding)
+		_, err := io.ReadAll(r)
+		if !isDecodeError(err) {
+			t.Fatalf("%q in %s: got error %v, want a decode error", tt.data, tt.encoding, err)
+		}
+	}
+}
+
+func TestDecryptEncoded(t *testing.T) {
+	dir := t.TempDir()
+	plaintext := bytes.Repeat([]byte("plaintext"), 20)
+	blob, err := os.ReadFile(writeEncrypted(t, dir, "input.enc", plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, encoding := range []string{"base32", "base64", "hex"} {
+		var encoded bytes.Buffer
+		w, err := encodeWriter(&encoded, encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(blob)
+		w.Close()
+		input := filepath.Join(dir, encoding+".enc")
+		err = os.WriteFile(input, encoded.Bytes(), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		output := filepath.Join(dir, encoding)
+		ok, err := runCommand([]string{"decrypt", "-p", "password", "-encoding", encoding, input, output})
+		if !ok || err != nil {
+			t.Fatalf("%s: got %v, %v", encoding, ok, err)
+		}
+		checkFile(t, output, plaintext)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bernardo1r/encdec"
+)
+
+// estimate prints the length of the file written by encrypting inputFile
+// with opts, and the key derivations it takes, without encrypting it.
+func estimate(inputFile string, opts *options) error {
+	size := opts.size
+	if inputFile != stdinName {
+		info, err := os.Stat(inputFile)
+		if err != nil {
+			return fmt.Errorf("input file: %w", err)
+		}
+		size = info.Size()
+	} else if size == 0 {
+		return errors.New("-dry-run needs -size when reading stdin")
+	}
+
+	params := encryptParams(opts)
+	// The digest is only computed when encrypting,
+	// but its length is known.
+	if opts.digest {
+		params.Digest = make([]byte, sha256.Size)
+	}
+	total, derivations, err := encdec.EstimateJob(size, &params)
+	if err != nil {
+		return err
+	}
+	var header int64
+	if opts.header != "" {
+		data, err := encdec.CiphertextSize(size, &params)
+		if err != nil {
+			return err
+		}
+		header = total - data
+		total = data
+	}
+
+	total = encodedLen(total, opts.encoding)
+	fmt.Printf("output: %s (%d bytes)\n", formatBytes(total), total)
+	if header > 0 {
+		fmt.Printf("header: %d bytes\n", header)
+	}
+	fmt.Printf("key derivations: %d\n", derivations)
+	return nil
+}
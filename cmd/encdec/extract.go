@@ -0,0 +1,253 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bernardo1r/encdec"
+)
+
+// gzipMagic starts every gzip stream, telling a compressed tar archive
+// apart from a plain one.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// extract decrypts the encrypted inputFile, which holds a tar archive,
+// possibly gzip compressed, and extracts it into the directory dir, which
+// must not exist yet. The archive is streamed from the decryption into
+// the files, so it is never held in memory as a whole.
+//
+// As with decrypt, nothing is left behind unless the whole input is
+// authenticated: the archive is extracted into a temporary directory next
+// to dir, only renamed to dir once the last chunk is read.
+func extract(password []byte, inputFile string, dir string, opts *options) (err error) {
+	var params *encdec.Params
+	if opts.header != "" {
+		params, err = readHeaderFile(opts.header)
+		if err != nil {
+			return fmt.Errorf("header file: %w", err)
+		}
+	}
+
+	_, err = os.Lstat(dir)
+	if err == nil {
+		return fmt.Errorf("output directory: %w", os.ErrExist)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("output directory: %w", err)
+	}
+
+	src, err := openInput(inputFile)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), "."+filepath.Base(dir)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("output directory: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tmp)
+		}
+	}()
+
+	// The plaintext is handed over through a pipe, which blocks the
+	// decryption until the archive catches up with it.
+	pr, pw := io.Pipe()
+	decrypted := make(chan error, 1)
+	go func() {
+		err := decryptStream(password, inputFile, src, params, pw, opts)
+		pw.CloseWithError(err)
+		decrypted <- err
+	}()
+
+	err = extractArchive(pr, tmp)
+	pr.CloseWithError(err)
+	// An error of the decryption explains one of the archive, which
+	// only sees the plaintext cut short.
+	err2 := <-decrypted
+	if err2 != nil {
+		return err2
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, dir)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(dir))
+}
+
+// extractArchive extracts the tar archive in r, gzip compressed or not,
+// into the directory dir, reading r until its end.
+func extractArchive(r io.Reader, dir string) error {
+	in := bufio.NewReader(r)
+	magic, err := in.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	archive := io.Reader(in)
+	if slices.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("archive: %w", err)
+		}
+		defer gz.Close()
+		archive = gz
+	}
+
+	err = extractTar(archive, dir)
+	if err != nil {
+		return err
+	}
+
+	// The padding after the end of the archive, and the gzip trailer
+	// holding its checksum, are still read, as is the end of the
+	// plaintext, so the last chunk gets authenticated.
+	_, err = io.Copy(io.Discard, archive)
+	if err != nil {
+		return fmt.Errorf("archive: %w", err)
+	}
+	_, err = io.Copy(io.Discard, in)
+	return err
+}
+
+// extractTar extracts the entries of the tar archive in r into the
+// directory dir: directories, regular files and links. Every path must
+// stay within dir, and symlinks may only point below their own directory,
+// so no entry can be written outside dir, even through a symlink.
+//
+// Permissions are restored without the setuid, setgid and sticky bits,
+// regardless of the umask, and so are modification times. Directories get
+// theirs once every entry is extracted, so a read-only directory doesn't
+// refuse the entries within it.
+func extractTar(r io.Reader, dir string) error {
+	type dirInfo struct {
+		path    string
+		mode    os.FileMode
+		modTime time.Time
+	}
+	var dirs []dirInfo
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("archive: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		name := filepath.FromSlash(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("archive: unsafe path %q", hdr.Name)
+		}
+		path := filepath.Join(dir, name)
+		mode := hdr.FileInfo().Mode().Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0700)
+			dirs = append(dirs, dirInfo{path, mode, hdr.ModTime})
+		case tar.TypeReg:
+			err = extractFile(tr, path, mode, hdr.ModTime)
+		case tar.TypeSymlink:
+			// Without .. in the target, a symlink can't lead out of
+			// its directory, however many symlinks are followed.
+			target := filepath.FromSlash(hdr.Linkname)
+			if !filepath.IsLocal(target) || slices.Contains(strings.Split(filepath.ToSlash(target), "/"), "..") {
+				return fmt.Errorf("archive: unsafe symlink %q to %q", hdr.Name, hdr.Linkname)
+			}
+			err = mkdirParent(path)
+			if err == nil {
+				err = os.Symlink(target, path)
+			}
+		case tar.TypeLink:
+			target := filepath.FromSlash(hdr.Linkname)
+			if !filepath.IsLocal(target) {
+				return fmt.Errorf("archive: unsafe hard link %q to %q", hdr.Name, hdr.Linkname)
+			}
+			err = mkdirParent(path)
+			if err == nil {
+				err = os.Link(filepath.Join(dir, target), path)
+			}
+		default:
+			return fmt.Errorf("archive: unsupported type %q of %q", hdr.Typeflag, hdr.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Inner directories go first, so setting their times doesn't
+	// change those of the directories holding them.
+	for _, d := range slices.Backward(dirs) {
+		err := os.Chmod(d.path, d.mode)
+		if err != nil {
+			return err
+		}
+		err = os.Chtimes(d.path, time.Time{}, d.modTime)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractFile writes the contents of the regular file in r to the new
+// file path, with the permissions mode and the modification time modTime.
+func extractFile(r io.Reader, path string, mode os.FileMode, modTime time.Time) (err error) {
+	err = mkdirParent(path)
+	if err != nil {
+		return err
+	}
+
+	// The file must be new, so an earlier symlink of the same name
+	// isn't followed.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err2 != nil && err == nil {
+			err = err2
+		}
+		if err == nil {
+			err = os.Chtimes(path, time.Time{}, modTime)
+		}
+	}()
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	err = f.Chmod(mode)
+	if err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// mkdirParent creates the directory holding path, for archives that don't
+// list every directory before the entries within it.
+func mkdirParent(path string) error {
+	return os.MkdirAll(filepath.Dir(path), 0700)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeArchive writes a tar archive of headers, each regular file holding
+// its own name, gzip compressed if compress is true.
+func writeArchive(t *testing.T, headers []tar.Header, compress bool) []byte {
+	t.Helper()
+	var buff bytes.Buffer
+	var tw *tar.Writer
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(&buff)
+		tw = tar.NewWriter(gz)
+	} else {
+		tw = tar.NewWriter(&buff)
+	}
+
+	for _, hdr := range headers {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(hdr.Name))
+		}
+		err := tw.WriteHeader(&hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			_, err = tw.Write([]byte(hdr.Name))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	err := tw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		err = gz.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buff.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	modTime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	headers := []tar.Header{
+		{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0750, ModTime: modTime},
+		{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 0640, ModTime: modTime},
+		// A file whose directory isn't listed, with bits that are
+		// dropped.
+		{Name: "other/file", Typeflag: tar.TypeReg, Mode: 04755, ModTime: modTime},
+		{Name: "dir/symlink", Typeflag: tar.TypeSymlink, Linkname: "file"},
+		{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "dir/file"},
+	}
+
+	for _, compress := range []bool{false, true} {
+		archive := writeArchive(t, headers, compress)
+		input := writeEncrypted(t, t.TempDir(), "archive.enc", archive)
+		dir := filepath.Join(t.TempDir(), "out")
+		err := extract([]byte("password"), input, dir, &options{})
+		if err != nil {
+			t.Fatalf("compressed %v: %v", compress, err)
+		}
+
+		for name, want := range map[string]os.FileMode{
+			"dir/file":   0640,
+			"other/file": 0755,
+			"hardlink":   0640,
+		} {
+			path := filepath.Join(dir, name)
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != want {
+				t.Fatalf("%s has mode %04o, want %04o", name, info.Mode().Perm(), want)
+			}
+			if !info.ModTime().Equal(modTime) {
+				t.Fatalf("%s modified at %v, want %v", name, info.ModTime(), modTime)
+			}
+		}
+		checkFile(t, filepath.Join(dir, "dir/file"), []byte("dir/file"))
+		checkFile(t, filepath.Join(dir, "other/file"), []byte("other/file"))
+		checkFile(t, filepath.Join(dir, "dir/symlink"), []byte("dir/file"))
+		info, err := os.Stat(filepath.Join(dir, "dir"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0750 || !info.ModTime().Equal(modTime) {
+			t.Fatalf("dir has mode %04o and time %v", info.Mode().Perm(), info.ModTime())
+		}
+
+		// The output directory must be new.
+		err = extract([]byte("password"), input, dir, &options{})
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatalf("existing directory: got error %v, want ErrExist", err)
+		}
+	}
+}
+
+func TestExtractUnsafe(t *testing.T) {
+	for _, hdr := range []tar.Header{
+		{Name: "../escaped", Typeflag: tar.TypeReg},
+		{Name: "/absolute", Typeflag: tar.TypeReg},
+		{Name: "symlink", Typeflag: tar.TypeSymlink, Linkname: "../escaped"},
+		{Name: "symlink", Typeflag: tar.TypeSymlink, Linkname: "dir/../../escaped"},
+		{Name: "symlink", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "../escaped"},
+		{Name: "fifo", Typeflag: tar.TypeFifo},
+	} {
+		parent := t.TempDir()
+		archive := writeArchive(t, []tar.Header{{Name: "file", Typeflag: tar.TypeReg, Mode: 0600}, hdr}, false)
+		input := writeEncrypted(t, t.TempDir(), "archive.enc", archive)
+		dir := filepath.Join(parent, "out")
+		err := extract([]byte("password"), input, dir, &options{})
+		if err == nil {
+			t.Fatalf("%s to %q: extracted", hdr.Name, hdr.Linkname)
+		}
+
+		// Neither the output directory nor the temporary one is
+		// left behind.
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 0 {
+			t.Fatalf("%s to %q: %d entries left", hdr.Name, hdr.Linkname, len(entries))
+		}
+	}
+}
+
+func TestExtractTampered(t *testing.T) {
+	archive := writeArchive(t, []tar.Header{{Name: "file", Typeflag: tar.TypeReg, Mode: 0600}}, true)
+	input := writeEncrypted(t, t.TempDir(), "archive.enc", archive)
+	blob, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob[len(blob)-1] ^= 1
+	err = os.WriteFile(input, blob, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The archive is read whole before its last chunk fails, which
+	// still leaves nothing behind.
+	parent := t.TempDir()
+	err = extract([]byte("password"), input, filepath.Join(parent, "out"), &options{})
+	if err == nil {
+		t.Fatal("a tampered archive was extracted")
+	}
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("%d entries left", len(entries))
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bernardo1r/encdec"
+)
+
+const (
+	// guardFreeAttempts is the number of failed attempts allowed
+	// before the guard starts delaying the next ones.
+	guardFreeAttempts = 3
+
+	// guardDelay is the delay after the first failed attempt past the
+	// free ones, doubled by every other failure up to guardMaxDelay.
+	guardDelay    = time.Second
+	guardMaxDelay = 5 * time.Minute
+)
+
+// attemptGuard counts the failed attempts at decrypting a file, in a state
+// file next to it, delaying the attempts once there were too many.
+//
+// It is best effort: it only slows down guessing passwords with this
+// program, and whoever can delete or edit the state file, or copy the
+// encrypted file elsewhere, bypasses it. Brute-forcing is only prevented
+// by a strong password and the cost of Argon2.
+type attemptGuard struct {
+	name  string
+	state guardState
+}
+
+// guardState is the content of the state file.
+type guardState struct {
+	// Salt is the salt of the file the failures belong to,
+	// so they are forgotten once the file is replaced.
+	Salt     []byte    `json:"salt"`
+	Failures int       `json:"failures"`
+	Last     time.Time `json:"last"`
+}
+
+// loadGuard loads the guard of inputFile, whose params have salt.
+func loadGuard(inputFile string, salt []byte) (*attemptGuard, error) {
+	if inputFile == stdinName {
+		return nil, errors.New("-guard can't be used when reading stdin")
+	}
+
+	g := &attemptGuard{
+		name:  filepath.Join(filepath.Dir(inputFile), "."+filepath.Base(inputFile)+".attempts"),
+		state: guardState{Salt: salt},
+	}
+	data, err := os.ReadFile(g.name)
+	if errors.Is(err, os.ErrNotExist) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("guard state: %w", err)
+	}
+
+	var state guardState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, fmt.Errorf("guard state: %w", err)
+	}
+	if bytes.Equal(state.Salt, salt) {
+		g.state = state
+	}
+	return g, nil
+}
+
+// wait waits the delay due to the failures so far, counted from the last.
+func (g *attemptGuard) wait() {
+	excess := g.state.Failures - guardFreeAttempts
+	if excess < 0 {
+		return
+	}
+
+	delay := guardMaxDelay
+	if excess < 16 {
+		delay = min(guardDelay<<excess, guardMaxDelay)
+	}
+	delay -= time.Since(g.state.Last)
+	if delay <= 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d failed attempts, waiting %s\n", g.state.Failures, delay.Round(time.Second))
+	time.Sleep(delay)
+}
+
+// record records the outcome of an attempt, where err is its error.
+// Only errors of a wrong password count as failures, and a success
+// clears them.
+func (g *attemptGuard) record(err error) error {
+	if err == nil {
+		err = os.Remove(g.name)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if !errors.Is(err, encdec.ErrWrongKey) {
+		return nil
+	}
+
+	g.state.Failures++
+	g.state.Last = time.Now()
+	data, err := json.Marshal(&g.state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(g.name, data, 0600)
+}
+
+// done records the outcome of an attempt ending with *err, setting *err to
+// the error recording it, if any and *err is nil. It is meant to be
+// deferred.
+func (g *attemptGuard) done(err *error) {
+	err2 := g.record(*err)
+	if err2 != nil && *err == nil {
+		*err = fmt.Errorf("guard state: %w", err2)
+	}
+}
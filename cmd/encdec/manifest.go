@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// manifest records the files processed by a command run over many
+// files, as JSON lines written as each file is done, so a manifest cut
+// short by a crash still holds every complete line before it.
+type manifest struct {
+	file *os.File
+	enc  *json.Encoder
+}
+
+// manifestEntry is a line of a manifest. It holds nothing secret, only
+// what is stored in the clear in the file itself.
+type manifestEntry struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	OK          bool   `json:"ok"`
+	Error       string `json:"error,omitempty"`
+}
+
+// createManifest creates the manifest name, truncating it if it exists.
+func createManifest(name string) (*manifest, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &manifest{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// add writes entry as a line of m, with a single write, so it is either
+// written whole or not at all. A nil manifest ignores it.
+func (m *manifest) add(entry *manifestEntry) error {
+	if m == nil {
+		return nil
+	}
+	return m.enc.Encode(entry)
+}
+
+// close flushes m to disk and closes it.
+func (m *manifest) close() error {
+	if m == nil {
+		return nil
+	}
+	err := m.file.Sync()
+	if err != nil {
+		m.file.Close()
+		return err
+	}
+	return m.file.Close()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bernardo1r/encdec"
+)
+
+func TestVerifyTreeManifest(t *testing.T) {
+	dir := t.TempDir()
+	good := writeEncrypted(t, dir, "good.enc", []byte("plaintext"))
+	err := os.Mkdir(filepath.Join(dir, "nested"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested := writeEncrypted(t, filepath.Join(dir, "nested"), "file.enc", bytes.Repeat([]byte("plaintext"), 100))
+	bad := writeEncrypted(t, dir, "bad.enc", []byte("plaintext"))
+	blob, err := os.ReadFile(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob[len(blob)-1] ^= 1
+	err = os.WriteFile(bad, blob, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "plain.txt"), []byte("not encrypted"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifestFile := filepath.Join(t.TempDir(), "manifest.json")
+	err = verifyTree([]byte("password"), dir, &options{keepGoing: true, manifest: manifestFile})
+	if err == nil {
+		t.Fatal("a tampered file was verified")
+	}
+
+	contents, err := os.ReadFile(manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(contents, []byte("password")) {
+		t.Fatal("the manifest holds the password")
+	}
+
+	// Every encrypted file has a line, in the order walked, and files
+	// that aren't encrypted have none.
+	var entries []manifestEntry
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		var entry manifestEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			t.Fatalf("line %q: %v", scanner.Bytes(), err)
+		}
+		entries = append(entries, entry)
+	}
+	want := []struct {
+		path string
+		ok   bool
+	}{
+		{bad, false},
+		{good, true},
+		{nested, true},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("manifest has %d lines, want %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if entry.Path != want[i].path || entry.OK != want[i].ok || (entry.Error == "") != want[i].ok {
+			t.Fatalf("line %d: %+v, want %s with OK %v", i, entry, want[i].path, want[i].ok)
+		}
+
+		info, err := os.Stat(entry.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Size != info.Size() {
+			t.Fatalf("%s: size %d, want %d", entry.Path, entry.Size, info.Size())
+		}
+		f, err := os.Open(entry.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		params, err := encdec.ParseHeader(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fingerprint, err := params.Fingerprint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Fingerprint != fingerprint {
+			t.Fatalf("%s: fingerprint %s, want %s", entry.Path, entry.Fingerprint, fingerprint)
+		}
+	}
+}
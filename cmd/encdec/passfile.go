@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// fdPathPrefix prefixes the paths naming an open file descriptor, which
+// are read from the descriptor itself, so they also work where there is
+// no /dev/fd.
+const fdPathPrefix = "/dev/fd/"
+
+// readPasswordFile reads the password from the first line of the file
+// at path, which may name a file descriptor as /dev/fd/N.
+func readPasswordFile(path string) ([]byte, error) {
+	if n, ok := strings.CutPrefix(path, fdPathPrefix); ok {
+		fd, err := strconv.Atoi(n)
+		if err == nil {
+			return readPasswordFD(fd)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("password file: %w", err)
+	}
+	defer f.Close()
+
+	return readPasswordLine(f)
+}
+
+// readPasswordFD reads the password from the first line read from the open
+// file descriptor fd, such as one handed by a secret manager, closing it.
+func readPasswordFD(fd int) ([]byte, error) {
+	if fd < 0 {
+		return nil, fmt.Errorf("invalid password file descriptor %d", fd)
+	}
+	f := os.NewFile(uintptr(fd), fdPathPrefix+strconv.Itoa(fd))
+	if f == nil {
+		return nil, fmt.Errorf("invalid password file descriptor %d", fd)
+	}
+	defer f.Close()
+
+	_, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("password file descriptor %d is not open: %w", fd, err)
+	}
+	return readPasswordLine(f)
+}
+
+// readPasswordLine reads the first line of src, without its newline.
+// A pipe may deliver the line in pieces, so src is read until a newline
+// or its end.
+func readPasswordLine(src io.Reader) ([]byte, error) {
+	line, err := bufio.NewReader(src).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read password: %w", err)
+	}
+
+	line, ok := strings.CutSuffix(line, "\n")
+	if ok {
+		line = strings.TrimSuffix(line, "\r")
+	}
+	if line == "" {
+		return nil, errors.New("password not provided")
+	}
+	return []byte(line), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// stdinName is the input file name standing for stdin.
+const stdinName = "-"
+
+// progressInterval is how often the progress is redrawn.
+const progressInterval = 100 * time.Millisecond
+
+// progressReader reports on stderr how much of src was read. If the total
+// is known, from the size of the file or the -size hint, it is shown as a
+// percentage, clamped so a wrong hint never shows more than 100%.
+type progressReader struct {
+	src   io.Reader
+	total int64
+	read  int64
+	drawn time.Time
+}
+
+// newProgressReader wraps src, whose size is taken from the file if it is a
+// regular one, or else from hint, if not zero.
+func newProgressReader(src *os.File, hint int64) *progressReader {
+	info, err := src.Stat()
+	if err == nil && info.Mode().IsRegular() {
+		hint = info.Size()
+	}
+
+	return &progressReader{src: src, total: hint}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	r.read += int64(n)
+	if time.Since(r.drawn) >= progressInterval {
+		r.draw(false)
+	}
+	return n, err
+}
+
+func (r *progressReader) draw(done bool) {
+	r.drawn = time.Now()
+	if r.total <= 0 {
+		fmt.Fprintf(os.Stderr, "\r%s    ", formatBytes(r.read))
+		return
+	}
+
+	// The total is only a hint for piped input, so the stream
+	// may be longer or shorter, only reaching 100% when done.
+	percent := 100 * min(r.read, r.total) / r.total
+	if done {
+		percent = 100
+	} else {
+		percent = min(percent, 99)
+	}
+	fmt.Fprintf(os.Stderr, "\r%3d%% %s    ", percent, formatBytes(r.read))
+}
+
+// finish draws the progress as complete and ends its line.
+func (r *progressReader) finish() {
+	r.draw(true)
+	fmt.Fprintln(os.Stderr)
+}
+
+// formatBytes formats n bytes with one decimal in the largest binary unit
+// not greater than n.
+func formatBytes(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	size := float64(n)
+	i := 0
+	for i < len(units)-1 && size >= 1<<10 {
+		size /= 1 << 10
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	return fmt.Sprintf("%.1f %s", size, units[i])
+}
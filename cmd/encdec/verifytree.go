@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/bernardo1r/encdec"
+)
+
+// verifyTree verifies every encrypted file under dir with password,
+// printing OK or FAIL for each one and a summary once done. Files that
+// aren't encrypted are skipped. Nothing is written. The first failing
+// file stops the others from being verified, unless opts keep going.
+// Every file verified is recorded in the manifest of opts, if any.
+func verifyTree(password []byte, dir string, opts *options) (err error) {
+	var m *manifest
+	if opts.manifest != "" {
+		m, err = createManifest(opts.manifest)
+		if err != nil {
+			return fmt.Errorf("manifest: %w", err)
+		}
+		defer func() {
+			err2 := m.close()
+			if err2 != nil && err == nil {
+				err = fmt.Errorf("manifest: %w", err2)
+			}
+		}()
+	}
+
+	b := batch{keepGoing: opts.keepGoing}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			err2 := m.add(&manifestEntry{Path: path, Error: err.Error()})
+			if err2 != nil {
+				return fmt.Errorf("manifest: %w", err2)
+			}
+			err = b.record(path, err)
+			// An unreadable directory is skipped, and the walk goes on.
+			if err == nil && d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		params, encrypted, err := verifyTreeFile(password, path, opts)
+		if err == nil && !encrypted {
+			b.skip()
+			return nil
+		}
+
+		entry := manifestEntry{Path: path, OK: err == nil}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		if params != nil {
+			// Parsed params always have a header, and so a
+			// fingerprint.
+			fingerprint, err2 := params.Fingerprint()
+			if err2 == nil {
+				entry.Fingerprint = fingerprint
+			}
+		}
+		info, err2 := d.Info()
+		if err2 == nil {
+			entry.Size = info.Size()
+		}
+		err2 = m.add(&entry)
+		if err2 != nil {
+			return fmt.Errorf("manifest: %w", err2)
+		}
+		return b.record(path, err)
+	})
+	if err != nil && err != errStopped {
+		return err
+	}
+
+	return b.summary()
+}
+
+// verifyTreeFile verifies inputFile with password, reporting whether it
+// is encrypted at all, and returning its params if its header is valid.
+func verifyTreeFile(password []byte, inputFile string, opts *options) (*encdec.Params, bool, error) {
+	src, err := os.Open(inputFile)
+	if err != nil {
+		return nil, false, err
+	}
+	defer src.Close()
+
+	in, err := decodeReader(src, opts.encoding)
+	if err != nil {
+		return nil, false, err
+	}
+	// Files that can't be decoded aren't in the encoding of opts,
+	// so they can't be encrypted files either, while failing to read
+	// them is an error like any other.
+	encrypted, in, err := encdec.IsEncdecReader(in)
+	if isDecodeError(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if !encrypted {
+		return nil, false, nil
+	}
+
+	params, err := encdec.ParseHeader(in)
+	if err != nil {
+		return nil, true, err
+	}
+	return params, true, verifyStream(password, inputFile, in, params, opts)
+}
+
+// verifyStream verifies the encrypted data of inputFile read from in,
+// deriving its key from password and params.
+func verifyStream(password []byte, inputFile string, in io.Reader, params *encdec.Params, opts *options) (err error) {
+	err = checkParams(params, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.guard {
+		var guard *attemptGuard
+		guard, err = loadGuard(inputFile, params.Salt)
+		if err != nil {
+			return err
+		}
+		guard.wait()
+		defer guard.done(&err)
+	}
+
+	key, err := deriveKey(password, params, opts)
+	if err != nil {
+		return err
+	}
+	return encdec.Verify(key, in, params)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyTreeFileNotEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plain.txt")
+	err := os.WriteFile(path, []byte("not base64 at all!"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, encoding := range []string{"", "base64", "hex"} {
+		_, encrypted, err := verifyTreeFile([]byte("password"), path, &options{encoding: encoding})
+		if err != nil || encrypted {
+			t.Fatalf("encoding %q: got %v, %v, want a file that isn't encrypted", encoding, encrypted, err)
+		}
+	}
+}
+
+func TestVerifyTreeFileReadError(t *testing.T) {
+	// Reading a directory fails, which must not be mistaken for a file
+	// that isn't encrypted.
+	dir := t.TempDir()
+	for _, encoding := range []string{"", "base64"} {
+		_, encrypted, err := verifyTreeFile([]byte("password"), dir, &options{encoding: encoding})
+		if err == nil || encrypted {
+			t.Fatalf("encoding %q: got %v, %v, want a read error", encoding, encrypted, err)
+		}
+	}
+}
+
+func TestVerifyTreeKeepGoing(t *testing.T) {
+	dir := t.TempDir()
+	// Files are walked in lexical order, so the bad file comes first.
+	bad := writeEncrypted(t, dir, "a.enc", []byte("plaintext"))
+	blob, err := os.ReadFile(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob[len(blob)-1] ^= 1
+	err = os.WriteFile(bad, blob, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeEncrypted(t, dir, "b.enc", []byte("plaintext"))
+	writeEncrypted(t, dir, "c.enc", []byte("plaintext"))
+	err = os.WriteFile(filepath.Join(dir, "d.txt"), []byte("not encrypted"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = verifyTree([]byte("password"), dir, &options{})
+	if !errors.Is(err, errStopped) {
+		t.Fatalf("without -keep-going: got error %v, want errStopped", err)
+	}
+
+	err = verifyTree([]byte("password"), dir, &options{keepGoing: true})
+	if err == nil || err.Error() != "1 of 3 files failed" {
+		t.Fatalf("with -keep-going: got error %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package encdec
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrCompress = errors.New("invalid compressed chunk")
+
+// errCompressUnsupported is returned for params with Compress by the
+// functions other than Writer and Reader.
+var errCompressUnsupported = fmt.Errorf("%w: compression is only supported by Writer and Reader", ErrCompress)
+
+// checkStreamOnly returns an error if params need a feature only supported
+// by Writer and Reader, or a Signature, which only Reader checks.
+func (p *Params) checkStreamOnly() error {
+	if p.Trailer {
+		return errTrailerUnsupported
+	}
+	if p.Compress {
+		return errCompressUnsupported
+	}
+	if p.Frames {
+		return errFramesUnsupported
+	}
+	if p.Erasure != (Erasure{}) {
+		return errErasureUnsupported
+	}
+	if len(p.Signature) != 0 {
+		return errSignatureUnsupported
+	}
+	return nil
+}
+
+// chunkCompressor compresses chunks independently of each other.
+type chunkCompressor struct {
+	w    *flate.Writer
+	buff bytes.Buffer
+}
+
+func newChunkCompressor() *chunkCompressor {
+	// The level is only an error if out of range.
+	w, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return &chunkCompressor{w: w}
+}
+
+// compress returns plaintext compressed and true, or plaintext itself and
+// false if compressing it doesn't make it shorter. The returned slice is
+// only valid until the next call.
+func (c *chunkCompressor) compress(plaintext []byte) ([]byte, bool) {
+	c.buff.Reset()
+	c.w.Reset(&c.buff)
+	// Writes to a bytes.Buffer never fail.
+	c.w.Write(plaintext)
+	c.w.Close()
+	if c.buff.Len() >= len(plaintext) {
+		return plaintext, false
+	}
+	return c.buff.Bytes(), true
+}
+
+// chunkDecompressor decompresses the chunks of chunkCompressor.
+type chunkDecompressor struct {
+	r   io.ReadCloser
+	src bytes.Reader
+}
+
+func newChunkDecompressor() *chunkDecompressor {
+	d := new(chunkDecompressor)
+	d.r = flate.NewReader(&d.src)
+	return d
+}
+
+// decompress decompresses compressed into dst, returning dst[:n] for the
+// n bytes decompressed, which must not be more than limit. dst must have
+// a capacity of more than limit, so it is never grown into a new buffer.
+func (d *chunkDecompressor) decompress(dst []byte, compressed []byte, limit int) ([]byte, error) {
+	d.src.Reset(compressed)
+	err := d.r.(flate.Resetter).Reset(&d.src, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCompress, err)
+	}
+
+	dst = dst[:limit+1]
+	n := 0
+	for {
+		m, err := d.r.Read(dst[n:])
+		n += m
+		if n > limit {
+			return nil, fmt.Errorf("%w: longer than the chunk size", ErrCompress)
+		}
+		if err == io.EOF {
+			return dst[:n], nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCompress, err)
+		}
+	}
+}
+
+// sealFrame encrypts the buffered chunk as the next frame, compressed if
+// the stream is and that makes it shorter.
+func (w *Writer) sealFrame(last bool) ([]byte, error) {
+	if w.compressor == nil {
+		return w.frames.sealFrame(w.buff.Bytes(), last)
+	}
+	plaintext, compressed := w.compressor.compress(w.buff.Bytes())
+	return w.frames.sealFrameCompressed(plaintext, last, compressed)
+}
+
+// readFrame reads the next frame into the pooled buffer of r, decompressing
+// it if it was compressed, and returns its plaintext.
+func (r *Reader) readFrame() ([]byte, bool, error) {
+	plaintext, flags, err := r.frames.readFrameFlags()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, false, err
+	}
+
+	r.config.logf("encdec: read frame of %d bytes", len(plaintext))
+	if flags&frameCompressed != 0 {
+		plaintext, err = r.decompressor.decompress(r.pooled[:0], plaintext, r.chunkSize)
+		if err != nil {
+			return nil, false, err
+		}
+	} else {
+		plaintext = append(r.pooled[:0], plaintext...)
+	}
+	return plaintext, flags&frameLast != 0, nil
+}
@@ -0,0 +1,70 @@
+package encdec
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	compressible := bytes.Repeat([]byte("compressible "), 1000)
+	random := make([]byte, 3*4096)
+	rand.Read(random)
+	mixed := append(bytes.Clone(compressible), random...)
+
+	// encrypt returns the ciphertext of plaintext after checking that it
+	// decrypts, compressed or only framed like compressed streams are.
+	encrypt := func(plaintext []byte, compress bool) []byte {
+		t.Helper()
+		params := &Params{ChunkSize: 4096, Salt: bytes.Repeat([]byte{1}, SaltSize)}
+		params.Compress = compress
+		params.Frames = !compress
+		blob, err := encryptWithKey(key, plaintext, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decryptWithKey(key, blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatal("decrypted data doesn't match the plaintext")
+		}
+		return blob[bytes.IndexByte(blob, '\n')+1:]
+	}
+
+	for _, plaintext := range [][]byte{nil, []byte("x"), compressible, random, mixed} {
+		encrypt(plaintext, true)
+	}
+
+	// Chunks that don't compress are kept as they are, so they take as
+	// much room as in a stream without compression, while the others
+	// take much less.
+	if len(encrypt(random, true)) != len(encrypt(random, false)) {
+		t.Fatal("incompressible chunks were expanded")
+	}
+	compressed, framed := len(encrypt(mixed, true)), len(encrypt(mixed, false))
+	if framed-compressed < len(compressible)/2 {
+		t.Fatalf("mixed data takes %d bytes compressed, %d without", compressed, framed)
+	}
+
+	// The flag marking a chunk as compressed is authenticated.
+	params := &Params{ChunkSize: 4096, Salt: bytes.Repeat([]byte{1}, SaltSize), Compress: true}
+	blob, err := encryptWithKey(key, compressible, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob[bytes.IndexByte(blob, '\n')+1] ^= frameCompressed >> 24
+	_, err = decryptWithKey(key, blob)
+	if err == nil {
+		t.Fatal("a chunk with its compressed flag cleared was decrypted")
+	}
+
+	err = Encrypt(key, bytes.NewReader(nil), io.Discard, &Params{Compress: true})
+	if !errors.Is(err, ErrCompress) {
+		t.Fatalf("Encrypt with compression: got error %v, want ErrCompress", err)
+	}
+}
@@ -0,0 +1,154 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+)
+
+// DecryptConcat decrypts src into dst, where src is made of one or more
+// encrypted streams concatenated together, each one starting with its own
+// header. The key of every stream is derived from password.
+//
+// Every stream ends with a chunk shorter than the others, but its length
+// isn't stored anywhere. So the end of a stream is found by trying to
+// decrypt its last chunk up to every position where the next header could
+// start, which authentication guarantees to succeed only at the right one.
+//
+// A stream with a Digest is checked against it once its last chunk is
+// decrypted, returning ErrDigestMismatch if they don't match.
+//
+// So data is appended to an encrypted file by encrypting it as a stream
+// of its own, with its own salt, written after the others. Nothing binds
+// the streams together, so streams can be dropped from the end, or
+// reordered, without failing authentication.
+func DecryptConcat(password []byte, src io.Reader, dst io.Writer) error {
+	r := &peekReader{src: src}
+	for {
+		params, err := ParseHeader(r)
+		if err != nil {
+			return err
+		}
+		key, err := Key(password, params)
+		if err != nil {
+			return err
+		}
+		err = decryptConcatStream(key, r, dst, params)
+		if err != nil {
+			return err
+		}
+
+		_, err = r.peek(1)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func decryptConcatStream(key []byte, r *peekReader, dst io.Writer, params *Params) error {
+	err := params.checkStreamOnly()
+	if err != nil {
+		return err
+	}
+	cipher, err := newChunkCipher(key, params, nil)
+	if err != nil {
+		return err
+	}
+	dst, checkDigest := digestWriter(dst, params)
+	chunkSize := cipher.sealedSize
+	next := []byte("$" + params.ArgonType + "$")
+
+	plaintext := make([]byte, cipher.chunkSize)
+	for {
+		window, err := r.peek(chunkSize + len(next))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		var openErr error
+		if len(window) >= chunkSize {
+			plaintext, openErr = cipher.open(plaintext[:0], window[:chunkSize], false)
+			if openErr == nil {
+				err = writeChunk(r, dst, plaintext, chunkSize)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		// The window holds the last chunk, ending either at the
+		// end of src or right before the next header.
+		ends := make([]int, 0, 1)
+		if len(window) < chunkSize {
+			ends = append(ends, len(window))
+		}
+		for i := cipher.aead.Overhead(); i < len(window) && i < chunkSize; i++ {
+			if bytes.HasPrefix(window[i:], next) {
+				ends = append(ends, i)
+			}
+		}
+		for _, end := range ends {
+			plaintext, openErr = cipher.open(plaintext[:0], window[:end], true)
+			if openErr == nil {
+				err = writeChunk(r, dst, plaintext, end)
+				if err != nil {
+					return err
+				}
+				return checkDigest()
+			}
+		}
+		return openErr
+	}
+}
+
+func writeChunk(r *peekReader, dst io.Writer, plaintext []byte, n int) error {
+	_, err := dst.Write(plaintext)
+	if err != nil {
+		return err
+	}
+	r.discard(n)
+	return nil
+}
+
+// peekReader is a reader that allows looking ahead of what was read.
+type peekReader struct {
+	src  io.Reader
+	buff []byte
+	err  error
+}
+
+// peek returns the next n bytes without consuming them. If fewer bytes
+// are returned, err explains why.
+func (r *peekReader) peek(n int) ([]byte, error) {
+	for len(r.buff) < n && r.err == nil {
+		r.buff = slices.Grow(r.buff, n-len(r.buff))
+		var m int
+		m, r.err = r.src.Read(r.buff[len(r.buff):n])
+		r.buff = r.buff[:len(r.buff)+m]
+	}
+	if len(r.buff) < n {
+		return r.buff, r.err
+	}
+	return r.buff[:n], nil
+}
+
+func (r *peekReader) discard(n int) {
+	r.buff = append(r.buff[:0], r.buff[n:]...)
+}
+
+func (r *peekReader) Read(p []byte) (int, error) {
+	if len(r.buff) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		return r.src.Read(p)
+	}
+	n := copy(p, r.buff)
+	r.discard(n)
+	return n, nil
+}
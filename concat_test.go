@@ -0,0 +1,78 @@
+package encdec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestDecryptConcatDigest(t *testing.T) {
+	first := mustEncryptBytes(t, []byte("first stream"))
+
+	params := testParams()
+	digest := sha256.Sum256([]byte("second stream"))
+	params.Digest = digest[:]
+	second, err := EncryptBytes([]byte("password"), []byte("second stream"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = DecryptConcat([]byte("password"), bytes.NewReader(append(bytes.Clone(first), second...)), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "first streamsecond stream" {
+		t.Fatalf("got %q", out.String())
+	}
+
+	params.Digest = bytes.Repeat([]byte{1}, DigestSize)
+	second, err = EncryptBytes([]byte("password"), []byte("second stream"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DecryptConcat([]byte("password"), bytes.NewReader(append(bytes.Clone(first), second...)), &out)
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("got error %v, want ErrDigestMismatch", err)
+	}
+}
+
+func TestDecryptConcat(t *testing.T) {
+	password := []byte("password")
+	// With chunks of 64 bytes, some streams end on a chunk boundary.
+	for _, n := range []int{0, 1, 64, 65, 200} {
+		for _, m := range []int{0, 5, 128, 300} {
+			first := bytes.Repeat([]byte{'a'}, n)
+			second := bytes.Repeat([]byte{'b'}, m)
+			blob := mustEncryptBytes(t, first)
+			blob = append(blob, mustEncryptBytes(t, second)...)
+			blob = append(blob, mustEncryptBytes(t, first)...)
+
+			var out bytes.Buffer
+			err := DecryptConcat(password, bytes.NewReader(blob), &out)
+			want := append(append(bytes.Clone(first), second...), first...)
+			if err != nil || !bytes.Equal(out.Bytes(), want) {
+				t.Fatalf("%d+%d: decrypted %d bytes, %v", n, m, out.Len(), err)
+			}
+
+			// A stream cut short fails, even though the streams
+			// before it decrypt.
+			err = DecryptConcat(password, bytes.NewReader(blob[:len(blob)-1]), io.Discard)
+			if err == nil {
+				t.Fatalf("%d+%d: truncated streams were accepted", n, m)
+			}
+		}
+	}
+
+	other, err := EncryptBytes([]byte("other"), []byte("data"), testParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := append(mustEncryptBytes(t, []byte("data")), other...)
+	err = DecryptConcat(password, bytes.NewReader(blob), io.Discard)
+	if !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("stream of another password: got error %v, want ErrWrongKey", err)
+	}
+}
@@ -0,0 +1,386 @@
+package encdec
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// recordHeaderSize is the size of the length prefixing every record.
+const recordHeaderSize = 4
+
+// recordClose is set in the length of the record sent by Close, which
+// ends the records of its side. Its chunk is sealed as the last one, so
+// it can't be forged, and a stream cut short without it is told apart.
+const recordClose = 1 << 31
+
+// connNonceSize is the length of the nonce sent by Server, which the keys
+// of both directions are derived from along with the password.
+const connNonceSize = 32
+
+// Replies of Server to the header sent by Client, followed by the nonce
+// if the params are accepted.
+const (
+	connAccepted = 0
+	connRefused  = 1
+)
+
+// ErrParamsRefused is returned by Client if the server refused its params,
+// such as for taking too long to derive the key from under its policy.
+var ErrParamsRefused = errors.New("params refused by server")
+
+// defaultConnPolicy restricts the params sent by clients to Server when
+// no policy is given, so a client can't keep the key derivation running
+// for longer than the default params of Client take.
+var defaultConnPolicy = &Policy{MaxArgonDuration: 30 * time.Second}
+
+// Conn is an encrypted channel over an io.ReadWriter, such as a net.Conn,
+// created by Client or Server. It implements net.Conn, delegating the
+// addresses and deadlines to the io.ReadWriter if it has them.
+//
+// Unlike Writer, every Write is sent right away as one or more records,
+// each one with the length of a chunk at most, which are authenticated
+// in order by the other side. Each direction is encrypted with its own key,
+// derived from the password, the salt chosen by the client and a nonce
+// chosen by the server, so a replayed client header never makes the server
+// reuse the keys of an earlier channel.
+type Conn struct {
+	rw        io.ReadWriter
+	chunkSize int
+
+	readMu  sync.Mutex
+	recv    *chunkCipher
+	pending []byte
+	record  []byte
+	readErr error
+
+	writeMu  sync.Mutex
+	send     *chunkCipher
+	sendBuff []byte
+	writeErr error
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+// Client starts an encrypted channel over rw, sending the header of params
+// for the server to derive the same key from password, and reading back
+// the nonce of the server. A nil params means NewParams, whose key takes
+// 2 GiB of memory to derive, so clients on smaller machines, or talking
+// to servers with a stricter Policy, pass cheaper ones. A fresh salt is
+// generated for every channel, leaving params unchanged.
+//
+// The server checks params against its policy before deriving the key,
+// and if it refuses them an error wrapping ErrParamsRefused is returned,
+// so the client can try again with cheaper ones.
+func Client(rw io.ReadWriter, password []byte, params *Params) (*Conn, error) {
+	if params == nil {
+		params = NewParams()
+	}
+	sessionParams := *params
+	sessionParams.Salt = nil
+	params = &sessionParams
+
+	key, err := Key(password, params)
+	if err != nil {
+		return nil, err
+	}
+	header, err := params.MarshalHeader()
+	if err != nil {
+		return nil, err
+	}
+	_, err = rw.Write(header)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, 1+connNonceSize)
+	_, err = io.ReadFull(rw, reply[:1])
+	if err != nil {
+		return nil, fmt.Errorf("reading server reply: %w", err)
+	}
+	if reply[0] != connAccepted {
+		return nil, ErrParamsRefused
+	}
+	nonce := reply[1:]
+	_, err = io.ReadFull(rw, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("reading server nonce: %w", err)
+	}
+	return newConn(rw, key, params, nonce, "client", "server")
+}
+
+// Server accepts an encrypted channel over rw started by Client, reading
+// the header sent by the client, deriving the key from password and
+// sending back a freshly generated nonce.
+//
+// The params of the header are chosen by the client, so they are checked
+// against policy before deriving the key, refusing those that are too
+// costly to derive it from. A nil policy bounds the derivation to 30
+// seconds. Params of a format before FormatV2 are always refused, as their
+// records can't be told to be the last. The client is told its params
+// were refused, so it can try again with others, and the reason is
+// returned.
+func Server(rw io.ReadWriter, password []byte, policy *Policy) (*Conn, error) {
+	params, err := acceptParams(rw, policy)
+	if err != nil {
+		rw.Write([]byte{connRefused})
+		return nil, err
+	}
+	key, err := Key(password, params)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := random(connNonceSize)
+	if err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+	_, err = rw.Write(append([]byte{connAccepted}, nonce...))
+	if err != nil {
+		return nil, err
+	}
+	return newConn(rw, key, params, nonce, "server", "client")
+}
+
+// acceptParams reads the header sent by the client, returning its params
+// if policy allows them.
+func acceptParams(rw io.ReadWriter, policy *Policy) (*Params, error) {
+	params, err := ParseHeader(rw)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		policy = defaultConnPolicy
+	}
+	err = policy.CheckParams(params)
+	if err != nil {
+		return nil, err
+	}
+	if params.Format < FormatV2 {
+		return nil, fmt.Errorf("%w: %d, channels require %d", ErrFormat, params.Format, FormatV2)
+	}
+	return params, nil
+}
+
+func newConn(rw io.ReadWriter, key []byte, params *Params, nonce []byte, local string, remote string) (*Conn, error) {
+	send, err := connCipher(key, params, nonce, local)
+	if err != nil {
+		return nil, err
+	}
+	recv, err := connCipher(key, params, nonce, remote)
+	if err != nil {
+		return nil, err
+	}
+
+	// Records are sealed and opened in buffers holding the largest one.
+	c := &Conn{
+		rw:        rw,
+		chunkSize: send.chunkSize,
+		send:      send,
+		sendBuff:  make([]byte, recordHeaderSize+send.sealedSize),
+		recv:      recv,
+		record:    make([]byte, max(recordHeaderSize, recv.sealedSize)),
+	}
+	return c, nil
+}
+
+// connCipher creates the chunkCipher of the records sent by side,
+// with a key of its own derived from key and the nonce of the server.
+func connCipher(key []byte, params *Params, nonce []byte, side string) (*chunkCipher, error) {
+	kdf := hkdf.New(sha256.New, key, nonce, []byte("encdec conn: "+side))
+	sideKey := make([]byte, keySize)
+	_, err := io.ReadFull(kdf, sideKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return newChunkCipher(sideKey, params, nil)
+}
+
+// Write encrypts p and sends it to the other side.
+// It returns the number of bytes of p sent and an error, if any.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.writeErr != nil {
+		return 0, c.writeErr
+	}
+
+	var total int
+	for len(p) > 0 {
+		size := min(len(p), c.chunkSize)
+		record, err := c.send.seal(c.sendBuff[:recordHeaderSize], p[:size], false)
+		if err != nil {
+			c.writeErr = err
+			return total, err
+		}
+		binary.BigEndian.PutUint32(record, uint32(len(record)-recordHeaderSize))
+		_, err = c.rw.Write(record)
+		if err != nil {
+			c.writeErr = err
+			return total, err
+		}
+		total += size
+		p = p[size:]
+	}
+
+	return total, nil
+}
+
+// Read reads up to len(p) decrypted bytes sent by the other side.
+// It returns io.EOF once the other side is closed, and
+// io.ErrUnexpectedEOF if the underlying reader ends before that.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for len(c.pending) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		c.readErr = c.readRecord()
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readRecord reads the next record into the buffer of c, which holds the
+// largest record, and decrypts it in place, so no record is allocated.
+func (c *Conn) readRecord() error {
+	// The length is read into the buffer too, as a local array would be
+	// allocated for escaping to the io.Reader.
+	header := c.record[:recordHeaderSize]
+	_, err := io.ReadFull(c.rw, header)
+	if err != nil {
+		// Without a close record, the stream was cut short.
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	closed := length&recordClose != 0
+	size := int(length &^ recordClose)
+	if size > c.chunkSize+c.recv.aead.Overhead() {
+		return errors.New("invalid record size")
+	}
+	record := c.record[:size]
+	_, err = io.ReadFull(c.rw, record)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	plaintext, err := c.recv.open(record[:0], record, closed)
+	if err != nil {
+		return err
+	}
+	if closed {
+		return io.EOF
+	}
+	c.pending = plaintext
+	return nil
+}
+
+// Close sends the close record, telling the other side that nothing
+// more will be sent, and closes the underlying io.ReadWriter, if it
+// implements io.Closer. Once closed, Write returns ErrClosed.
+func (c *Conn) Close() error {
+	err := c.sendClose()
+	closer, ok := c.rw.(io.Closer)
+	if !ok {
+		return err
+	}
+	err2 := closer.Close()
+	if err == nil {
+		err = err2
+	}
+	return err
+}
+
+// LocalAddr returns the local address of the underlying io.ReadWriter,
+// or nil if it doesn't have one.
+func (c *Conn) LocalAddr() net.Addr {
+	conn, ok := c.rw.(interface{ LocalAddr() net.Addr })
+	if !ok {
+		return nil
+	}
+	return conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the underlying io.ReadWriter,
+// or nil if it doesn't have one.
+func (c *Conn) RemoteAddr() net.Addr {
+	conn, ok := c.rw.(interface{ RemoteAddr() net.Addr })
+	if !ok {
+		return nil
+	}
+	return conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the underlying
+// io.ReadWriter, returning os.ErrNoDeadline if it has none. A Read or
+// Write timing out leaves c unusable, as a record may be partly sent
+// or received.
+func (c *Conn) SetDeadline(t time.Time) error {
+	conn, ok := c.rw.(interface{ SetDeadline(time.Time) error })
+	if !ok {
+		return os.ErrNoDeadline
+	}
+	return conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying io.ReadWriter,
+// returning os.ErrNoDeadline if it has none.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	conn, ok := c.rw.(interface{ SetReadDeadline(time.Time) error })
+	if !ok {
+		return os.ErrNoDeadline
+	}
+	return conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying io.ReadWriter,
+// returning os.ErrNoDeadline if it has none.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	conn, ok := c.rw.(interface{ SetWriteDeadline(time.Time) error })
+	if !ok {
+		return os.ErrNoDeadline
+	}
+	return conn.SetWriteDeadline(t)
+}
+
+// sendClose sends the close record, unless the records already ended,
+// as c is closed or a write failed, which was reported then.
+func (c *Conn) sendClose() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.writeErr != nil {
+		return nil
+	}
+
+	c.writeErr = ErrClosed
+	record, err := c.send.seal(c.sendBuff[:recordHeaderSize], nil, true)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(record, uint32(len(record)-recordHeaderSize)|recordClose)
+	_, err = c.rw.Write(record)
+	return err
+}
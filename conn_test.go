@@ -0,0 +1,290 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testConnParams returns params cheap enough to derive keys in tests.
+func testConnParams() *Params {
+	return &Params{ArgonMemory: 64, ArgonThreads: 1, ChunkSize: 16}
+}
+
+// connPair returns both ends of a channel over a net.Pipe.
+func connPair(t *testing.T) (*Conn, *Conn) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		c, err := Server(b, []byte("password"), nil)
+		done <- result{c, err}
+	}()
+	client, err := Client(a, []byte("password"), testConnParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	return client, r.conn
+}
+
+func TestConnRoundTrip(t *testing.T) {
+	client, server := connPair(t)
+	msg := bytes.Repeat([]byte("0123456789"), 10)
+
+	go func() {
+		client.Write(msg)
+		client.Close()
+	}()
+	got, err := io.ReadAll(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("got %q, want %q", got, msg)
+	}
+
+	_, err = client.Write(msg)
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("Write after Close returned %v, want ErrClosed", err)
+	}
+}
+
+func TestConnTruncated(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	done := make(chan *Conn, 1)
+	go func() {
+		c, _ := Server(b, []byte("password"), nil)
+		done <- c
+	}()
+	client, err := Client(a, []byte("password"), testConnParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-done
+
+	go func() {
+		client.Write([]byte("hello"))
+		// Closing the pipe without the close record cuts the stream.
+		a.Close()
+	}()
+	got, err := io.ReadAll(server)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("got error %v, want io.ErrUnexpectedEOF", err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+// replayConn replays a recorded client header and keeps what is written.
+type replayConn struct {
+	io.Reader
+	sent bytes.Buffer
+}
+
+func (c *replayConn) Write(p []byte) (int, error) {
+	return c.sent.Write(p)
+}
+
+func TestConnReplayedHeader(t *testing.T) {
+	params := testConnParams()
+	_, err := Key([]byte("password"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := params.MarshalHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every session with the same client header sends other ciphertext
+	// for the same plaintext, as the server nonce differs.
+	var sent [][]byte
+	for range 2 {
+		rw := &replayConn{Reader: bytes.NewReader(header)}
+		server, err := Server(rw, []byte("password"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw.sent.Reset()
+		_, err = server.Write([]byte("same data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, bytes.Clone(rw.sent.Bytes()))
+	}
+	if bytes.Equal(sent[0], sent[1]) {
+		t.Fatal("replayed header reused the keys of the server")
+	}
+}
+
+func TestConnPolicy(t *testing.T) {
+	params := testConnParams()
+	params.ArgonMemory = 1 << 30
+	params.ArgonTime = 1 << 20
+	params.Salt = make([]byte, SaltSize)
+	header, err := params.MarshalHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw := &replayConn{Reader: bytes.NewReader(header)}
+	_, err = Server(rw, []byte("password"), nil)
+	if !errors.Is(err, ErrArgonCostTooHigh) {
+		t.Fatalf("got error %v, want ErrArgonCostTooHigh", err)
+	}
+
+	params = testConnParams()
+	params.Format = FormatV1
+	params.Salt = make([]byte, SaltSize)
+	header, err = params.MarshalHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw = &replayConn{Reader: bytes.NewReader(header)}
+	_, err = Server(rw, []byte("password"), nil)
+	if !errors.Is(err, ErrFormat) {
+		t.Fatalf("got error %v, want ErrFormat", err)
+	}
+}
+
+func TestConnRefused(t *testing.T) {
+	policy := &Policy{MaxChunkSize: 8}
+	for _, chunkSize := range []int64{16, 8} {
+		a, b := net.Pipe()
+		defer a.Close()
+		defer b.Close()
+		done := make(chan error, 1)
+		go func() {
+			_, err := Server(b, []byte("password"), policy)
+			done <- err
+		}()
+
+		params := testConnParams()
+		params.ChunkSize = chunkSize
+		_, err := Client(a, []byte("password"), params)
+		serverErr := <-done
+		if chunkSize > policy.MaxChunkSize {
+			if !errors.Is(err, ErrParamsRefused) {
+				t.Fatalf("chunk size %d: got error %v, want ErrParamsRefused", chunkSize, err)
+			}
+			if !errors.Is(serverErr, ErrPolicyViolation) {
+				t.Fatalf("chunk size %d: server got error %v, want ErrPolicyViolation", chunkSize, serverErr)
+			}
+			continue
+		}
+		if err != nil || serverErr != nil {
+			t.Fatalf("chunk size %d: got errors %v and %v", chunkSize, err, serverErr)
+		}
+	}
+}
+
+func TestClientParamsUnchanged(t *testing.T) {
+	params := testConnParams()
+	want := *params
+	for range 2 {
+		a, b := net.Pipe()
+		defer a.Close()
+		defer b.Close()
+		go Server(b, []byte("password"), nil)
+		_, err := Client(a, []byte("password"), params)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(*params, want) {
+		t.Fatalf("Client changed params to %+v", params)
+	}
+}
+
+func TestConnDeadline(t *testing.T) {
+	client, server := connPair(t)
+	if client.LocalAddr() == nil || server.RemoteAddr() == nil {
+		t.Fatal("addresses of the net.Pipe weren't delegated")
+	}
+
+	err := server.SetReadDeadline(time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got error %v, want os.ErrDeadlineExceeded", err)
+	}
+
+	err = client.SetDeadline(time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Write([]byte("hello"))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got error %v, want os.ErrDeadlineExceeded", err)
+	}
+
+	// Without a net.Conn underneath there are no deadlines nor addresses.
+	params := testConnParams()
+	_, err = Key([]byte("password"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := params.MarshalHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Server(&replayConn{Reader: bytes.NewReader(header)}, []byte("password"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.LocalAddr() != nil || c.RemoteAddr() != nil {
+		t.Fatal("got addresses without a net.Conn")
+	}
+	for _, set := range []func(time.Time) error{c.SetDeadline, c.SetReadDeadline, c.SetWriteDeadline} {
+		err = set(time.Now())
+		if !errors.Is(err, os.ErrNoDeadline) {
+			t.Fatalf("got error %v, want os.ErrNoDeadline", err)
+		}
+	}
+}
+
+func TestConnReadAllocs(t *testing.T) {
+	client, server := connPair(t)
+	msg := []byte("0123456789abcdef")
+	go func() {
+		for {
+			_, err := client.Write(msg)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	p := make([]byte, len(msg))
+	allocs := testing.AllocsPerRun(100, func() {
+		_, err := io.ReadFull(server, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("Read allocated %v times per record", allocs)
+	}
+}
@@ -0,0 +1,78 @@
+package encdec
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// convergentSaltSize is the length of the salt derived by EncryptConvergent.
+const convergentSaltSize = 16
+
+// convergentStretchSalt is the fixed salt of the Argon2 derivation
+// stretching the password before the salt of EncryptConvergent is derived.
+var convergentStretchSalt = []byte("encdec convergent")
+
+// EncryptConvergent encrypts src into dst, preceded by its header, with the
+// default params and a salt derived from password and the contents of src,
+// so the same plaintext encrypted with the same password always produces
+// the same output. Files can then be deduplicated by comparing their
+// encrypted bytes, without the password.
+//
+// This is convergent encryption, and it leaks which files hold the same
+// plaintext: anyone seeing two outputs learns whether they are equal, and
+// anyone knowing the password can confirm whether an output holds a
+// plaintext they guess, without decrypting it. Use it only where that is
+// acceptable, and EncryptDeterministic or Writer everywhere else.
+//
+// The salt is an HMAC of the plaintext keyed by the password stretched by
+// Argon2, with the default params and a fixed salt, so different
+// plaintexts get different salts, and so different keys, which keeps the
+// nonces of their chunks from being reused. As the salt is stored in the
+// clear, keying the HMAC with the password itself would let anyone who
+// can guess the plaintext test passwords against it at the speed of HMAC,
+// rather than of Argon2. This makes encrypting cost two key derivations.
+// src is read twice, once to derive the salt and again to encrypt it,
+// seeking back to where it was.
+func EncryptConvergent(password []byte, src io.ReadSeeker, dst io.Writer) error {
+	return encryptConvergent(password, src, dst, NewParams())
+}
+
+// encryptConvergent works like EncryptConvergent, with params instead of
+// the default ones.
+func encryptConvergent(password []byte, src io.ReadSeeker, dst io.Writer, params *Params) error {
+	start, err := src.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	stretch := *params
+	stretch.Salt = convergentStretchSalt
+	stretch.SaltSize = uint8(len(convergentStretchSalt))
+	stretched, err := Key(password, &stretch)
+	if err != nil {
+		return err
+	}
+	// The stretched password is only used through a key of its own, so
+	// it is never the key of a file, even one with the fixed salt.
+	macKey := make([]byte, sha256.Size)
+	_, err = io.ReadFull(hkdf.New(sha256.New, stretched, nil, []byte("encdec convergent salt")), macKey)
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, macKey)
+	_, err = io.Copy(mac, src)
+	if err != nil {
+		return err
+	}
+	salt := mac.Sum(nil)[:convergentSaltSize]
+
+	_, err = src.Seek(start, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	return EncryptDeterministic(password, salt, src, dst, params)
+}
@@ -0,0 +1,64 @@
+package encdec
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"io"
+	"testing"
+)
+
+func convergentOutput(t *testing.T, password string, plaintext string) []byte {
+	t.Helper()
+	var dst bytes.Buffer
+	params := &Params{ArgonMemory: 64, ArgonThreads: 1}
+	err := encryptConvergent([]byte(password), bytes.NewReader([]byte(plaintext)), &dst, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dst.Bytes()
+}
+
+func TestEncryptConvergent(t *testing.T) {
+	a := convergentOutput(t, "password", "same plaintext")
+	b := convergentOutput(t, "password", "same plaintext")
+	if !bytes.Equal(a, b) {
+		t.Fatal("same plaintext and password encrypted differently")
+	}
+	if bytes.Equal(a, convergentOutput(t, "password", "other plaintext")) {
+		t.Fatal("different plaintexts encrypted the same")
+	}
+	if bytes.Equal(a, convergentOutput(t, "other password", "same plaintext")) {
+		t.Fatal("different passwords encrypted the same")
+	}
+
+	src := bytes.NewReader(a)
+	params, err := ParseHeader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := Key([]byte("password"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(key, src, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "same plaintext" {
+		t.Fatalf("decrypted %q", got)
+	}
+
+	// The salt in the clear can't be recomputed from the raw password
+	// without Argon2.
+	mac := hmac.New(sha256.New, []byte("password"))
+	mac.Write([]byte("encdec convergent salt"))
+	mac.Write([]byte("same plaintext"))
+	if bytes.Equal(params.Salt, mac.Sum(nil)[:convergentSaltSize]) {
+		t.Fatal("salt is an HMAC of the raw password")
+	}
+}
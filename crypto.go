@@ -1,67 +1,83 @@
 package encdec
 
 import (
-	"bytes"
-	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"os"
-	"os/signal"
+	"io"
+	"time"
 
 	"golang.org/x/crypto/argon2"
-
-	"golang.org/x/term"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/text/unicode/norm"
 )
 
 const keySize = 32
 
-// ReadPassword reads the password from stdin without local echo,
-// displaying message before reading the password.
-// It is safe to interrupt the program with SIGINT when blocked
-// by this function as it will restore the previous state of terminal on exit.
-func ReadPassword(message string, repeat bool) ([]byte, error) {
-	passwordCtx, passwordCancel := context.WithCancel(context.Background())
-	defer passwordCancel()
-	stdin := int(os.Stdin.Fd())
-	state, err := term.GetState(stdin)
+var ErrRandomSource = errors.New("random source unavailable")
+
+// Sizes of the nonce and of the tag every AEAD must have
+// to be used in the chunk framing.
+const (
+	nonceSize = 12
+	tagSize   = 16
+)
+
+func checkCipher(name string) error {
+	switch name {
+	case ChaCha20Poly1305, AES256GCM:
+		return nil
+	}
+	return fmt.Errorf("%w: %q is not supported", ErrCipherMismatch, name)
+}
+
+// cipherKeySize returns the key size, in bytes, of the cipher named name.
+func cipherKeySize(name string) (uint32, error) {
+	err := checkCipher(name)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
+	// Both ChaCha20-Poly1305 and AES-256 take 32-byte keys.
+	return keySize, nil
+}
 
-	signalCtx, signalCancel := signal.NotifyContext(passwordCtx, os.Interrupt)
-	go func() {
-		<-signalCtx.Done()
-		signalCancel()
-		if passwordCtx.Err() != nil {
-			return
-		}
-		term.Restore(stdin, state)
-		passwordCancel()
-		fmt.Println("")
-		os.Exit(1)
-	}()
-	fmt.Print(message)
-	password, err := term.ReadPassword(stdin)
-	fmt.Println("")
+// newAEAD creates the AEAD named by cipherName using a 256-bit key,
+// validating that it fits the chunk framing.
+func newAEAD(key []byte, cipherName string) (cipher.AEAD, error) {
+	err := checkCipher(cipherName)
 	if err != nil {
 		return nil, err
 	}
 
-	if repeat {
-		fmt.Print(message)
-		password_check, err := term.ReadPassword(stdin)
-		fmt.Println("")
+	var aead cipher.AEAD
+	switch cipherName {
+	case ChaCha20Poly1305:
+		aead, err = chacha20poly1305.New(key)
+	case AES256GCM:
+		if len(key) != keySize {
+			return nil, errors.New("aes256gcm: bad key length")
+		}
+		var block cipher.Block
+		block, err = aes.NewCipher(key)
 		if err != nil {
 			return nil, err
 		}
-
-		if !bytes.Equal(password, password_check) {
-			return nil, errors.New("passwords don't match")
-		}
+		aead, err = cipher.NewGCM(block)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return password, nil
+	if aead.NonceSize() != nonceSize || aead.Overhead() != tagSize {
+		return nil, fmt.Errorf("%w: %s does not fit the chunk framing", ErrCipherMismatch, cipherName)
+	}
+	return aead, nil
 }
 
 func incNonce(nonce []byte) error {
@@ -77,10 +93,38 @@ func incNonce(nonce []byte) error {
 	return nil
 }
 
+// setNonce sets nonce to the value it reaches after index calls
+// to incNonce starting from zero.
+func setNonce(nonce []byte, index uint64) {
+	clear(nonce)
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], index)
+}
+
+// randomAttempts is the number of times reading from the random source
+// is tried, waiting randomBackoff before the second attempt and twice as
+// long before every other.
+const (
+	randomAttempts = 4
+	randomBackoff  = 10 * time.Millisecond
+)
+
+// random returns n random bytes. As the random source may not be ready
+// yet, such as early in the boot of some systems, reading from it is
+// retried before giving up with an error wrapping ErrRandomSource.
 func random(n uint8) ([]byte, error) {
 	buff := make([]byte, n)
-	_, err := rand.Read(buff)
-	return buff, err
+	var err error
+	for attempt := 0; attempt < randomAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(randomBackoff << (attempt - 1))
+		}
+		_, err = rand.Read(buff)
+		if err == nil {
+			return buff, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %w", ErrRandomSource, err)
 }
 
 // Key uses argon2 algorithm to create a cryptographic key
@@ -90,6 +134,17 @@ func random(n uint8) ([]byte, error) {
 // amount of time and memory. Using the zero value of params it will use the
 // first recommended parameters option specified in RFC9106.
 func Key(password []byte, params *Params) ([]byte, error) {
+	return KeyWithPepper(password, nil, params)
+}
+
+// KeyWithPepper works like Key, but also mixes pepper into the key.
+//
+// A pepper is a secret shared by all passwords which, unlike the salt, is
+// never stored in the header. It is meant to be kept apart from the
+// encrypted data, so the data alone isn't enough to brute-force the password.
+// The same pepper must be used to derive the key for decryption.
+// A nil or empty pepper derives the same key as Key.
+func KeyWithPepper(password []byte, pepper []byte, params *Params) ([]byte, error) {
 	if params == nil {
 		return nil, ErrNilParams
 	}
@@ -106,14 +161,64 @@ func Key(password []byte, params *Params) ([]byte, error) {
 		params.Salt = salt
 	}
 
+	if params.Normalization == NormalizationNFC {
+		password = norm.NFC.Bytes(password)
+	}
+	if len(pepper) > 0 {
+		mac := hmac.New(sha256.New, pepper)
+		mac.Write(password)
+		password = mac.Sum(nil)
+	}
+
 	key := argon2.IDKey(
 		password,
 		params.Salt,
 		params.ArgonTime,
 		params.ArgonMemory,
 		params.ArgonThreads,
-		keySize,
+		params.KeySize,
 	)
 
+	if params.Context != "" {
+		return contextKey(key, params.Context)
+	}
 	return key, nil
 }
+
+// contextKey derives from key a new key bound to context.
+func contextKey(key []byte, context string) ([]byte, error) {
+	kdf := hkdf.New(sha256.New, key, nil, []byte("encdec context: "+context))
+	contextKey := make([]byte, len(key))
+	_, err := io.ReadFull(kdf, contextKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return contextKey, nil
+}
+
+// KeyResult is the result of a key derivation started by KeyAsync.
+type KeyResult struct {
+	Key []byte
+	Err error
+}
+
+// KeyAsync works like Key, but derives the key in a new goroutine,
+// returning a channel that receives the result once it is done.
+// This allows showing some feedback while a slow derivation runs.
+//
+// As Key may fill params, params must not be used until the result
+// is received.
+func KeyAsync(password []byte, params *Params) <-chan KeyResult {
+	return KeyWithPepperAsync(password, nil, params)
+}
+
+// KeyWithPepperAsync works like KeyAsync, using KeyWithPepper.
+func KeyWithPepperAsync(password []byte, pepper []byte, params *Params) <-chan KeyResult {
+	result := make(chan KeyResult, 1)
+	go func() {
+		key, err := KeyWithPepper(password, pepper, params)
+		result <- KeyResult{Key: key, Err: err}
+	}()
+	return result
+}
@@ -0,0 +1,194 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestKeyWithPepper(t *testing.T) {
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	password := []byte("password")
+
+	key, err := Key(password, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pepper := range [][]byte{nil, {}} {
+		got, err := KeyWithPepper(password, pepper, params)
+		if err != nil || !bytes.Equal(got, key) {
+			t.Fatalf("pepper %q: key differs from Key, %v", pepper, err)
+		}
+	}
+
+	peppered, err := KeyWithPepper(password, []byte("pepper"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(peppered, key) {
+		t.Fatal("the pepper didn't change the key")
+	}
+	header, err := params.MarshalHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(header, []byte("pepper")) {
+		t.Fatal("the pepper is stored in the header")
+	}
+
+	blob, err := encryptWithKey(peppered, []byte("data"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decryptWithKey(peppered, blob)
+	if err != nil || string(got) != "data" {
+		t.Fatalf("decrypting with the pepper returned %q, %v", got, err)
+	}
+	for _, pepper := range [][]byte{nil, []byte("wrong")} {
+		key, err := KeyWithPepper(password, pepper, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = decryptWithKey(key, blob)
+		if !errors.Is(err, ErrWrongKey) {
+			t.Fatalf("pepper %q: got error %v, want ErrWrongKey", pepper, err)
+		}
+	}
+}
+
+func TestKeyContext(t *testing.T) {
+	password := []byte("password")
+	salt := bytes.Repeat([]byte{1}, SaltSize)
+	keys := make(map[string][]byte)
+	for _, context := range []string{"", "app-a", "app-b"} {
+		params := testParams()
+		params.Salt = salt
+		params.Context = context
+		key, err := Key(password, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for other, otherKey := range keys {
+			if bytes.Equal(key, otherKey) {
+				t.Fatalf("contexts %q and %q derive the same key", context, other)
+			}
+		}
+		keys[context] = key
+	}
+
+	// The context is stored in the header, so decrypting derives the
+	// key of the right one, and a blob can't be moved to another.
+	params := testParams()
+	params.Context = "app-a"
+	blob, err := EncryptBytes(password, []byte("data"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseHeader(bytes.NewReader(blob))
+	if err != nil || parsed.Context != "app-a" {
+		t.Fatalf("parsed context %q, %v", parsed.Context, err)
+	}
+	got, err := DecryptBytes(password, blob)
+	if err != nil || string(got) != "data" {
+		t.Fatalf("DecryptBytes returned %q, %v", got, err)
+	}
+	parsed.Context = "app-b"
+	key, err := Key(password, parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := bytes.NewReader(blob)
+	_, err = ParseHeader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	err = Decrypt(key, src, &out, parsed)
+	if err == nil {
+		t.Fatal("a key of another context decrypted the data")
+	}
+
+	for _, context := range []string{"a$b", "tab\t", strings.Repeat("a", 256)} {
+		params := testParams()
+		params.Context = context
+		err := params.Check()
+		if !errors.Is(err, ErrContext) {
+			t.Fatalf("context %q: got error %v, want ErrContext", context, err)
+		}
+	}
+}
+
+func TestCipherMismatch(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	plaintext := bytes.Repeat([]byte{'x'}, 100)
+
+	for _, format := range []uint8{FormatV1, FormatV4} {
+		for _, tt := range []struct {
+			cipher   string
+			tampered string
+		}{
+			{ChaCha20Poly1305, AES256GCM},
+			{AES256GCM, ChaCha20Poly1305},
+		} {
+			params := testParams()
+			params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+			params.Format = format
+			params.Cipher = tt.cipher
+			blob, err := encryptWithKey(key, plaintext, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The header names the other cipher, whose nonce and tag
+			// sizes fit the framing, so the chunks fail to authenticate.
+			tamperedParams := *params
+			tamperedParams.Cipher = tt.tampered
+			header, err := tamperedParams.MarshalHeader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tampered := append(header, blob[bytes.IndexByte(blob, '\n')+1:]...)
+			_, err = decryptWithKey(key, tampered)
+			if !errors.Is(err, ErrWrongKey) {
+				t.Fatalf("format %d, %s as %s: got error %v, want ErrWrongKey", format, tt.cipher, tt.tampered, err)
+			}
+		}
+	}
+
+	// A header naming a cipher this build doesn't support is refused
+	// before any chunk is read.
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	params.Cipher = AES256GCM
+	blob, err := encryptWithKey(key, plaintext, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"aes128gcm", "AES256GCM", "xchacha20poly1305"} {
+		tampered := bytes.Replace(blob, []byte("$c="+AES256GCM), []byte("$c="+name), 1)
+		_, err := ParseHeader(bytes.NewReader(tampered))
+		if !errors.Is(err, ErrCipherMismatch) {
+			t.Fatalf("cipher %q: got error %v from ParseHeader, want ErrCipherMismatch", name, err)
+		}
+
+		tamperedParams := *params
+		tamperedParams.Cipher = name
+		var logged bytes.Buffer
+		src := bytes.NewReader(blob)
+		_, err = NewReader(key, src, &tamperedParams, WithLogger(log.New(&logged, "", 0)))
+		if !errors.Is(err, ErrCipherMismatch) {
+			t.Fatalf("cipher %q: got error %v from NewReader, want ErrCipherMismatch", name, err)
+		}
+		err = Decrypt(key, src, io.Discard, &tamperedParams, WithLogger(log.New(&logged, "", 0)))
+		if !errors.Is(err, ErrCipherMismatch) {
+			t.Fatalf("cipher %q: got error %v from Decrypt, want ErrCipherMismatch", name, err)
+		}
+		if src.Len() != len(blob) || logged.Len() != 0 {
+			t.Fatalf("cipher %q: read %d bytes and logged %q", name, len(blob)-src.Len(), logged.String())
+		}
+	}
+}
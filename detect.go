@@ -0,0 +1,51 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// headerSignatures are what follows headerMagic in a header, for the
+// header of params and the header of a profile.
+var headerSignatures = []string{"v=", "profile="}
+
+// detectSize is the number of bytes needed by IsEncdec.
+const detectSize = len(headerMagic) + len("profile=")
+
+// IsEncdec reports whether prefix, the first bytes of a file or stream,
+// looks like the start of an encrypted stream, without parsing the header.
+// The first 18 bytes, or the whole stream if shorter, are enough.
+//
+// A true result doesn't guarantee the header is valid, which only
+// ParseHeader does, and streams kept in a text encoding are not detected.
+func IsEncdec(prefix []byte) bool {
+	rest, ok := bytes.CutPrefix(prefix, []byte(headerMagic))
+	if !ok {
+		return false
+	}
+	for _, signature := range headerSignatures {
+		if bytes.HasPrefix(rest, []byte(signature)) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEncdecReader works like IsEncdec, reading the prefix from r. The
+// returned reader yields everything read from r again, followed by the
+// rest of r, so it should be used in place of r afterwards.
+func IsEncdecReader(r io.Reader) (bool, io.Reader, error) {
+	prefix := make([]byte, detectSize)
+	n, err := io.ReadFull(r, prefix)
+	prefix = prefix[:n]
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = nil
+	}
+
+	rest := io.MultiReader(bytes.NewReader(prefix), r)
+	if err != nil {
+		return false, rest, err
+	}
+	return IsEncdec(prefix), rest, nil
+}
@@ -0,0 +1,45 @@
+package encdec
+
+import (
+	"bytes"
+	"io"
+)
+
+// EncryptDeterministic encrypts src into dst, preceded by its header, with
+// a key derived from password and params using salt instead of a random
+// one, so the same inputs always produce the same output. This is meant
+// for tests, reproducible builds and content addressing. params isn't
+// modified.
+//
+// As the nonces of the chunks only depend on their position, encrypting
+// different data with the same password and salt reuses nonces under the
+// same key, which breaks the confidentiality and integrity of both. So a
+// salt must never be used for more than one plaintext. Everywhere else,
+// leave Params.Salt nil so a fresh salt is generated.
+func EncryptDeterministic(password []byte, salt []byte, src io.Reader, dst io.Writer, params *Params) error {
+	if params == nil {
+		return ErrNilParams
+	}
+	err := checkSaltLength(len(salt))
+	if err != nil {
+		return err
+	}
+
+	p := *params
+	p.Salt = bytes.Clone(salt)
+	p.SaltSize = uint8(len(salt))
+	key, err := Key(password, &p)
+	if err != nil {
+		return err
+	}
+	header, err := p.MarshalHeader()
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(header)
+	if err != nil {
+		return err
+	}
+
+	return Encrypt(key, src, dst, &p)
+}
@@ -0,0 +1,53 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEncryptDeterministic(t *testing.T) {
+	password := []byte("password")
+	salt := bytes.Repeat([]byte{7}, SaltSize)
+	plaintext := bytes.Repeat([]byte{'x'}, 300)
+	params := testParams()
+
+	var a, b bytes.Buffer
+	err := EncryptDeterministic(password, salt, bytes.NewReader(plaintext), &a, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = EncryptDeterministic(password, salt, bytes.NewReader(plaintext), &b, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Fatal("the same inputs encrypted differently")
+	}
+	if params.Salt != nil {
+		t.Fatal("EncryptDeterministic set the salt of params")
+	}
+
+	got, err := DecryptBytes(password, a.Bytes())
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("decrypted %d bytes, %v", len(got), err)
+	}
+
+	// Another salt gives another output, and so does the default path.
+	var c bytes.Buffer
+	err = EncryptDeterministic(password, bytes.Repeat([]byte{8}, SaltSize), bytes.NewReader(plaintext), &c, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a.Bytes(), c.Bytes()) {
+		t.Fatal("different salts encrypted the same")
+	}
+	if bytes.Equal(a.Bytes(), mustEncryptBytes(t, plaintext)) {
+		t.Fatal("EncryptBytes reused the salt")
+	}
+
+	err = EncryptDeterministic(password, []byte("short"), bytes.NewReader(plaintext), &c, params)
+	if !errors.Is(err, ErrSaltTooSmall) {
+		t.Fatalf("short salt: got error %v, want ErrSaltTooSmall", err)
+	}
+}
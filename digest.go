@@ -0,0 +1,48 @@
+package encdec
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"io"
+)
+
+// DigestSize is the length of Params.Digest.
+const DigestSize = sha256.Size
+
+var (
+	ErrDigest         = errors.New("invalid digest")
+	ErrDigestMismatch = errors.New("plaintext digest mismatch")
+)
+
+// Digest returns the SHA-256 digest of the data read from src until EOF,
+// to be set as Params.Digest before encrypting the same data. The digest
+// is not keyed, so see Params.Digest before publishing it in a header.
+func Digest(src io.Reader) ([]byte, error) {
+	h := sha256.New()
+	_, err := io.Copy(h, src)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+// digestWriter returns a writer that writes to dst and a function that
+// checks, once all the plaintext was written, that its digest matches
+// params.Digest, returning ErrDigestMismatch otherwise. If params have no
+// digest, dst is returned as is.
+func digestWriter(dst io.Writer, params *Params) (io.Writer, func() error) {
+	if len(params.Digest) == 0 {
+		return dst, func() error { return nil }
+	}
+
+	h := sha256.New()
+	check := func() error {
+		if !hmac.Equal(h.Sum(nil), params.Digest) {
+			return ErrDigestMismatch
+		}
+		return nil
+	}
+	return io.MultiWriter(dst, h), check
+}
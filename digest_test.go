@@ -0,0 +1,70 @@
+package encdec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestDigest(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	plaintext := bytes.Repeat([]byte{'x'}, 200)
+	digest, err := Digest(bytes.NewReader(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDigest, err := Digest(bytes.NewReader(plaintext[1:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// encrypt returns plaintext encrypted with digest in its header.
+	encrypt := func(digest []byte) []byte {
+		params := testParams()
+		params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+		params.Digest = digest
+		blob, err := encryptWithKey(key, plaintext, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return blob
+	}
+	// decrypt decrypts blob with both Reader and Decrypt, checking that
+	// they fail with want.
+	decrypt := func(name string, blob []byte, want error) {
+		got, err := decryptWithKey(key, blob)
+		if !errors.Is(err, want) || err == nil && !bytes.Equal(got, plaintext) {
+			t.Fatalf("%s: Reader returned %d bytes, error %v, want %v", name, len(got), err, want)
+		}
+
+		src := bytes.NewReader(blob)
+		params, err := ParseHeader(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		err = Decrypt(key, src, &out, params)
+		if !errors.Is(err, want) || err == nil && !bytes.Equal(out.Bytes(), plaintext) {
+			t.Fatalf("%s: Decrypt returned %d bytes, error %v, want %v", name, out.Len(), err, want)
+		}
+	}
+
+	matching := encrypt(digest)
+	decrypt("matching digest", matching, nil)
+	// The digest given when encrypting doesn't match the plaintext.
+	decrypt("other digest", encrypt(otherDigest), ErrDigestMismatch)
+
+	// The digest is authenticated, so one corrupted in the header, as
+	// well as corrupted ciphertext, fails before it is checked.
+	stored := []byte("$d=" + base64.RawStdEncoding.EncodeToString(digest))
+	corrupted := bytes.Replace(matching, stored,
+		[]byte("$d="+base64.RawStdEncoding.EncodeToString(otherDigest)), 1)
+	if bytes.Equal(corrupted, matching) {
+		t.Fatal("no digest in the header")
+	}
+	decrypt("corrupted digest", corrupted, ErrWrongKey)
+	corrupted = bytes.Clone(matching)
+	corrupted[bytes.IndexByte(corrupted, '\n')+1] ^= 1
+	decrypt("corrupted ciphertext", corrupted, ErrWrongKey)
+}
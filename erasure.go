@@ -0,0 +1,306 @@
+package encdec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// maxErasureChunks is the maximum of data and parity chunks in a group,
+// the most supported by Reed-Solomon codes over bytes.
+const maxErasureChunks = 256
+
+// DefaultMaxErasureGroup is the maximum length, in bytes, of the data and
+// parity chunks of a group read by Reader, unless set by
+// WithMaxErasureGroup.
+const DefaultMaxErasureGroup = 256 << 20
+
+var ErrErasure = errors.New("invalid erasure coding")
+
+// errErasureUnsupported is returned for params with Erasure by the
+// functions other than Writer and Reader.
+var errErasureUnsupported = fmt.Errorf("%w: only supported by Writer and Reader", ErrErasure)
+
+// Erasure configures the erasure coding of a stream: after every group of
+// Data chunks, Writer writes Parity chunks computed from their ciphertext
+// with Reed-Solomon codes, so Reader can recover up to Parity chunks of
+// each group that fail authentication, such as by bit rot.
+//
+// The parity chunks hold no more about the plaintext than the ciphertext
+// they are computed from, and what they recover is still authenticated.
+// Each group is read as a whole, so Reader holds Data+Parity chunks in
+// memory, besides the plaintext of the Data chunks, and refuses groups
+// longer than allowed by WithMaxErasureGroup.
+type Erasure struct {
+	Data   int
+	Parity int
+}
+
+func (p *Params) checkErasure() error {
+	e := p.Erasure
+	if e == (Erasure{}) {
+		return nil
+	}
+	if e.Data < 1 || e.Parity < 1 || e.Data+e.Parity > maxErasureChunks {
+		return fmt.Errorf("%w: %d data and %d parity chunks, must be at least 1 each and at most %d together",
+			ErrErasure, e.Data, e.Parity, maxErasureChunks)
+	}
+	if p.Format < FormatV3 {
+		return fmt.Errorf("%w: requires format %d, not %d", ErrErasure, FormatV3, p.Format)
+	}
+	if p.framed() {
+		return fmt.Errorf("%w: can't be combined with frames or compression", ErrErasure)
+	}
+	return nil
+}
+
+func (e Erasure) marshal() string {
+	return fmt.Sprintf("%d,%d", e.Data, e.Parity)
+}
+
+func parseErasure(s string) (Erasure, error) {
+	data, parity, ok := strings.Cut(s, ",")
+	if !ok {
+		return Erasure{}, errors.New("invalid erasure field")
+	}
+	var e Erasure
+	var err error
+	e.Data, err = strconv.Atoi(data)
+	if err != nil {
+		return Erasure{}, err
+	}
+	e.Parity, err = strconv.Atoi(parity)
+	if err != nil {
+		return Erasure{}, err
+	}
+	return e, nil
+}
+
+// erasureWriter computes the parity chunks of each group of chunks.
+type erasureWriter struct {
+	enc    reedsolomon.Encoder
+	data   int
+	shards [][]byte
+	n      int
+}
+
+func newErasureWriter(e Erasure, sealedSize int) (*erasureWriter, error) {
+	enc, err := reedsolomon.New(e.Data, e.Parity)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrErasure, err)
+	}
+
+	w := &erasureWriter{enc: enc, data: e.Data}
+	w.shards = make([][]byte, e.Data+e.Parity)
+	for i := range w.shards {
+		w.shards[i] = make([]byte, sealedSize)
+	}
+	return w, nil
+}
+
+// add adds the ciphertext of the next chunk to the group, returning the
+// parity chunks to write after it if it completes the group, or if it is
+// the last chunk. The parity chunks are only valid until the next call.
+func (w *erasureWriter) add(ciphertext []byte, last bool) ([][]byte, error) {
+	// The last chunk is shorter, so it is padded with zeros,
+	// and so are the chunks missing from the last group.
+	n := copy(w.shards[w.n], ciphertext)
+	clear(w.shards[w.n][n:])
+	w.n++
+	if w.n < w.data && !last {
+		return nil, nil
+	}
+
+	for _, shard := range w.shards[w.n:w.data] {
+		clear(shard)
+	}
+	w.n = 0
+	err := w.enc.Encode(w.shards)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrErasure, err)
+	}
+	return w.shards[w.data:], nil
+}
+
+// writeParity writes the parity chunks computed after the chunk just
+// written, if any.
+func (w *Writer) writeParity(ciphertext []byte, last bool) error {
+	parity, err := w.erasure.add(ciphertext, last)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range parity {
+		_, err = w.dst.Write(chunk)
+		if err != nil {
+			return fmt.Errorf("writing parity chunk: %w", err)
+		}
+		w.ciphertextOffset += int64(len(chunk))
+	}
+	return nil
+}
+
+// erasureReader reads the groups of chunks of erasureWriter, recovering
+// the chunks failing authentication from the parity chunks.
+type erasureReader struct {
+	enc        reedsolomon.Encoder
+	cipher     *chunkCipher
+	src        io.Reader
+	data       int
+	parity     int
+	group      []byte
+	shards     [][]byte
+	plaintexts [][]byte
+	next       int
+	lastGroup  bool
+}
+
+// newErasureReader creates an erasureReader for groups of e, refusing
+// groups longer than maxGroup bytes, unless it is <= 0.
+func newErasureReader(cipher *chunkCipher, src io.Reader, e Erasure, maxGroup int64) (*erasureReader, error) {
+	groupSize := int64(e.Data+e.Parity) * int64(cipher.sealedSize)
+	if maxGroup > 0 && groupSize > maxGroup {
+		return nil, fmt.Errorf("%w: erasure group of %d bytes exceeds %d", ErrLimitExceeded, groupSize, maxGroup)
+	}
+	enc, err := reedsolomon.New(e.Data, e.Parity)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrErasure, err)
+	}
+
+	r := &erasureReader{
+		enc:        enc,
+		cipher:     cipher,
+		src:        src,
+		data:       e.Data,
+		parity:     e.Parity,
+		group:      make([]byte, (e.Data+e.Parity)*cipher.sealedSize),
+		shards:     make([][]byte, e.Data+e.Parity),
+		plaintexts: make([][]byte, e.Data),
+		next:       e.Data,
+	}
+	for i := range r.plaintexts {
+		r.plaintexts[i] = make([]byte, 0, cipher.chunkSize)
+	}
+	return r, nil
+}
+
+// nextChunk returns the plaintext of the next chunk, reading the next
+// group if needed, and whether it is the last one.
+func (r *erasureReader) nextChunk() ([]byte, bool, error) {
+	if r.next == len(r.plaintexts) {
+		err := r.readGroup()
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	plaintext := r.plaintexts[r.next]
+	r.next++
+	return plaintext, r.lastGroup && r.next == len(r.plaintexts), nil
+}
+
+// readGroup reads and decrypts the next group, recovering its chunks
+// failing authentication if there are no more than parity chunks.
+func (r *erasureReader) readGroup() error {
+	n, err := io.ReadFull(r.src, r.group)
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	// A group is only short if it is the last one, whose last chunk
+	// is shorter than the others.
+	sealedSize := r.cipher.sealedSize
+	data := n - r.parity*sealedSize
+	chunks := r.data
+	lastSize := sealedSize
+	if n < len(r.group) {
+		if data < r.cipher.aead.Overhead() {
+			return io.ErrUnexpectedEOF
+		}
+		r.lastGroup = true
+		chunks = data/sealedSize + 1
+		lastSize = data % sealedSize
+		if lastSize < r.cipher.aead.Overhead() {
+			return io.ErrUnexpectedEOF
+		}
+		// The parity chunks were computed over zero padding.
+		parity := make([]byte, r.parity*sealedSize)
+		copy(parity, r.group[data:n])
+		clear(r.group[data:])
+		copy(r.group[r.data*sealedSize:], parity)
+	}
+	for i := range r.shards {
+		r.shards[i] = r.group[i*sealedSize : (i+1)*sealedSize]
+	}
+	r.plaintexts = r.plaintexts[:chunks]
+
+	var failed []int
+	var firstErr error
+	for i := range chunks {
+		err := r.open(i, chunks, lastSize)
+		if err != nil {
+			failed = append(failed, i)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if len(failed) > 0 {
+		if len(failed) > r.parity {
+			return firstErr
+		}
+		for _, i := range failed {
+			r.shards[i] = nil
+		}
+		err = r.enc.ReconstructData(r.shards)
+		if err != nil {
+			return firstErr
+		}
+		for _, i := range failed {
+			err = r.open(i, chunks, lastSize)
+			if err != nil {
+				return firstErr
+			}
+		}
+	}
+
+	r.cipher.seek(r.cipher.index + uint64(chunks))
+	r.next = 0
+	return nil
+}
+
+// open decrypts chunk i of a group of the given number of chunks,
+// where the last one is lastSize bytes long.
+func (r *erasureReader) open(i int, chunks int, lastSize int) error {
+	ciphertext := r.shards[i]
+	last := r.lastGroup && i == chunks-1
+	if i == chunks-1 {
+		ciphertext = ciphertext[:lastSize]
+	}
+
+	plaintext, err := r.cipher.openAt(r.plaintexts[i][:0], ciphertext, r.cipher.index+uint64(i), last)
+	if err != nil {
+		return err
+	}
+	r.plaintexts[i] = plaintext
+	return nil
+}
+
+// readErasure reads the next chunk into the buffer of r, returning its
+// plaintext.
+func (r *Reader) readErasure() ([]byte, bool, error) {
+	plaintext, last, err := r.erasure.nextChunk()
+	if err != nil {
+		return nil, false, err
+	}
+
+	plaintext = append(r.pooled[:0], plaintext...)
+	r.config.logf("encdec: read chunk of %d bytes", len(plaintext))
+	return plaintext, last, nil
+}
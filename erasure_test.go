@@ -0,0 +1,122 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestErasureGroupLimit(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	params.Erasure = Erasure{Data: 4, Parity: 2}
+	err := params.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	w, err := NewWriter(key, &out, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(bytes.Repeat([]byte{'x'}, 500))
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A group holds 6 chunks of 64+16 bytes.
+	for _, tt := range []struct {
+		max int64
+		err error
+	}{{480, nil}, {479, ErrLimitExceeded}, {0, nil}} {
+		r, err := NewReader(key, bytes.NewReader(out.Bytes()), params, WithMaxErasureGroup(tt.max))
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("limit %d: got error %v, want %v", tt.max, err, tt.err)
+		}
+		if err != nil {
+			continue
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || len(got) != 500 {
+			t.Fatalf("limit %d: read %d bytes, %v", tt.max, len(got), err)
+		}
+	}
+
+	// Without the option, a header can't make the Reader allocate
+	// more than DefaultMaxErasureGroup.
+	huge := *params
+	huge.Erasure = Erasure{Data: 200, Parity: 56}
+	huge.ChunkSize = 16 << 20
+	_, err = NewReader(key, bytes.NewReader(nil), &huge)
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("got error %v, want ErrLimitExceeded", err)
+	}
+}
+
+func TestErasureRecovery(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	params.Erasure = Erasure{Data: 4, Parity: 2}
+	plaintext := make([]byte, 500)
+	for i := range plaintext {
+		plaintext[i] = byte(i)
+	}
+	blob, err := encryptWithKey(key, plaintext, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := bytes.IndexByte(blob, '\n') + 1
+	if len(blob)-header != 480+308+160 {
+		t.Fatalf("ciphertext of %d bytes, want %d", len(blob)-header, 480+308+160)
+	}
+
+	// decrypt decrypts blob with a byte flipped in every chunk starting
+	// at the given offsets of the ciphertext.
+	decrypt := func(offsets ...int) ([]byte, error) {
+		corrupted := bytes.Clone(blob)
+		for _, offset := range offsets {
+			corrupted[header+offset+10] ^= 1
+		}
+		return decryptWithKey(key, corrupted)
+	}
+
+	// Chunks of 64+16 bytes: a full group of 4 data chunks and 2
+	// parity chunks, then the last group, of 3 full data chunks, the
+	// last chunk of 52+16 bytes, and 2 parity chunks.
+	for _, tt := range []struct {
+		name    string
+		offsets []int
+	}{
+		{"data chunk", []int{80}},
+		{"first chunk", []int{0}},
+		{"parity chunk", []int{320}},
+		{"data and parity chunks", []int{80, 400}},
+		{"two data chunks", []int{0, 240}},
+		{"chunk of the last group", []int{480 + 80}},
+		{"last chunk", []int{480 + 240}},
+		{"parity chunk of the last group", []int{480 + 308}},
+		{"chunks of both groups", []int{160, 480 + 160}},
+	} {
+		got, err := decrypt(tt.offsets...)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("%s: recovered data doesn't match the plaintext", tt.name)
+		}
+	}
+
+	// More chunks of a group than parity chunks can't be recovered.
+	_, err = decrypt(0, 80, 160)
+	if !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("three chunks: got error %v, want ErrWrongKey", err)
+	}
+	_, err = decrypt(480, 480+80, 480+240)
+	if err == nil {
+		t.Fatal("three chunks of the last group were recovered")
+	}
+}
@@ -0,0 +1,111 @@
+package encdec
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"math"
+)
+
+// NonceSize returns the length of the nonce of the cipher of params.
+// Every nonce is derived from the index of its chunk, so none is stored.
+func NonceSize(params *Params) (int, error) {
+	aead, err := paramsAEAD(params)
+	if err != nil {
+		return 0, err
+	}
+	return aead.NonceSize(), nil
+}
+
+// Overhead returns the length the cipher of params adds to every chunk.
+// The frames of params with Frames or Compress take 4 bytes more each,
+// which CiphertextSize accounts for.
+func Overhead(params *Params) (int, error) {
+	aead, err := paramsAEAD(params)
+	if err != nil {
+		return 0, err
+	}
+	return aead.Overhead(), nil
+}
+
+// paramsAEAD returns the AEAD of the cipher of params, with a zero key,
+// to tell its sizes, which don't depend on the key.
+func paramsAEAD(params *Params) (cipher.AEAD, error) {
+	if params == nil {
+		return nil, ErrNilParams
+	}
+	err := params.checkFormatted()
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(make([]byte, params.KeySize), params.Cipher)
+}
+
+// CiphertextSize returns the length of the encrypted data written by Writer
+// for plaintextSize bytes of plaintext with params, without the header.
+// With Compress it is the length if no chunk gets shorter, which the
+// actual length never exceeds.
+func CiphertextSize(plaintextSize int64, params *Params) (int64, error) {
+	if plaintextSize < 0 {
+		return 0, fmt.Errorf("negative plaintext size %d", plaintextSize)
+	}
+	aead, err := paramsAEAD(params)
+	if err != nil {
+		return 0, err
+	}
+	overhead := int64(aead.Overhead())
+	if params.framed() {
+		overhead += frameHeaderSize
+	}
+
+	n := plaintextSize
+	if params.Trailer {
+		n += trailerSize
+	}
+	// The last chunk is always shorter than the others, so there is one
+	// more chunk when the plaintext ends in a chunk boundary.
+	chunks := n/params.ChunkSize + 1
+	sizes := []int64{n, chunks * overhead}
+	if params.Erasure != (Erasure{}) {
+		groups := (chunks + int64(params.Erasure.Data) - 1) / int64(params.Erasure.Data)
+		parity := groups * int64(params.Erasure.Parity)
+		sizes = append(sizes, parity*params.ChunkSize, parity*int64(aead.Overhead()))
+	}
+
+	var total int64
+	for _, size := range sizes {
+		if size < 0 || total > math.MaxInt64-size {
+			return 0, fmt.Errorf("%w: %d bytes of plaintext", ErrInputTooLarge, plaintextSize)
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// EstimateJob returns the length of the encrypted data, header included,
+// of encrypting plaintextTotal bytes as a single stream with params, and
+// the number of Argon2 key derivations it takes, so the disk space and the
+// time needed can be checked before starting. A job of several files is
+// estimated by adding up the estimates of each file, as every file has its
+// own salt and so its own key.
+func EstimateJob(plaintextTotal int64, params *Params) (ciphertextTotal int64, argonDerivations int, err error) {
+	ciphertextTotal, err = CiphertextSize(plaintextTotal, params)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The salt is only generated when encrypting, so one of the same
+	// length stands for it.
+	p := *params
+	if len(p.Salt) == 0 {
+		p.Salt = make([]byte, p.SaltSize)
+	}
+	header, err := p.MarshalHeader()
+	if err != nil {
+		return 0, 0, err
+	}
+	if ciphertextTotal > math.MaxInt64-int64(len(header)) {
+		return 0, 0, fmt.Errorf("%w: %d bytes of plaintext", ErrInputTooLarge, plaintextTotal)
+	}
+
+	return ciphertextTotal + int64(len(header)), 1, nil
+}
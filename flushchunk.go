@@ -0,0 +1,66 @@
+package encdec
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrFrames = errors.New("invalid frames")
+
+// errFramesUnsupported is returned for params with Frames by the
+// functions other than Writer and Reader.
+var errFramesUnsupported = fmt.Errorf("%w: only supported by Writer and Reader", ErrFrames)
+
+// errNotFramed is returned by FlushChunk for streams of fixed size chunks.
+var errNotFramed = fmt.Errorf("%w: flushing a chunk requires Frames or Compress", ErrFrames)
+
+func (p *Params) checkFrames() error {
+	if p.Frames && p.Format < FormatV3 {
+		return fmt.Errorf("%w: requires format %d, not %d", ErrFrames, FormatV3, p.Format)
+	}
+	if p.Frames && p.ChunkSize+tagSize > frameSizeMask {
+		return fmt.Errorf("%w: %d with frames", ErrChunkSizeTooLarge, p.ChunkSize)
+	}
+
+	return nil
+}
+
+// framed reports whether the chunks of params are stored as frames.
+func (p *Params) framed() bool {
+	return p.Frames || p.Compress
+}
+
+// FlushChunk encrypts and writes the data buffered so far as a chunk of
+// its own, without waiting for it to fill, so the other side can decrypt
+// it right away, as Reader returns from Read once a chunk is read if it
+// has any data for it. It suits interactive streams, where latency
+// matters more than the overhead of shorter chunks. Calling it with
+// nothing buffered does nothing. With a Trailer, Reader still holds back
+// as much plaintext as a trailer takes until the next chunk, as it may be
+// the trailer.
+//
+// It requires params with Frames or Compress, as only the last chunk of
+// a stream of fixed size chunks may be shorter. With WithBufferedChunks it
+// waits for the chunks queued to be written. The underlying writer isn't
+// flushed, which is up to the caller, as it would end a block of
+// WithBlockSize before it is full.
+func (w *Writer) FlushChunk() error {
+	if w == nil || w.cipher == nil {
+		return ErrUninitialized
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if w.frames == nil {
+		return errNotFramed
+	}
+	if w.buff.Len() == 0 {
+		return nil
+	}
+
+	w.err = w.flush(false)
+	if w.err == nil && w.queue != nil {
+		w.err = w.queue.wait()
+	}
+	return w.err
+}
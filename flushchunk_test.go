@@ -0,0 +1,95 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestFlushChunk(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	// Pieces written between flushes, shorter and longer than a chunk.
+	pieces := []int{1, 10, 0, 64, 63, 65, 200, 5}
+
+	for _, compress := range []bool{false, true} {
+		params := testParams()
+		params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+		params.Frames = !compress
+		params.Compress = compress
+
+		pr, pw := io.Pipe()
+		w, err := NewWriter(key, pw, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewReader(key, pr, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Each piece is read once it is flushed, before the next one
+		// is written.
+		type result struct {
+			got []byte
+			err error
+		}
+		results := make(chan result)
+		go func() {
+			for _, n := range pieces {
+				got := make([]byte, n)
+				_, err := io.ReadFull(r, got)
+				results <- result{got, err}
+			}
+			rest, err := io.ReadAll(r)
+			results <- result{rest, err}
+		}()
+
+		for i, n := range pieces {
+			piece := bytes.Repeat([]byte{byte(i)}, n)
+			written := make(chan error)
+			go func() {
+				_, err := w.Write(piece)
+				if err == nil {
+					err = w.FlushChunk()
+				}
+				written <- err
+			}()
+
+			select {
+			case res := <-results:
+				if res.err != nil || !bytes.Equal(res.got, piece) {
+					t.Fatalf("compress %v, piece %d: read %d bytes, %v", compress, i, len(res.got), res.err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("compress %v, piece %d: not read after FlushChunk", compress, i)
+			}
+			err := <-written
+			if err != nil {
+				t.Fatalf("compress %v, piece %d: %v", compress, i, err)
+			}
+		}
+
+		go func() {
+			pw.CloseWithError(w.Close())
+		}()
+		res := <-results
+		if res.err != nil || len(res.got) != 0 {
+			t.Fatalf("compress %v: read %d more bytes, %v", compress, len(res.got), res.err)
+		}
+	}
+
+	// Only the last chunk of fixed size chunks may be short.
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	w, err := NewWriter(key, io.Discard, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("data"))
+	err = w.FlushChunk()
+	if !errors.Is(err, ErrFrames) {
+		t.Fatalf("got error %v, want ErrFrames", err)
+	}
+}
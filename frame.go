@@ -0,0 +1,157 @@
+package encdec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// frameHeaderSize is the size of the word prefixing every frame.
+	frameHeaderSize = 4
+
+	// frameLast marks the last frame of a stream in its header.
+	frameLast = 1 << 31
+
+	// frameCompressed marks a compressed frame in its header,
+	// only in streams with Params.Compress.
+	frameCompressed = 1 << 30
+
+	// frameSizeMask is the part of the header with the length of the frame.
+	frameSizeMask = frameCompressed - 1
+)
+
+// frameWriter encrypts chunks of variable length as frames, each one prefixed
+// by a word with the length of its ciphertext and whether it is the last
+// frame. The word is authenticated along with the chunk, so it can't be
+// changed without failing authentication.
+type frameWriter struct {
+	cipher *chunkCipher
+	buff   []byte
+}
+
+func newFrameWriter(key []byte, params *Params) (*frameWriter, error) {
+	if params.Format < FormatV2 {
+		return nil, errors.New("framing requires format 2 or later")
+	}
+	cipher, err := newChunkCipher(key, params, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &frameWriter{
+		cipher: cipher,
+	}
+	return w, nil
+}
+
+// sealFrame encrypts plaintext as the next frame.
+// The returned frame is only valid until the next call.
+func (w *frameWriter) sealFrame(plaintext []byte, last bool) ([]byte, error) {
+	return w.sealFrameCompressed(plaintext, last, false)
+}
+
+// sealFrameCompressed works like sealFrame, marking the frame as compressed
+// if compressed is true.
+func (w *frameWriter) sealFrameCompressed(plaintext []byte, last bool, compressed bool) ([]byte, error) {
+	header := uint32(len(plaintext) + w.cipher.aead.Overhead())
+	if last {
+		header |= frameLast
+	}
+	if compressed {
+		header |= frameCompressed
+	}
+
+	w.buff = binary.BigEndian.AppendUint32(w.buff[:0], header)
+	var err error
+	w.buff, err = w.cipher.sealExtra(w.buff, plaintext, last, w.buff[:frameHeaderSize])
+	if err != nil {
+		return nil, err
+	}
+	return w.buff, nil
+}
+
+// frameReader reads and decrypts the frames of frameWriter.
+type frameReader struct {
+	cipher    *chunkCipher
+	src       io.Reader
+	chunkSize int
+	buff      []byte
+	lastFrame bool
+
+	// compressed allows frames marked as compressed.
+	compressed bool
+}
+
+func newFrameReader(key []byte, src io.Reader, params *Params) (*frameReader, error) {
+	if params.Format < FormatV2 {
+		return nil, errors.New("framing requires format 2 or later")
+	}
+	cipher, err := newChunkCipher(key, params, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &frameReader{
+		cipher:    cipher,
+		src:       src,
+		chunkSize: cipher.chunkSize,
+	}
+	return r, nil
+}
+
+// readFrame reads and decrypts the next frame, returning io.EOF after the
+// last one. The returned plaintext is only valid until the next call.
+func (r *frameReader) readFrame() ([]byte, bool, error) {
+	plaintext, flags, err := r.readFrameFlags()
+	return plaintext, flags&frameLast != 0, err
+}
+
+// readFrameFlags works like readFrame, returning the flags of the frame
+// header, frameLast and frameCompressed, instead of whether it is the last.
+func (r *frameReader) readFrameFlags() ([]byte, uint32, error) {
+	if r.lastFrame {
+		return nil, 0, io.EOF
+	}
+
+	var header [frameHeaderSize]byte
+	_, err := io.ReadFull(r.src, header[:])
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+
+	word := binary.BigEndian.Uint32(header[:])
+	last := word&frameLast != 0
+	flags := word &^ frameSizeMask
+	if !r.compressed {
+		flags &= frameLast
+	}
+	size := int(word &^ flags)
+	overhead := r.cipher.aead.Overhead()
+	if size > r.chunkSize+overhead {
+		return nil, 0, errors.New("invalid frame size")
+	}
+	if size < overhead {
+		return nil, 0, fmt.Errorf("%w: frame of %d bytes", ErrShortChunk, size)
+	}
+
+	r.buff = append(r.buff[:0], make([]byte, size)...)
+	_, err = io.ReadFull(r.src, r.buff)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+
+	plaintext, err := r.cipher.openExtra(r.buff[:0], r.buff, last, header[:])
+	if err != nil {
+		return nil, 0, err
+	}
+	r.lastFrame = last
+	return plaintext, flags, nil
+}
@@ -3,9 +3,14 @@ module github.com/bernardo1r/encdec
 go 1.23
 
 require (
+	github.com/klauspost/reedsolomon v1.12.4
 	golang.org/x/crypto v0.26.0
 	golang.org/x/sync v0.8.0
 	golang.org/x/term v0.23.0
+	golang.org/x/text v0.17.0
 )
 
-require golang.org/x/sys v0.24.0 // indirect
+require (
+	github.com/klauspost/cpuid/v2 v2.2.8 // indirect
+	golang.org/x/sys v0.24.0 // indirect
+)
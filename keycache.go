@@ -0,0 +1,119 @@
+package encdec
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+)
+
+// KeyCache caches keys derived from passwords and params, so decrypting
+// data with the same header over and over, such as serving many range
+// requests of the same object, only runs Argon2 once. It holds up to a
+// fixed number of keys, evicting the least recently used one, and is
+// safe for concurrent use.
+//
+// Keys are cached by a MAC of the password, pepper and params under a
+// secret generated for each cache, so the cache never holds passwords
+// or anything that allows testing guesses of them. Evicted keys are
+// wiped from memory.
+type KeyCache struct {
+	mu      sync.Mutex
+	secret  []byte
+	size    int
+	entries map[[sha256.Size]byte]*list.Element
+	lru     *list.List
+}
+
+type keyCacheEntry struct {
+	id  [sha256.Size]byte
+	key []byte
+}
+
+// NewKeyCache creates a KeyCache holding up to size keys.
+func NewKeyCache(size int) (*KeyCache, error) {
+	secret, err := random(sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &KeyCache{
+		secret:  secret,
+		size:    max(size, 1),
+		entries: make(map[[sha256.Size]byte]*list.Element),
+		lru:     list.New(),
+	}
+	return c, nil
+}
+
+// Key works like Key, returning the cached key if it was already derived.
+func (c *KeyCache) Key(password []byte, params *Params) ([]byte, error) {
+	return c.KeyWithPepper(password, nil, params)
+}
+
+// KeyWithPepper works like KeyWithPepper, returning the cached key if it
+// was already derived. Params without a salt aren't cached, as deriving
+// their key generates a new one.
+func (c *KeyCache) KeyWithPepper(password []byte, pepper []byte, params *Params) ([]byte, error) {
+	if params == nil {
+		return nil, ErrNilParams
+	}
+	if params.Salt == nil {
+		return KeyWithPepper(password, pepper, params)
+	}
+
+	header, err := params.MarshalHeader()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(header)
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(len(pepper))))
+	mac.Write(pepper)
+	mac.Write(password)
+	var id [sha256.Size]byte
+	mac.Sum(id[:0])
+
+	c.mu.Lock()
+	elem, ok := c.entries[id]
+	if ok {
+		c.lru.MoveToFront(elem)
+		key := bytes.Clone(elem.Value.(*keyCacheEntry).key)
+		c.mu.Unlock()
+		return key, nil
+	}
+	c.mu.Unlock()
+
+	key, err := KeyWithPepper(password, pepper, params)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[id]; !ok {
+		entry := &keyCacheEntry{id: id, key: bytes.Clone(key)}
+		c.entries[id] = c.lru.PushFront(entry)
+		for c.lru.Len() > c.size {
+			c.evict(c.lru.Back())
+		}
+	}
+	return key, nil
+}
+
+// Purge wipes and removes every cached key.
+func (c *KeyCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.evict(c.lru.Back())
+	}
+}
+
+func (c *KeyCache) evict(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*keyCacheEntry)
+	delete(c.entries, entry.id)
+	clear(entry.key)
+}
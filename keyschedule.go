@@ -0,0 +1,52 @@
+package encdec
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Labels of the subkeys of a keySet, each one naming its single purpose.
+const (
+	dataKeyLabel     = "encdec subkey: data"
+	headerKeyLabel   = "encdec subkey: header"
+	metadataKeyLabel = "encdec subkey: metadata"
+)
+
+// keySet holds the subkeys derived from a single Argon2 result by
+// deriveSubkeys, so no key is ever used for two purposes.
+type keySet struct {
+	// data encrypts the chunks.
+	data []byte
+
+	// header authenticates the header.
+	header []byte
+
+	// metadata encrypts the metadata stored along with the chunks.
+	metadata []byte
+}
+
+// deriveSubkeys is the key schedule: an HKDF-Extract of argonOutput with
+// salt, the one stored in the header or another random value, followed by
+// an HKDF-Expand of every subkey with its label.
+//
+// Since FormatV4 the chunks are encrypted with the data subkey. The
+// earlier formats encrypt them with the Argon2 result itself, which is
+// kept so their files still decrypt.
+func deriveSubkeys(argonOutput []byte, salt []byte) keySet {
+	prk := hkdf.Extract(sha256.New, argonOutput, salt)
+	return keySet{
+		data:     expandSubkey(prk, dataKeyLabel),
+		header:   expandSubkey(prk, headerKeyLabel),
+		metadata: expandSubkey(prk, metadataKeyLabel),
+	}
+}
+
+// expandSubkey expands the pseudorandom key prk into the subkey of label.
+func expandSubkey(prk []byte, label string) []byte {
+	subkey := make([]byte, keySize)
+	// HKDF-Expand only fails past 255 hashes of output.
+	io.ReadFull(hkdf.Expand(sha256.New, prk, []byte(label)), subkey)
+	return subkey
+}
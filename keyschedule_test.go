@@ -0,0 +1,75 @@
+package encdec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestDeriveSubkeys(t *testing.T) {
+	argonOutput := make([]byte, keySize)
+	for i := range argonOutput {
+		argonOutput[i] = byte(i)
+	}
+	salt := make([]byte, SaltSize)
+	for i := range salt {
+		salt[i] = byte(0x80 + i)
+	}
+
+	keys := deriveSubkeys(argonOutput, salt)
+	for _, tt := range []struct {
+		name   string
+		subkey []byte
+		want   string
+	}{
+		{"data", keys.data, "a04d82ede4aeac6e791b4b67e5ed8237485683a069e229055e2320c85b838ba5"},
+		{"header", keys.header, "9f1ebf4cdf8bd9b006b782d7257ad6f0e92e3ad611ff028609f999a8422d61c0"},
+		{"metadata", keys.metadata, "ce903c848c4da631729beabbb85fa895ff91a38747454b3fb6a6854af7747f79"},
+	} {
+		if got := hex.EncodeToString(tt.subkey); got != tt.want {
+			t.Errorf("%s subkey is %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFormatV4Subkey(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+
+	var sealed bytes.Buffer
+	for _, format := range []uint8{FormatV3, FormatV4} {
+		params.Format = format
+		sealed.Reset()
+		err := Encrypt(key, bytes.NewReader([]byte("data")), &sealed, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		err = Decrypt(key, bytes.NewReader(sealed.Bytes()), &out, params)
+		if err != nil || out.String() != "data" {
+			t.Fatalf("format %d: Decrypt returned %q, %v", format, out.String(), err)
+		}
+	}
+
+	// The chunks of FormatV4 are sealed with the data subkey, not with
+	// the key itself.
+	c, err := newChunkCipher(key, params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ad := c.additionalData(nil, 0, true)
+	for _, tt := range []struct {
+		key   []byte
+		opens bool
+	}{{deriveSubkeys(key, params.Salt).data, true}, {key, false}} {
+		aead, err := newAEAD(tt.key, params.Cipher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = aead.Open(nil, c.nonce[:], sealed.Bytes(), ad)
+		if (err == nil) != tt.opens {
+			t.Fatalf("opening with key %x: got error %v", tt.key, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MaxEncryptReaderSize is the maximum length of the data encrypted by
+// EncryptReader, which is held in memory as a whole.
+const MaxEncryptReaderSize = 1 << 30
+
+var (
+	ErrInputTooLarge     = errors.New("input too large")
+	ErrRoundTripMismatch = errors.New("decrypted data doesn't match the plaintext")
+)
+
+// EncryptBytes encrypts plaintext with a key derived from password and
+// params, returning the encrypted data preceded by its header. Every call
+// generates a fresh salt, ignoring the Salt of params, so reusing params
+// never encrypts different plaintexts under the same key and nonces.
+// params isn't modified.
+func EncryptBytes(password []byte, plaintext []byte, params *Params) ([]byte, error) {
+	return encryptMemory(password, bytes.NewReader(plaintext), params)
+}
+
+// DecryptBytes decrypts blob, as returned by EncryptBytes, with a key
+// derived from password and the params in its header.
+func DecryptBytes(password []byte, blob []byte) ([]byte, error) {
+	src := bytes.NewReader(blob)
+	params, err := ParseHeader(src)
+	if err != nil {
+		return nil, err
+	}
+	key, err := Key(password, params)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewReader(key, src, params)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// RoundTrip encrypts plaintext with password and params, as EncryptBytes,
+// and decrypts the result back, as DecryptBytes, from the params parsed
+// from its header, returning the decrypted data. If it differs from
+// plaintext, an error wrapping ErrRoundTripMismatch is returned. It checks
+// params, including every field of the header, before they are relied on.
+func RoundTrip(password []byte, plaintext []byte, params *Params) ([]byte, error) {
+	blob, err := EncryptBytes(password, plaintext, params)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting: %w", err)
+	}
+	decrypted, err := DecryptBytes(password, blob)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting: %w", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		return nil, fmt.Errorf("%w: %d bytes decrypted from %d", ErrRoundTripMismatch, len(decrypted), len(plaintext))
+	}
+
+	return decrypted, nil
+}
+
+// EncryptReader reads src until EOF, encrypting it with a key derived from
+// password and params, and returns the encrypted data preceded by its
+// header. It saves wiring a Writer to a bytes.Buffer when the length of src
+// isn't known, such as a pipe. Like EncryptBytes, it generates a fresh salt
+// on every call.
+//
+// If src holds more than MaxEncryptReaderSize bytes, an error wrapping
+// ErrInputTooLarge is returned, so the memory used stays bounded.
+func EncryptReader(password []byte, src io.Reader, params *Params) ([]byte, error) {
+	limited := &io.LimitedReader{R: src, N: MaxEncryptReaderSize + 1}
+	blob, err := encryptMemory(password, limited, params)
+	if err != nil {
+		return nil, err
+	}
+	if limited.N == 0 {
+		return nil, fmt.Errorf("%w: exceeds %d bytes", ErrInputTooLarge, MaxEncryptReaderSize)
+	}
+
+	return blob, nil
+}
+
+// encryptMemory encrypts src into memory with a fresh salt, leaving params
+// unchanged.
+func encryptMemory(password []byte, src io.Reader, params *Params) ([]byte, error) {
+	if params == nil {
+		return nil, ErrNilParams
+	}
+	p := *params
+	p.Salt = nil
+	params = &p
+
+	key, err := Key(password, params)
+	if err != nil {
+		return nil, err
+	}
+	header, err := params.MarshalHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	buff := bytes.NewBuffer(header)
+	w, err := NewWriter(key, buff, params)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(w, src)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
@@ -0,0 +1,84 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// testParams returns params cheap enough to derive keys in tests.
+func testParams() *Params {
+	return &Params{ArgonMemory: 64, ArgonThreads: 1, ChunkSize: 64}
+}
+
+func TestEncryptBytesFreshSalt(t *testing.T) {
+	params := testParams()
+	a, err := EncryptBytes([]byte("password"), []byte("first"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := EncryptBytes([]byte("password"), []byte("second"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Salt != nil {
+		t.Fatal("EncryptBytes set the salt of params")
+	}
+
+	pa, err := ParseHeader(bytes.NewReader(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb, err := ParseHeader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(pa.Salt, pb.Salt) {
+		t.Fatal("reused params encrypted under the same salt")
+	}
+
+	// A salt set by the caller is ignored as well.
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	c, err := EncryptValue([]byte("password"), "value", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := ParseHeader(bytes.NewReader(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(pc.Salt, params.Salt) {
+		t.Fatal("EncryptValue used the salt of params")
+	}
+	v, err := DecryptValue[string]([]byte("password"), c)
+	if err != nil || v != "value" {
+		t.Fatalf("DecryptValue returned %q, %v", v, err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, 64, 65, 1000} {
+		plaintext := bytes.Repeat([]byte{'x'}, size)
+		got, err := RoundTrip([]byte("password"), plaintext, testParams())
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("%d bytes: round trip changed the data", size)
+		}
+	}
+
+	_, err := DecryptBytes([]byte("wrong"), mustEncryptBytes(t, []byte("data")))
+	if !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("got error %v, want ErrWrongKey", err)
+	}
+}
+
+func mustEncryptBytes(t *testing.T, plaintext []byte) []byte {
+	t.Helper()
+	blob, err := EncryptBytes([]byte("password"), plaintext, testParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blob
+}
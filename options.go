@@ -0,0 +1,144 @@
+package encdec
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io"
+	"log"
+)
+
+// config holds the settings shared by Writer and Reader.
+type config struct {
+	aad             []byte
+	closeUnderlying bool
+	logger          *log.Logger
+}
+
+func (c *config) logf(format string, v ...any) {
+	if c.logger != nil {
+		c.logger.Printf(format, v...)
+	}
+}
+
+type writerConfig struct {
+	config
+	chunkLog       io.Writer
+	blockSize      int
+	bufferedChunks int
+	chunkOffsets   func(ChunkOffset) error
+}
+
+type readerConfig struct {
+	config
+	policy        *Policy
+	maxCiphertext int64
+	maxErasure    int64
+	trailingData  bool
+	publicKey     ed25519.PublicKey
+}
+
+func (c *readerConfig) checkPolicy(params *Params) error {
+	if c.policy == nil {
+		return nil
+	}
+	return c.policy.Check(params)
+}
+
+// WriterOption configures a Writer created by NewWriter.
+//
+// It is an interface rather than a func(*writerConfig) so an Option,
+// such as WithAAD, is a WriterOption and a ReaderOption at once, and
+// the same value can be given to both sides.
+type WriterOption interface {
+	applyWriter(*writerConfig)
+}
+
+// ReaderOption configures a Reader created by NewReader.
+type ReaderOption interface {
+	applyReader(*readerConfig)
+}
+
+// Option configures both Writer and Reader, so it can be passed
+// either as a WriterOption or as a ReaderOption.
+type Option func(*config)
+
+func (o Option) applyWriter(c *writerConfig) { o(&c.config) }
+
+func (o Option) applyReader(c *readerConfig) { o(&c.config) }
+
+type writerOptionFunc func(*writerConfig)
+
+func (o writerOptionFunc) applyWriter(c *writerConfig) { o(c) }
+
+type readerOptionFunc func(*readerConfig)
+
+func (o readerOptionFunc) applyReader(c *readerConfig) { o(c) }
+
+// WithAAD authenticates aad, without encrypting it, along with every chunk.
+// The same aad must be given to decrypt the data.
+func WithAAD(aad []byte) Option {
+	aad = bytes.Clone(aad)
+	return func(c *config) {
+		c.aad = aad
+	}
+}
+
+// WithCloseUnderlying makes Close also close the underlying writer or
+// reader, if it implements io.Closer.
+func WithCloseUnderlying() Option {
+	return func(c *config) {
+		c.closeUnderlying = true
+	}
+}
+
+// WithLogger logs the processing of every chunk to logger.
+func WithLogger(logger *log.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// WithPolicy makes the Reader refuse params not allowed by policy,
+// returning an error wrapping ErrPolicyViolation.
+func WithPolicy(policy *Policy) ReaderOption {
+	return readerOptionFunc(func(c *readerConfig) {
+		c.policy = policy
+	})
+}
+
+// WithMaxCiphertext makes the Reader read at most n bytes of encrypted
+// data from the underlying reader, returning ErrLimitExceeded if it holds
+// more. This bounds the data consumed from an untrusted source, such as
+// a peer that keeps sending chunks. A value of n <= 0 means no limit.
+func WithMaxCiphertext(n int64) ReaderOption {
+	return readerOptionFunc(func(c *readerConfig) {
+		c.maxCiphertext = n
+	})
+}
+
+// WithMaxErasureGroup makes the Reader refuse a stream with Erasure whose
+// groups, which are held in memory as a whole, take more than n bytes of
+// data and parity chunks, returning ErrLimitExceeded. This bounds the
+// memory a header can make the Reader allocate. Without it, the limit is
+// DefaultMaxErasureGroup. A value of n <= 0 means no limit.
+func WithMaxErasureGroup(n int64) ReaderOption {
+	return readerOptionFunc(func(c *readerConfig) {
+		c.maxErasure = n
+	})
+}
+
+func newWriterConfig(opts []WriterOption) *writerConfig {
+	c := new(writerConfig)
+	for _, opt := range opts {
+		opt.applyWriter(c)
+	}
+	return c
+}
+
+func newReaderConfig(opts []ReaderOption) *readerConfig {
+	c := &readerConfig{maxErasure: DefaultMaxErasureGroup}
+	for _, opt := range opts {
+		opt.applyReader(c)
+	}
+	return c
+}
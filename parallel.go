@@ -5,8 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"runtime"
 
-	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -19,20 +19,21 @@ func Encrypt(key []byte, src io.Reader, dst io.Writer, params *Params) error {
 	if err != nil {
 		return err
 	}
+	err = params.checkStreamOnly()
+	if err != nil {
+		return err
+	}
 
-	aead, err := chacha20poly1305.New(key)
+	cipher, err := newChunkCipher(key, params, nil)
 	if err != nil {
 		return err
 	}
-	nonce := make([]byte, chacha20poly1305.NonceSize)
 	err = process(src,
-		int(params.ChunkSize),
+		cipher.chunkSize,
 		dst,
-		int(params.ChunkSize)+aead.Overhead(),
-		func(input []byte, output []byte) ([]byte, error) {
-			ciphertext := aead.Seal(output[:0], nonce, input, nil)
-			err := incNonce(nonce)
-			return ciphertext, err
+		cipher.sealedSize,
+		func(input []byte, output []byte, last bool) ([]byte, error) {
+			return cipher.seal(output[:0], input, last)
 		},
 	)
 	if err != nil {
@@ -42,8 +43,58 @@ func Encrypt(key []byte, src io.Reader, dst io.Writer, params *Params) error {
 	return nil
 }
 
+// EncryptMulti works like Encrypt, writing the same encrypted data to every
+// writer of dsts. A writer that fails is no longer written to, while the
+// others keep going, so a failing destination doesn't stop the others from
+// getting the whole data. The errors of the failed writers are returned
+// joined, each one telling the index of its writer in dsts. Encryption
+// only stops early if every writer fails.
+func EncryptMulti(key []byte, src io.Reader, dsts []io.Writer, params *Params) error {
+	if len(dsts) == 0 {
+		return errors.New("no destinations")
+	}
+
+	w := &fanOutWriter{
+		dsts: dsts,
+		errs: make([]error, len(dsts)),
+	}
+	err := Encrypt(key, src, w, params)
+	if w.failed == len(dsts) {
+		return err
+	}
+
+	return errors.Join(append([]error{err}, w.errs...)...)
+}
+
+// fanOutWriter writes to every writer of dsts that hasn't failed yet,
+// only failing once all of them have.
+type fanOutWriter struct {
+	dsts   []io.Writer
+	errs   []error
+	failed int
+}
+
+func (w *fanOutWriter) Write(p []byte) (int, error) {
+	for i, dst := range w.dsts {
+		if w.errs[i] != nil {
+			continue
+		}
+		_, err := dst.Write(p)
+		if err != nil {
+			w.errs[i] = fmt.Errorf("destination %d: %w", i, err)
+			w.failed++
+		}
+	}
+
+	if w.failed == len(w.dsts) {
+		return 0, errors.Join(w.errs...)
+	}
+	return len(p), nil
+}
+
 // Decrypt decrypts src into dst using a 256-bit key and the params.
-func Decrypt(key []byte, src io.Reader, dst io.Writer, params *Params) error {
+// Of opts, only WithAAD and WithPolicy have an effect.
+func Decrypt(key []byte, src io.Reader, dst io.Writer, params *Params, opts ...ReaderOption) error {
 	if params == nil {
 		return ErrNilParams
 	}
@@ -51,26 +102,129 @@ func Decrypt(key []byte, src io.Reader, dst io.Writer, params *Params) error {
 	if err != nil {
 		return err
 	}
+	err = params.checkStreamOnly()
+	if err != nil {
+		return err
+	}
 
-	aead, err := chacha20poly1305.New(key)
+	config := newReaderConfig(opts)
+	err = config.checkPolicy(params)
 	if err != nil {
 		return err
 	}
-	nonce := make([]byte, chacha20poly1305.NonceSize)
+	cipher, err := newChunkCipher(key, params, config.aad)
+	if err != nil {
+		return err
+	}
+	out, checkDigest := digestWriter(dst, params)
 	err = process(
 		src,
-		int(params.ChunkSize)+aead.Overhead(),
-		dst,
-		int(params.ChunkSize),
-		func(input []byte, output []byte) ([]byte, error) {
-			plaintext, err := aead.Open(output[:0], nonce, input, nil)
-			if err != nil {
-				return nil, err
-			}
-			err = incNonce(nonce)
-			return plaintext, err
+		cipher.sealedSize,
+		out,
+		cipher.chunkSize,
+		func(input []byte, output []byte, last bool) ([]byte, error) {
+			return cipher.open(output[:0], input, last)
 		},
 	)
+	if err == nil {
+		err = checkDigest()
+	}
+	if err != nil {
+		return fmt.Errorf("decryption: %w", err)
+	}
+
+	return nil
+}
+
+// DecryptAt decrypts src into dst using a 256-bit key and the params,
+// where src holds srcSize bytes of ciphertext, without the header.
+//
+// As every chunk is at a known offset of src and is encrypted with a nonce
+// derived from its index, the chunks are read and decrypted concurrently,
+// being written to dst in order.
+//
+// Of opts, only WithAAD and WithPolicy have an effect.
+func DecryptAt(key []byte, src io.ReaderAt, srcSize int64, dst io.Writer, params *Params, opts ...ReaderOption) error {
+	if params == nil {
+		return ErrNilParams
+	}
+	err := params.checkFormatted()
+	if err != nil {
+		return err
+	}
+	err = params.checkStreamOnly()
+	if err != nil {
+		return err
+	}
+
+	config := newReaderConfig(opts)
+	err = config.checkPolicy(params)
+	if err != nil {
+		return err
+	}
+	cipher, err := newChunkCipher(key, params, config.aad)
+	if err != nil {
+		return err
+	}
+	out, checkDigest := digestWriter(dst, params)
+	chunkSize := int64(cipher.sealedSize)
+	// The last chunk is always shorter than the others,
+	// so it is present even when src ends in a chunk boundary.
+	chunks := srcSize/chunkSize + 1
+
+	group, ctx := errgroup.WithContext(context.Background())
+	results := make(chan chan []byte, runtime.GOMAXPROCS(0))
+	group.Go(func() error {
+		defer close(results)
+		for i := int64(0); i < chunks; i++ {
+			result := make(chan []byte, 1)
+			select {
+			case results <- result:
+			case <-ctx.Done():
+				return nil
+			}
+
+			group.Go(func() error {
+				size := min(chunkSize, srcSize-i*chunkSize)
+				buff := make([]byte, size)
+				n, err := src.ReadAt(buff, i*chunkSize)
+				if n < len(buff) {
+					// A short read must come with an error, but
+					// one without it still ends the chunk early.
+					if err == nil || err == io.EOF {
+						err = io.ErrUnexpectedEOF
+					}
+					return err
+				}
+
+				plaintext, err := cipher.openAt(buff[:0], buff, uint64(i), i == chunks-1)
+				if err != nil {
+					return err
+				}
+				result <- plaintext
+				return nil
+			})
+		}
+		return nil
+	})
+	group.Go(func() error {
+		for result := range results {
+			select {
+			case plaintext := <-result:
+				_, err := out.Write(plaintext)
+				if err != nil {
+					return err
+				}
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		return nil
+	})
+	err = group.Wait()
+	if err == nil {
+		err = checkDigest()
+	}
 	if err != nil {
 		return fmt.Errorf("decryption: %w", err)
 	}
@@ -78,65 +232,92 @@ func Decrypt(key []byte, src io.Reader, dst io.Writer, params *Params) error {
 	return nil
 }
 
-func process(src io.Reader, buffInSize int, dst io.Writer, buffOutSize int, p func(input []byte, output []byte) ([]byte, error)) error {
-	buffIn := make([]byte, buffInSize)
-	buffOut := make([]byte, buffOutSize)
+// pipelineDepth is the number of chunks process holds at once, so reading,
+// processing and writing overlap on different chunks.
+const pipelineDepth = 3
+
+// processBlock holds a chunk going through process, along with the
+// buffers it is read and processed into.
+type processBlock struct {
+	buffIn  []byte
+	buffOut []byte
+	input   []byte
+	output  []byte
+}
+
+// process reads src in chunks of buffInSize bytes, passing each one to p
+// along with whether it is the last chunk, and writes the results to dst.
+//
+// Reading, processing and writing run on their own, connected by channels
+// holding up to pipelineDepth chunks, so one chunk is read while the one
+// before it is processed and the one before that is written. The chunks
+// are still passed to p one at a time and in order, as the nonces of
+// the chunks are sequential.
+func process(src io.Reader, buffInSize int, dst io.Writer, buffOutSize int, p func(input []byte, output []byte, last bool) ([]byte, error)) error {
+	free := make(chan *processBlock, pipelineDepth)
+	for range pipelineDepth {
+		free <- &processBlock{
+			buffIn:  make([]byte, buffInSize),
+			buffOut: make([]byte, buffOutSize),
+		}
+	}
 	group, ctx := errgroup.WithContext(context.Background())
-	chanIn := make(chan []byte)
-	chanOut := make(chan []byte)
-	read := make(chan struct{})
-	written := make(chan struct{})
+	chanIn := make(chan *processBlock, pipelineDepth)
+	chanOut := make(chan *processBlock, pipelineDepth)
 	group.Go(func() error {
 		defer close(chanIn)
 		for {
+			var block *processBlock
 			select {
+			case block = <-free:
 			case <-ctx.Done():
 				return nil
-			default:
 			}
-			n, err := src.Read(buffIn)
+			// Every chunk is full except the last one, which is processed
+			// even when empty, so the stream always ends with a short chunk.
+			// Following the io.Reader contract, the n bytes returned
+			// are processed before considering the error.
+			n, err := io.ReadFull(src, block.buffIn)
+			if n > 0 || errors.Is(err, io.EOF) {
+				block.input = block.buffIn[:n]
+				select {
+				case chanIn <- block:
+				case <-ctx.Done():
+					return nil
+				}
+			}
 			switch {
-			case errors.Is(err, io.EOF):
+			case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
 				return nil
 			case err != nil:
 				return err
 			}
-			chanIn <- buffIn[:n]
-			<-read
 		}
 	})
 	group.Go(func() error {
 		defer close(chanOut)
-		defer close(read)
-		for input := range chanIn {
+		for block := range chanIn {
+			output, err := p(block.input, block.buffOut, len(block.input) < buffInSize)
+			if err != nil {
+				return err
+			}
+			block.output = output
 			select {
+			case chanOut <- block:
 			case <-ctx.Done():
 				return nil
-			default:
-			}
-			output, err := p(input, buffOut)
-			if err != nil {
-				return err
 			}
-			read <- struct{}{}
-			chanOut <- output
-			<-written
 		}
 		return nil
 	})
 	group.Go(func() error {
-		defer close(written)
-		for output := range chanOut {
-			select {
-			case <-ctx.Done():
-				return nil
-			default:
-			}
-			_, err := dst.Write(output)
+		for block := range chanOut {
+			_, err := dst.Write(block.output)
 			if err != nil {
 				return err
 			}
-			written <- struct{}{}
+			// free holds every block, so this never blocks.
+			free <- block
 		}
 		return nil
 	})
@@ -147,3 +328,16 @@ func process(src io.Reader, buffInSize int, dst io.Writer, buffOutSize int, p fu
 
 	return nil
 }
+
+// Verify decrypts src with key and params, discarding the plaintext, to
+// check that the whole stream is intact and key is right. Any digest or
+// trailer in params is checked as well.
+func Verify(key []byte, src io.Reader, params *Params, opts ...ReaderOption) error {
+	r, err := NewReader(key, src, params, opts...)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
@@ -0,0 +1,240 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// errorReader returns data along with err, the way some readers end.
+type errorReader struct {
+	data []byte
+	err  error
+}
+
+func (r *errorReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	if len(r.data) > 0 {
+		return n, nil
+	}
+	return n, r.err
+}
+
+func TestProcessReadError(t *testing.T) {
+	errBoom := errors.New("boom")
+	for _, readErr := range []error{io.EOF, errBoom} {
+		// The bytes returned with the error fill a single chunk, which
+		// is processed even though the pipeline then stops.
+		src := &errorReader{data: bytes.Repeat([]byte{'x'}, 10), err: readErr}
+		var processed []byte
+		err := process(src, 16, io.Discard, 16, func(input []byte, output []byte, last bool) ([]byte, error) {
+			processed = append(processed, input...)
+			return append(output[:0], input...), nil
+		})
+		if readErr == io.EOF && err != nil || readErr != io.EOF && !errors.Is(err, errBoom) {
+			t.Fatalf("read error %v: got error %v", readErr, err)
+		}
+		if len(processed) != 10 {
+			t.Fatalf("read error %v: processed %d bytes, want 10", readErr, len(processed))
+		}
+	}
+}
+
+// shortReaderAt reads at most max bytes per call, without an error, which
+// breaks the io.ReaderAt contract.
+type shortReaderAt struct {
+	src *bytes.Reader
+	max int
+}
+
+func (r *shortReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.src.ReadAt(p[:min(len(p), r.max)], off)
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func TestDecryptAt(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	err := params.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := bytes.Repeat([]byte{'x'}, 1000)
+	var out bytes.Buffer
+	w, err := NewWriter(key, &out, params, WithAAD([]byte("context")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plaintext)
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := out.Bytes()
+	size := int64(len(ciphertext))
+
+	var got bytes.Buffer
+	err = DecryptAt(key, bytes.NewReader(ciphertext), size, &got, params, WithAAD([]byte("context")))
+	if err != nil || !bytes.Equal(got.Bytes(), plaintext) {
+		t.Fatalf("DecryptAt returned %d bytes, %v", got.Len(), err)
+	}
+	err = DecryptAt(key, bytes.NewReader(ciphertext), size, io.Discard, params)
+	if err == nil {
+		t.Fatal("DecryptAt succeeded without the AAD")
+	}
+	err = DecryptAt(key, bytes.NewReader(ciphertext), size, io.Discard, params,
+		WithAAD([]byte("context")), WithPolicy(&Policy{Ciphers: []string{AES256GCM}}))
+	if !errors.Is(err, ErrPolicyViolation) {
+		t.Fatalf("got error %v, want ErrPolicyViolation", err)
+	}
+
+	// Short reads end in an error instead of leaving the chunk out.
+	short := &shortReaderAt{src: bytes.NewReader(ciphertext), max: 10}
+	err = DecryptAt(key, short, size, io.Discard, params, WithAAD([]byte("context")))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("short reads: got error %v, want io.ErrUnexpectedEOF", err)
+	}
+	err = DecryptAt(key, bytes.NewReader(ciphertext), size+100, io.Discard, params, WithAAD([]byte("context")))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("size past the end: got error %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestEmptyInput(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	err := params.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parallel, stream bytes.Buffer
+	err = Encrypt(key, bytes.NewReader(nil), &parallel, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWriter(key, &stream, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both write a single empty chunk, the last one.
+	if parallel.Len() != 16 || !bytes.Equal(parallel.Bytes(), stream.Bytes()) {
+		t.Fatalf("Encrypt wrote %x, Writer wrote %x", parallel.Bytes(), stream.Bytes())
+	}
+
+	var out bytes.Buffer
+	err = Decrypt(key, bytes.NewReader(parallel.Bytes()), &out, params)
+	if err != nil || out.Len() != 0 {
+		t.Fatalf("Decrypt returned %d bytes, %v", out.Len(), err)
+	}
+	err = DecryptAt(key, bytes.NewReader(parallel.Bytes()), int64(parallel.Len()), &out, params)
+	if err != nil || out.Len() != 0 {
+		t.Fatalf("DecryptAt returned %d bytes, %v", out.Len(), err)
+	}
+	r, err := NewReader(key, bytes.NewReader(parallel.Bytes()), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := r.Read(make([]byte, 1))
+	if n != 0 || err != io.EOF {
+		t.Fatalf("Read returned %d, %v, want 0, io.EOF", n, err)
+	}
+
+	// Without its empty last chunk, the stream is truncated.
+	err = Decrypt(key, bytes.NewReader(nil), io.Discard, params)
+	if err == nil {
+		t.Fatal("Decrypt accepted a stream without chunks")
+	}
+}
+
+// stallingReader and stallingWriter stall on every 4th call, out of phase
+// with each other, as devices flushing their caches do.
+type stallingReader struct {
+	r     io.Reader
+	calls int
+}
+
+func (r *stallingReader) Read(p []byte) (int, error) {
+	r.calls++
+	if r.calls%4 == 0 {
+		time.Sleep(2 * time.Millisecond)
+	}
+	return r.r.Read(p)
+}
+
+type stallingWriter struct {
+	calls int
+}
+
+func (w *stallingWriter) Write(p []byte) (int, error) {
+	w.calls++
+	if w.calls%4 == 2 {
+		time.Sleep(2 * time.Millisecond)
+	}
+	return len(p), nil
+}
+
+// BenchmarkEncrypt compares Encrypt, whose pipeline overlaps reading,
+// encrypting and writing, against Writer, which does them in turn, over
+// memory and over a source and a destination that stall now and then.
+func BenchmarkEncrypt(b *testing.B) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := &Params{Salt: bytes.Repeat([]byte{1}, SaltSize)}
+	err := params.Check()
+	if err != nil {
+		b.Fatal(err)
+	}
+	plaintext := make([]byte, 4<<20)
+
+	writer := func(key []byte, src io.Reader, dst io.Writer, params *Params) error {
+		w, err := NewWriter(key, dst, params)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, src)
+		if err != nil {
+			return err
+		}
+		return w.Close()
+	}
+
+	for _, bench := range []struct {
+		name    string
+		encrypt func(key []byte, src io.Reader, dst io.Writer, params *Params) error
+	}{
+		{"pipeline", Encrypt},
+		{"writer", writer},
+	} {
+		b.Run(bench.name+"/memory", func(b *testing.B) {
+			b.SetBytes(int64(len(plaintext)))
+			for range b.N {
+				err := bench.encrypt(key, bytes.NewReader(plaintext), io.Discard, params)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(bench.name+"/stalling", func(b *testing.B) {
+			b.SetBytes(int64(len(plaintext)))
+			for range b.N {
+				src := &stallingReader{r: bytes.NewReader(plaintext)}
+				err := bench.encrypt(key, src, &stallingWriter{}, params)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
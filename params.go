@@ -1,11 +1,17 @@
 package encdec
 
 import (
-	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"math"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -19,12 +25,67 @@ const (
 	ArgonMemory  = 1 << 21 // 2 MiB * KiB = 2 GiB
 	ArgonThreads = 4
 	ChunkSize    = 64 * (1 << 10) // 64 KiB
+	Cipher       = ChaCha20Poly1305
+	Format       = FormatV4
+)
+
+// Versions of the chunk framing, set in the Format field of params.
+const (
+	// FormatV1 authenticates each chunk by its nonce alone.
+	FormatV1 = 1
+
+	// FormatV2 also authenticates the index of each chunk and whether
+	// it is the last one as associated data.
+	FormatV2 = 2
+
+	// FormatV3 also authenticates the header, so none of its fields,
+	// such as the label, can be changed without failing authentication.
+	FormatV3 = 3
+
+	// FormatV4 also encrypts the chunks with a subkey derived from the
+	// key by the key schedule, rather than with the key itself.
+	FormatV4 = 4
+)
+
+// Supported values of the Cipher field of params.
+const (
+	ChaCha20Poly1305 = "chacha20poly1305"
+	AES256GCM        = "aes256gcm"
+)
+
+// Supported values of the Normalization field of params, besides the
+// empty string, which leaves the password as it is.
+const (
+	// NormalizationNFC normalizes the password to the Unicode
+	// Normalization Form C, which most keyboards and systems produce.
+	NormalizationNFC = "nfc"
 )
 
 var (
-	ErrNilParams = errors.New("params is nil")
+	ErrNilParams         = errors.New("params is nil")
+	ErrCipherMismatch    = errors.New("cipher mismatch")
+	ErrArgonType         = errors.New("invalid argon2 type")
+	ErrArgonVersion      = errors.New("invalid argon2 version")
+	ErrSaltSize          = errors.New("salt is not the same size as salt size")
+	ErrChunkSize         = errors.New("chunk size too small")
+	ErrChunkSizeTooLarge = errors.New("chunk size too large")
+	ErrFormat            = errors.New("unsupported format")
+	ErrContext           = errors.New("invalid context")
+	ErrSalt              = errors.New("invalid salt")
+	ErrMagicNotFound     = errors.New("header magic not found")
+	ErrLabel             = errors.New("invalid label")
+	ErrIncompleteHeader  = errors.New("incomplete header")
+	ErrExtension         = errors.New("invalid extension")
+	ErrNormalization     = errors.New("unsupported password normalization")
+	ErrChunkSizeUnset    = errors.New("chunk size not set")
+	ErrKeySize           = errors.New("invalid key size")
+	ErrNoSalt            = errors.New("salt not generated")
 )
 
+// ErrSaltTooSmall is returned for salts shorter than the minimum of
+// 8 bytes, whose keys are weak. It wraps ErrSalt.
+var ErrSaltTooSmall = fmt.Errorf("%w: too small", ErrSalt)
+
 // Params represents the parameters used to generate a symmetric key using
 // Argon2 and the chunk size in bytes for splitting the payload before
 // encrypting they with unique nonces.
@@ -58,6 +119,94 @@ type Params struct {
 	// ChunkSize is the length, in bytes, that the plaintext
 	// will be splitted and encrypted with different nonces.
 	ChunkSize int64
+
+	// Cipher is the AEAD used to encrypt the chunks.
+	Cipher string
+
+	// KeySize is the length, in bytes, of the key derived by Argon2,
+	// which must be the key size of Cipher. Zero sets it to the key size
+	// of Cipher, 32 bytes for every cipher so far, in which case it
+	// isn't stored in the header.
+	KeySize uint32
+
+	// Format is the version of the chunk framing.
+	Format uint8
+
+	// Normalization is how the password is normalized before deriving
+	// the key, so the same password typed on systems composing accented
+	// characters differently, such as macOS and Linux, derives the same
+	// key. The empty string, the default for compatibility with older
+	// files, leaves it as it is, while NormalizationNFC is recommended
+	// for new ones. It is stored in the header, so the password is
+	// normalized the same way for decryption.
+	Normalization string
+
+	// Context is an optional label mixed into the key, so the same
+	// password derives unrelated keys for different contexts.
+	// It must be printable ASCII, without '$', and at most 255 bytes long.
+	Context string
+
+	// Label is an optional human-readable description of the data,
+	// such as "db-backup-2024-06". It is stored in the header without
+	// being encrypted, so it must not hold secrets. It must be printable
+	// ASCII, without '$', and at most 255 bytes long, and requires
+	// FormatV3 or later, so it is authenticated.
+	Label string
+
+	// Digest is an optional SHA-256 digest of the plaintext, as returned
+	// by Digest, which is checked after decrypting the data. It requires
+	// FormatV3 or later, so it is authenticated. Like Label, it is stored
+	// in the header without being encrypted: anyone holding the encrypted
+	// data can check a guess of the plaintext against it, so it must not
+	// be set for plaintexts that could be guessed, such as short secrets.
+	Digest []byte
+
+	// PublicKey and Signature are an optional Ed25519 signature of the
+	// Digest, set by Sign, so Reader can check who encrypted the data.
+	// Only Reader checks them, the other functions decrypting data refuse
+	// them. They require a Digest and FormatV3 or later, so they are
+	// authenticated.
+	PublicKey ed25519.PublicKey
+	Signature []byte
+
+	// Extensions are optional public fields, such as a content type or
+	// a routing tag, stored in the header without being encrypted, so
+	// they can be read without the key. There can be up to 8, with names
+	// of up to 32 lowercase letters, digits and '-', and values following
+	// the rules of Label. They require FormatV3 or later, so they are
+	// authenticated.
+	Extensions map[string]string
+
+	// Trailer makes Writer store a Trailer, with the length and digest of
+	// the plaintext, at the end of the encrypted data, which Reader checks
+	// and returns from Reader.Trailer. Unlike Digest, it doesn't need the
+	// plaintext to be known in advance. It is only supported by Writer and
+	// Reader, and requires FormatV3 or later.
+	Trailer bool
+
+	// Compress makes Writer compress every chunk on its own before
+	// encrypting it, keeping it as it is if that doesn't make it shorter,
+	// so incompressible data is never expanded. The chunks are then stored
+	// as frames of their own length, each one marked as compressed or not.
+	// It is only supported by Writer and Reader, and requires FormatV3 or
+	// later and a chunk size under 1 GiB.
+	Compress bool
+
+	// Frames makes Writer store every chunk as a frame of its own length,
+	// as Compress does without compressing them, so Writer.FlushChunk can
+	// write a chunk before it is full. It is only supported by Writer and
+	// Reader, and requires FormatV3 or later and a chunk size under 1 GiB.
+	Frames bool
+
+	// Erasure makes Writer add parity chunks to the encrypted data, from
+	// which Reader recovers chunks failing authentication. It is only
+	// supported by Writer and Reader, and requires FormatV3 or later.
+	Erasure Erasure
+
+	// Profile is the name of the params registered with RegisterProfile.
+	// When set, the header only holds the profile name and the salt,
+	// so the other fields must match the registered params.
+	Profile string
 }
 
 // NewParams creates an instance of Params struct with default configuration
@@ -71,25 +220,87 @@ func NewParams() *Params {
 // when a field with the zero value is detected or returning an error
 // if a field has an invalid value.
 func (p *Params) Check() error {
+	for _, check := range paramsChecks {
+		err := check(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckStrict works like Check, except that a zero ChunkSize returns
+// ErrChunkSizeUnset instead of being set to the default, so callers
+// building params in code catch a forgotten chunk size, which changes
+// the layout of the encrypted data. The other fields still get their
+// defaults.
+func (p *Params) CheckStrict() error {
+	if p.ChunkSize == 0 {
+		return ErrChunkSizeUnset
+	}
+
+	return p.Check()
+}
+
+// paramsChecks are the checks run by Check, in order, each one filling in
+// the defaults of the fields it checks, which the later ones may rely on.
+// They are kept apart so ValidateHeader can run all of them.
+var paramsChecks = []func(*Params) error{
+	(*Params).checkArgonType,
+	(*Params).checkArgonVersion,
+	(*Params).checkSaltSize,
+	(*Params).checkArgonCost,
+	(*Params).checkChunkSize,
+	(*Params).checkCipherField,
+	(*Params).checkKeySize,
+	(*Params).checkFormat,
+	(*Params).checkNormalization,
+	(*Params).checkContext,
+	(*Params).checkLabel,
+	(*Params).checkExtensions,
+	(*Params).checkDigest,
+	(*Params).checkSignature,
+	(*Params).checkTrailer,
+	(*Params).checkCompress,
+	(*Params).checkFrames,
+	(*Params).checkErasure,
+}
+
+func (p *Params) checkArgonType() error {
 	if p.ArgonType == "" {
 		p.ArgonType = ArgonType
 	} else if p.ArgonType != ArgonType {
-		return errors.New("invalid argon2 type")
+		return fmt.Errorf("%w: %q", ErrArgonType, p.ArgonType)
 	}
 
+	return nil
+}
+
+func (p *Params) checkArgonVersion() error {
 	if p.ArgonVersion == 0 {
 		p.ArgonVersion = ArgonVersion
 	} else if p.ArgonVersion != ArgonVersion {
-		return errors.New("invalid argon2 version")
+		return fmt.Errorf("%w: %d", ErrArgonVersion, p.ArgonVersion)
 	}
 
+	return nil
+}
+
+func (p *Params) checkSaltSize() error {
 	if p.SaltSize == 0 {
 		p.SaltSize = SaltSize
+	} else if p.SaltSize < minSaltSize {
+		return fmt.Errorf("%w: SaltSize=%d is less than %d", ErrSaltTooSmall, p.SaltSize, minSaltSize)
 	}
 	if p.Salt != nil && len(p.Salt) != int(p.SaltSize) {
-		return errors.New("salt is not the same size as salt size")
+		return fmt.Errorf("%w: SaltSize=%d but len(Salt)=%d", ErrSaltSize, p.SaltSize, len(p.Salt))
 	}
 
+	return nil
+}
+
+func (p *Params) checkArgonCost() error {
 	if p.ArgonTime == 0 {
 		p.ArgonTime = ArgonTime
 	}
@@ -102,10 +313,129 @@ func (p *Params) Check() error {
 		p.ArgonThreads = ArgonThreads
 	}
 
+	return nil
+}
+
+func (p *Params) checkChunkSize() error {
 	if p.ChunkSize == 0 {
 		p.ChunkSize = ChunkSize
 	} else if p.ChunkSize < 0 {
-		return errors.New("chunk size too small")
+		return fmt.Errorf("%w: %d", ErrChunkSize, p.ChunkSize)
+	}
+
+	return nil
+}
+
+func (p *Params) checkCipherField() error {
+	if p.Cipher == "" {
+		p.Cipher = Cipher
+	}
+	return checkCipher(p.Cipher)
+}
+
+func (p *Params) checkKeySize() error {
+	size, err := cipherKeySize(p.Cipher)
+	if err != nil {
+		// An unsupported cipher was already reported by checkCipherField,
+		// which runs first, so ValidateHeader doesn't report it twice.
+		return nil
+	}
+	if p.KeySize == 0 {
+		p.KeySize = size
+	} else if p.KeySize != size {
+		return fmt.Errorf("%w: %d bytes, %s needs %d", ErrKeySize, p.KeySize, p.Cipher, size)
+	}
+
+	return nil
+}
+
+func (p *Params) checkFormat() error {
+	if p.Format == 0 {
+		p.Format = Format
+	} else if p.Format > FormatV4 {
+		return fmt.Errorf("%w: %d", ErrFormat, p.Format)
+	}
+
+	return nil
+}
+
+func (p *Params) checkNormalization() error {
+	if p.Normalization != "" && p.Normalization != NormalizationNFC {
+		return fmt.Errorf("%w: %q", ErrNormalization, p.Normalization)
+	}
+
+	return nil
+}
+
+func (p *Params) checkContext() error {
+	if len(p.Context) > maxContextSize {
+		return fmt.Errorf("%w: length %d exceeds %d", ErrContext, len(p.Context), maxContextSize)
+	}
+	if !isHeaderText(p.Context) {
+		return fmt.Errorf("%w: %q is not printable ASCII without '$'", ErrContext, p.Context)
+	}
+
+	return nil
+}
+
+func (p *Params) checkLabel() error {
+	if len(p.Label) > maxLabelSize {
+		return fmt.Errorf("%w: length %d exceeds %d", ErrLabel, len(p.Label), maxLabelSize)
+	}
+	if !isHeaderText(p.Label) {
+		return fmt.Errorf("%w: %q is not printable ASCII without '$'", ErrLabel, p.Label)
+	}
+	if p.Label != "" && p.Format < FormatV3 {
+		return fmt.Errorf("%w: requires format %d, not %d", ErrLabel, FormatV3, p.Format)
+	}
+
+	return nil
+}
+
+func (p *Params) checkDigest() error {
+	if len(p.Digest) != 0 && len(p.Digest) != DigestSize {
+		return fmt.Errorf("%w: length %d is not %d", ErrDigest, len(p.Digest), DigestSize)
+	}
+	if len(p.Digest) != 0 && p.Format < FormatV3 {
+		return fmt.Errorf("%w: requires format %d, not %d", ErrDigest, FormatV3, p.Format)
+	}
+
+	return nil
+}
+
+func (p *Params) checkTrailer() error {
+	if p.Trailer && p.Format < FormatV3 {
+		return fmt.Errorf("%w: requires format %d, not %d", ErrTrailer, FormatV3, p.Format)
+	}
+
+	return nil
+}
+
+func (p *Params) checkCompress() error {
+	if p.Compress && p.Format < FormatV3 {
+		return fmt.Errorf("%w: requires format %d, not %d", ErrCompress, FormatV3, p.Format)
+	}
+	if p.Compress && p.ChunkSize+tagSize > frameSizeMask {
+		return fmt.Errorf("%w: %d with compression", ErrChunkSizeTooLarge, p.ChunkSize)
+	}
+
+	return nil
+}
+
+func (p *Params) checkExtensions() error {
+	if len(p.Extensions) > maxExtensions {
+		return fmt.Errorf("%w: %d extensions exceed %d", ErrExtension, len(p.Extensions), maxExtensions)
+	}
+	for name, value := range p.Extensions {
+		if !isExtensionName(name) {
+			return fmt.Errorf("%w: invalid name %q", ErrExtension, name)
+		}
+		if len(value) > maxLabelSize || !isHeaderText(value) {
+			return fmt.Errorf("%w: invalid value %q of %q", ErrExtension, value, name)
+		}
+	}
+	if len(p.Extensions) != 0 && p.Format < FormatV3 {
+		return fmt.Errorf("%w: requires format %d, not %d", ErrExtension, FormatV3, p.Format)
 	}
 
 	return nil
@@ -120,17 +450,282 @@ func (p *Params) checkFormatted() error {
 	return nil
 }
 
+// Equal reports whether p and other hold the same values, including the
+// salt bytes. A nil salt is equal to an empty one, and two nil Params are
+// equal to each other.
+func (p *Params) Equal(other *Params) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+
+	return p.ArgonVersion == other.ArgonVersion &&
+		p.ArgonType == other.ArgonType &&
+		p.SaltSize == other.SaltSize &&
+		bytes.Equal(p.Salt, other.Salt) &&
+		p.ArgonTime == other.ArgonTime &&
+		p.ArgonMemory == other.ArgonMemory &&
+		p.ArgonThreads == other.ArgonThreads &&
+		p.ChunkSize == other.ChunkSize &&
+		p.Cipher == other.Cipher &&
+		p.KeySize == other.KeySize &&
+		p.Format == other.Format &&
+		p.Normalization == other.Normalization &&
+		p.Context == other.Context &&
+		p.Label == other.Label &&
+		bytes.Equal(p.Digest, other.Digest) &&
+		bytes.Equal(p.PublicKey, other.PublicKey) &&
+		bytes.Equal(p.Signature, other.Signature) &&
+		maps.Equal(p.Extensions, other.Extensions) &&
+		p.Trailer == other.Trailer &&
+		p.Compress == other.Compress &&
+		p.Frames == other.Frames &&
+		p.Erasure == other.Erasure &&
+		p.Profile == other.Profile
+}
+
+// Fingerprint returns the SHA-256 of the header of p in hex, which
+// identifies the params, salt included, such as for caching their key.
+// It holds nothing secret, as the header is stored in the clear. Invalid
+// params, or params without a salt, have no header, and so return the
+// error of MarshalHeader instead. p isn't modified.
+func (p *Params) Fingerprint() (string, error) {
+	if p == nil {
+		return "", ErrNilParams
+	}
+	params := *p
+	header, err := params.MarshalHeader()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(header)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// minSaltSize is the minimum length of a salt accepted from a header,
+// as required by the Argon2 specification.
+const minSaltSize = 8
+
+// checkSaltLength returns an error if n isn't a valid salt length.
+func checkSaltLength(n int) error {
+	if n < minSaltSize {
+		return fmt.Errorf("%w: length %d is less than %d", ErrSaltTooSmall, n, minSaltSize)
+	}
+	if n > math.MaxUint8 {
+		return fmt.Errorf("%w: length %d exceeds %d", ErrSalt, n, math.MaxUint8)
+	}
+	return nil
+}
+
+// maxContextSize is the maximum length of Params.Context.
+const maxContextSize = 255
+
+// maxLabelSize is the maximum length of Params.Label.
+const maxLabelSize = 255
+
+const (
+	// maxExtensions is the maximum number of Params.Extensions.
+	maxExtensions = 8
+
+	// maxExtensionName is the maximum length of the name of an extension.
+	maxExtensionName = 32
+)
+
+// isExtensionName reports whether s is a valid name of an extension.
+func isExtensionName(s string) bool {
+	if s == "" || len(s) > maxExtensionName {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if (s[i] < 'a' || s[i] > 'z') && (s[i] < '0' || s[i] > '9') && s[i] != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// isHeaderText reports whether s only has printable ASCII characters
+// other than '$', so it can be stored as is in a header field.
+func isHeaderText(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] > '~' || s[i] == '$' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSalt parses the salt field of a header into p.
+func (p *Params) parseSalt(arg string) error {
+	errInfoLevelString := "parsing header: "
+
+	// The salt is cut at the first '=' only, as padded base64 ends in '='.
+	key, value, ok := strings.Cut(arg, "=")
+	if !ok || key != "s" {
+		return errors.New(errInfoLevelString + "corrupted header")
+	}
+	salt, err := decodeSalt(value)
+	if err != nil {
+		return fmt.Errorf(errInfoLevelString+"parsing salt: %w", err)
+	}
+	err = checkSaltLength(len(salt))
+	if err != nil {
+		return fmt.Errorf(errInfoLevelString+"parsing salt: %w", err)
+	}
+	p.Salt = salt
+	p.SaltSize = uint8(len(salt))
+
+	return nil
+}
+
+// parseProfileHeader parses the split fields of a header written for
+// params with a Profile, taking the fields other than the salt from the
+// registered profile.
+func parseProfileHeader(args []string) (*Params, error) {
+	name := strings.TrimPrefix(args[2], "profile=")
+	params, err := ProfileParams(name)
+	if err != nil {
+		return nil, fmt.Errorf("parsing header: %w", err)
+	}
+	if args[1] != params.ArgonType {
+		return nil, errors.New("parsing header: corrupted header")
+	}
+
+	err = params.parseSalt(args[3])
+	if err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// saltEncodings are the base64 variants accepted for the salt of a header,
+// in the order they are tried. Only the first is used to write headers,
+// the others are found in PHC strings written by other tools.
+var saltEncodings = []*base64.Encoding{
+	base64.RawStdEncoding,
+	base64.StdEncoding,
+	base64.RawURLEncoding,
+}
+
+func decodeSalt(s string) ([]byte, error) {
+	var err error
+	for _, encoding := range saltEncodings {
+		var salt []byte
+		salt, err = encoding.DecodeString(s)
+		if err == nil {
+			return salt, nil
+		}
+	}
+	return nil, err
+}
+
+// String returns a compact, human-readable description of the params,
+// suitable for logging. The salt bytes are deliberately left out,
+// only its length is shown. A nil p is shown as "<nil>".
+func (p *Params) String() string {
+	if p == nil {
+		return "<nil>"
+	}
+	salt := "none"
+	if p.Salt != nil {
+		salt = fmt.Sprintf("%d bytes", len(p.Salt))
+	}
+
+	s := fmt.Sprintf(
+		"%s v=%d t=%d m=%s p=%d salt=%s chunk=%s cipher=%s format=%d",
+		p.ArgonType,
+		p.ArgonVersion,
+		p.ArgonTime,
+		formatSize(int64(p.ArgonMemory)*(1<<10)),
+		p.ArgonThreads,
+		salt,
+		formatSize(p.ChunkSize),
+		p.Cipher,
+		p.Format,
+	)
+	if p.KeySize != keySize {
+		s += fmt.Sprintf(" key=%d", p.KeySize)
+	}
+	if p.Normalization != "" {
+		s += fmt.Sprintf(" normalization=%s", p.Normalization)
+	}
+	if p.Context != "" {
+		s += fmt.Sprintf(" context=%q", p.Context)
+	}
+	if p.Label != "" {
+		s += fmt.Sprintf(" label=%q", p.Label)
+	}
+	if len(p.Digest) != 0 {
+		s += fmt.Sprintf(" digest=%x", p.Digest)
+	}
+	if len(p.PublicKey) != 0 {
+		s += fmt.Sprintf(" signed-by=%x", []byte(p.PublicKey))
+	}
+	for _, name := range slices.Sorted(maps.Keys(p.Extensions)) {
+		s += fmt.Sprintf(" e.%s=%q", name, p.Extensions[name])
+	}
+	if p.Trailer {
+		s += " trailer"
+	}
+	if p.Compress {
+		s += " compress"
+	}
+	if p.Frames {
+		s += " frames"
+	}
+	if p.Erasure != (Erasure{}) {
+		s += fmt.Sprintf(" erasure=%d+%d", p.Erasure.Data, p.Erasure.Parity)
+	}
+	if p.Profile != "" {
+		s += fmt.Sprintf(" profile=%q", p.Profile)
+	}
+
+	return s
+}
+
+// formatSize formats n bytes using the largest binary unit
+// that represents it exactly.
+func formatSize(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for i < len(units)-1 && n != 0 && n%(1<<10) == 0 {
+		n /= 1 << 10
+		i++
+	}
+
+	return fmt.Sprintf("%d %s", n, units[i])
+}
+
 // MarshalHeader returns a string header as a byte slice made from
-// the Params fields. Returns an error if the Params used are not valid.
+// the Params fields. Returns an error if the Params used are not valid,
+// wrapping ErrNoSalt if there is no salt yet, as the header couldn't be
+// parsed back. The salt is generated by Key when it derives the key, so
+// the header is marshaled after it.
 func (p *Params) MarshalHeader() ([]byte, error) {
 	err := p.checkFormatted()
 	if err != nil {
 		return nil, err
 	}
+	if len(p.Salt) == 0 {
+		return nil, fmt.Errorf("params: %w", ErrNoSalt)
+	}
 
+	return p.marshalHeader()
+}
+
+// marshalHeader works like MarshalHeader for checked params, which may
+// lack a salt, such as to hash the header of params given along with a
+// key rather than derived from a password.
+func (p *Params) marshalHeader() ([]byte, error) {
 	salt := base64.RawStdEncoding.EncodeToString(p.Salt)
-	s := fmt.Sprintf(
-		"$%s$v=%d$t=%d,m=%d,p=%d$s=%s$b=%d\n",
+	if p.Profile != "" {
+		return p.marshalProfileHeader(salt)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(
+		&b,
+		"$%s$v=%d$t=%d,m=%d,p=%d$s=%s$b=%d",
 		p.ArgonType,
 		p.ArgonVersion,
 		p.ArgonTime,
@@ -139,116 +734,440 @@ func (p *Params) MarshalHeader() ([]byte, error) {
 		salt,
 		p.ChunkSize,
 	)
+	// Optional fields are only written when they differ from the
+	// values implied by their absence, so headers written before
+	// they existed keep their meaning.
+	if p.Cipher != ChaCha20Poly1305 {
+		fmt.Fprintf(&b, "$c=%s", p.Cipher)
+	}
+	if p.KeySize != keySize {
+		fmt.Fprintf(&b, "$k=%d", p.KeySize)
+	}
+	if p.Format != FormatV1 {
+		fmt.Fprintf(&b, "$f=%d", p.Format)
+	}
+	if p.Normalization != "" {
+		fmt.Fprintf(&b, "$n=%s", p.Normalization)
+	}
+	if p.Context != "" {
+		fmt.Fprintf(&b, "$x=%s", p.Context)
+	}
+	if p.Label != "" {
+		fmt.Fprintf(&b, "$l=%s", p.Label)
+	}
+	if len(p.Digest) != 0 {
+		fmt.Fprintf(&b, "$d=%s", base64.RawStdEncoding.EncodeToString(p.Digest))
+	}
+	if len(p.Signature) != 0 {
+		fmt.Fprintf(&b, "$pk=%s", base64.RawStdEncoding.EncodeToString(p.PublicKey))
+		fmt.Fprintf(&b, "$sig=%s", base64.RawStdEncoding.EncodeToString(p.Signature))
+	}
+	if p.Trailer {
+		b.WriteString("$tr=1")
+	}
+	if p.Compress {
+		b.WriteString("$z=1")
+	}
+	if p.Frames {
+		b.WriteString("$fr=1")
+	}
+	if p.Erasure != (Erasure{}) {
+		fmt.Fprintf(&b, "$ec=%s", p.Erasure.marshal())
+	}
+	// Extensions are sorted, so the header of the same params is always
+	// the same, as its hash is authenticated.
+	for _, name := range slices.Sorted(maps.Keys(p.Extensions)) {
+		fmt.Fprintf(&b, "$e.%s=%s", name, p.Extensions[name])
+	}
+	b.WriteByte('\n')
+
+	return []byte(b.String()), nil
+}
+
+// marshalProfileHeader returns the header of params with a Profile,
+// which only holds the profile name and the salt.
+func (p *Params) marshalProfileHeader(salt string) ([]byte, error) {
+	profile, err := ProfileParams(p.Profile)
+	if err != nil {
+		return nil, fmt.Errorf("params: %w", err)
+	}
+	profile.Salt = p.Salt
+	profile.SaltSize = p.SaltSize
+	if !profile.Equal(p) {
+		return nil, fmt.Errorf("params: params differ from profile %q", p.Profile)
+	}
 
-	return []byte(s), nil
+	header := fmt.Sprintf("$%s$profile=%s$s=%s\n", p.ArgonType, p.Profile, salt)
+	return []byte(header), nil
+}
+
+// maxHeaderSize is the maximum length of a header, so a stream
+// without a newline isn't read indefinitely.
+const maxHeaderSize = 1 << 12
+
+// readHeader reads the header line from src, without the trailing newline.
+// It reads one byte at a time so nothing past the header is consumed,
+// through ReadByte if src implements io.ByteReader.
+func readHeader(src io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	readByte := func() (byte, error) {
+		_, err := io.ReadFull(src, b)
+		return b[0], err
+	}
+	if br, ok := src.(io.ByteReader); ok {
+		readByte = br.ReadByte
+	}
+	for len(line) < maxHeaderSize {
+		c, err := readByte()
+		if err != nil {
+			if len(line) > 0 && errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		if c == '\n' {
+			return string(line), nil
+		}
+		line = append(line, c)
+	}
+
+	return "", errors.New("header too long")
 }
 
 // ParseHeader parses the header of the given src stream.
 // It create a new Params object and load its fields from the provided header.
-func ParseHeader(src io.ReadSeeker) (*Params, error) {
-	errInfoLevelString := "parsing header: "
-	errParsing := errors.New(errInfoLevelString + "corrupted header")
+// No data past the header is consumed from src, so the payload can be read
+// from src afterwards. Since src is read one byte at a time, unbuffered
+// sources such as files should be wrapped in a bufio.Reader, which is then
+// used to read the payload.
+func ParseHeader(src io.Reader) (*Params, error) {
+	params, _, err := ParseHeaderRaw(src)
+	return params, err
+}
 
-	buff := bufio.NewReader(src)
-	line, err := buff.ReadString('\n')
+// ParseHeaderRaw works like ParseHeader, also returning the header exactly
+// as read from src, including the trailing newline. Marshaling the params
+// again may not give the same bytes, as some fields have more than one
+// accepted representation.
+func ParseHeaderRaw(src io.Reader) (*Params, []byte, error) {
+	line, err := readHeader(src)
 	if err != nil {
-		return nil, fmt.Errorf(errInfoLevelString+"%w", err)
+		return nil, nil, fmt.Errorf("parsing header: %w", err)
 	}
-	line = line[:len(line)-1]
 
-	_, err = src.Seek(int64(len(line)+1), io.SeekStart)
+	params, err := parseHeader(line)
 	if err != nil {
-		return nil, fmt.Errorf(errInfoLevelString+"%w", err)
+		return nil, nil, err
 	}
-	args := strings.Split(line, "$")
-	if len(args) != 6 || args[0] != "" {
-		fmt.Println("1")
-		return nil, errParsing
+	return params, []byte(line + "\n"), nil
+}
+
+// headerMagic is the prefix of every header.
+const headerMagic = "$" + ArgonType + "$"
+
+// ParseHeaderScan works like ParseHeader, but skips up to limit bytes of
+// src preceding the header, such as the preamble of a container holding
+// the encrypted data. The header is found by its "$argon2id$" prefix, and
+// if it doesn't start within limit bytes an error wrapping
+// ErrMagicNotFound is returned.
+//
+// The prefix is looked for along with what follows it in a header, so
+// junk ending in "$argon2id" isn't taken for the start of the header.
+func ParseHeaderScan(src io.Reader, limit int) (*Params, error) {
+	var window []byte
+	b := make([]byte, 1)
+	for read := 0; ; {
+		if read >= limit+detectSize {
+			return nil, fmt.Errorf("parsing header: %w within %d bytes", ErrMagicNotFound, limit)
+		}
+		_, err := io.ReadFull(src, b)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = ErrMagicNotFound
+			}
+			return nil, fmt.Errorf("parsing header: %w", err)
+		}
+		read++
+		window = append(window, b[0])
+		if len(window) > detectSize {
+			window = append(window[:0], window[1:]...)
+		}
+
+		for _, signature := range headerSignatures {
+			start := headerMagic + signature
+			if !bytes.HasSuffix(window, []byte(start)) || read-len(start) > limit {
+				continue
+			}
+			line, err := readHeader(src)
+			if err != nil {
+				return nil, fmt.Errorf("parsing header: %w", err)
+			}
+			return parseHeader(start + line)
+		}
 	}
+}
 
-	var params Params
-	params.ArgonType = args[1]
+// ParseHeaderString parses the header held in s, which may or may not
+// end with the newline terminating the header.
+func ParseHeaderString(s string) (*Params, error) {
+	line := strings.TrimSuffix(s, "\n")
+	if len(line) >= maxHeaderSize {
+		return nil, errors.New("parsing header: header too long")
+	}
+	if strings.Contains(line, "\n") {
+		return nil, errors.New("parsing header: corrupted header")
+	}
 
-	values := strings.Split(args[2], "=")
-	if len(values) != 2 || values[0] != "v" {
-		fmt.Println("2")
-		return nil, errParsing
+	return parseHeader(line)
+}
+
+// ParseHeaderBytes works like ParseHeaderString for a header held in b.
+func ParseHeaderBytes(b []byte) (*Params, error) {
+	return ParseHeaderString(string(b))
+}
+
+// ParseHeaderStrict works like ParseHeader, but returns an error wrapping
+// ErrIncompleteHeader if the type or the salt is empty, or any of the
+// version, time, memory, threads or chunk size fields is zero, instead of
+// letting Check replace it with its default value or accept params without
+// a salt. This is meant for headers of untrusted files, where a defaulted
+// field could hide tampering.
+func ParseHeaderStrict(src io.Reader) (*Params, error) {
+	line, err := readHeader(src)
+	if err != nil {
+		return nil, fmt.Errorf("parsing header: %w", err)
+	}
+
+	params, err := parseHeaderFields(line)
+	if err != nil {
+		return nil, err
 	}
-	u, err := strconv.ParseUint(values[1], 10, 8)
+	err = params.checkComplete()
 	if err != nil {
-		return nil, fmt.Errorf(errInfoLevelString+"parsing argon2 version %w", err)
+		return nil, fmt.Errorf("parsing header: %w", err)
+	}
+	err = params.Check()
+	if err != nil {
+		return nil, fmt.Errorf("parsing header: %w", err)
 	}
-	params.ArgonVersion = uint8(u)
 
-	values = strings.Split(args[3], ",")
-	if len(values) != 3 {
-		fmt.Println("3")
-		return nil, errParsing
+	return params, nil
+}
+
+// checkComplete returns an error wrapping ErrIncompleteHeader listing
+// the header fields of p that Check would default, or accept empty.
+func (p *Params) checkComplete() error {
+	var missing []string
+	if p.ArgonType == "" {
+		missing = append(missing, "type")
+	}
+	if p.ArgonVersion == 0 {
+		missing = append(missing, "v")
+	}
+	if p.ArgonTime == 0 {
+		missing = append(missing, "t")
+	}
+	if p.ArgonMemory == 0 {
+		missing = append(missing, "m")
+	}
+	if p.ArgonThreads == 0 {
+		missing = append(missing, "p")
+	}
+	if len(p.Salt) == 0 {
+		missing = append(missing, "s")
+	}
+	if p.ChunkSize == 0 {
+		missing = append(missing, "b")
 	}
 
-	subValues := strings.Split(values[0], "=")
-	if len(subValues) != 2 || subValues[0] != "t" {
-		fmt.Println("4")
-		return nil, errParsing
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrIncompleteHeader, strings.Join(missing, ", "))
 	}
-	u, err = strconv.ParseUint(subValues[1], 10, 32)
+	return nil
+}
+
+// parseHeader parses a header line without the trailing newline.
+func parseHeader(line string) (*Params, error) {
+	params, err := parseHeaderFields(line)
 	if err != nil {
-		return nil, fmt.Errorf(errInfoLevelString+"parsing argon2 time: %w", err)
+		return nil, err
 	}
-	params.ArgonTime = uint32(u)
 
-	subValues = strings.Split(values[1], "=")
-	if len(subValues) != 2 || subValues[0] != "m" {
-		fmt.Println("5")
-		return nil, errParsing
-	}
-	u, err = strconv.ParseUint(subValues[1], 10, 32)
+	err = params.Check()
 	if err != nil {
-		return nil, fmt.Errorf(errInfoLevelString+"parsing argon2 memory: %w", err)
+		return nil, fmt.Errorf("parsing header: %w", err)
 	}
-	params.ArgonMemory = uint32(u)
 
-	subValues = strings.Split(values[2], "=")
-	if len(subValues) != 2 || subValues[0] != "p" {
-		fmt.Println("6")
-		return nil, errParsing
+	return params, nil
+}
+
+// parseHeaderFields parses the fields of a header line, without checking
+// the resulting params.
+func parseHeaderFields(line string) (*Params, error) {
+	params, errs := parseHeaderFieldsAll(line)
+	if len(errs) != 0 {
+		return nil, errs[0]
 	}
-	u, err = strconv.ParseUint(subValues[1], 10, 8)
-	if err != nil {
-		return nil, fmt.Errorf(errInfoLevelString+"parsing argon2 threads: %w", err)
+
+	return params, nil
+}
+
+// parseHeaderFieldsAll parses the fields of line, carrying on past the
+// fields failing to parse, whose errors are returned in order. Only if the
+// fields can't be told apart are the params nil.
+func parseHeaderFieldsAll(line string) (*Params, []error) {
+	errInfoLevelString := "parsing header: "
+	errParsing := errors.New(errInfoLevelString + "corrupted header")
+
+	args := strings.Split(line, "$")
+	if len(args) == 4 && args[0] == "" && strings.HasPrefix(args[2], "profile=") {
+		params, err := parseProfileHeader(args)
+		if err != nil {
+			return nil, []error{err}
+		}
+		return params, nil
+	}
+	if len(args) < 6 || args[0] != "" {
+		return nil, []error{errParsing}
+	}
+
+	var params Params
+	var errs []error
+	params.ArgonType = args[1]
+
+	values := strings.Split(args[2], "=")
+	if len(values) != 2 || values[0] != "v" {
+		errs = append(errs, errParsing)
+	} else if u, err := strconv.ParseUint(values[1], 10, 8); err != nil {
+		errs = append(errs, fmt.Errorf(errInfoLevelString+"parsing argon2 version %w", err))
+	} else {
+		params.ArgonVersion = uint8(u)
 	}
-	params.ArgonThreads = uint8(u)
 
-	values = strings.Split(args[4], "=")
-	if len(values) != 2 || values[0] != "s" {
-		fmt.Println(values)
-		fmt.Println("7")
-		return nil, errParsing
+	values = strings.Split(args[3], ",")
+	if len(values) != 3 {
+		errs = append(errs, errParsing)
+		values = nil
 	}
-	params.Salt, err = base64.RawStdEncoding.DecodeString(values[1])
-	if err != nil {
-		return nil, fmt.Errorf(errInfoLevelString+"parsing salt: %w", err)
+	argonFields := []struct {
+		key     string
+		name    string
+		bitSize int
+		set     func(uint64)
+	}{
+		{"t", "time", 32, func(u uint64) { params.ArgonTime = uint32(u) }},
+		{"m", "memory", 32, func(u uint64) { params.ArgonMemory = uint32(u) }},
+		{"p", "threads", 8, func(u uint64) { params.ArgonThreads = uint8(u) }},
 	}
-	if len(params.Salt) > (1 << 8) {
-		return nil, errors.New(errInfoLevelString + "parsing salt: salt too long")
+	for i, value := range values {
+		field := argonFields[i]
+		subValues := strings.Split(value, "=")
+		if len(subValues) != 2 || subValues[0] != field.key {
+			errs = append(errs, errParsing)
+			continue
+		}
+		u, err := strconv.ParseUint(subValues[1], 10, field.bitSize)
+		if err != nil {
+			errs = append(errs, fmt.Errorf(errInfoLevelString+"parsing argon2 %s: %w", field.name, err))
+			continue
+		}
+		field.set(u)
+	}
+
+	err := params.parseSalt(args[4])
+	if err != nil {
+		errs = append(errs, err)
 	}
-	params.SaltSize = uint8(len(params.Salt))
 
 	values = strings.Split(args[5], "=")
 	if len(values) != 2 || values[0] != "b" {
-		fmt.Println("8")
-		return nil, errParsing
+		errs = append(errs, errParsing)
+	} else if i, err := strconv.ParseInt(values[1], 10, 64); err != nil {
+		errs = append(errs, fmt.Errorf(errInfoLevelString+"parsing chunk size: %w", err))
+	} else {
+		params.ChunkSize = i
 	}
-	i, err := strconv.ParseInt(values[1], 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf(errInfoLevelString+"parsing chunk size: %w", err)
+
+	params.Cipher = ChaCha20Poly1305
+	params.Format = FormatV1
+	seen := make(map[string]bool)
+	for _, arg := range args[6:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || seen[key] {
+			errs = append(errs, errParsing)
+			continue
+		}
+		seen[key] = true
+
+		err := params.parseOptionalField(key, value)
+		if err != nil {
+			errs = append(errs, errParsing)
+		}
 	}
 
-	params.ChunkSize = int64(i)
-	err = params.Check()
-	if err != nil {
-		return nil, fmt.Errorf(errInfoLevelString+"%w", err)
+	return &params, errs
+}
+
+// parseOptionalField parses the optional field of a header named key
+// into p.
+func (p *Params) parseOptionalField(key string, value string) error {
+	errInvalid := errors.New("invalid field")
+	var err error
+	switch key {
+	case "c":
+		p.Cipher = value
+	case "k":
+		u, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || u == 0 {
+			return errInvalid
+		}
+		p.KeySize = uint32(u)
+	case "f":
+		u, err := strconv.ParseUint(value, 10, 8)
+		if err != nil || u == 0 {
+			return errInvalid
+		}
+		p.Format = uint8(u)
+	case "n":
+		p.Normalization = value
+	case "x":
+		p.Context = value
+	case "l":
+		p.Label = value
+	case "d":
+		p.Digest, err = base64.RawStdEncoding.DecodeString(value)
+	case "pk":
+		p.PublicKey, err = base64.RawStdEncoding.DecodeString(value)
+	case "sig":
+		p.Signature, err = base64.RawStdEncoding.DecodeString(value)
+	case "tr":
+		if value != "1" {
+			return errInvalid
+		}
+		p.Trailer = true
+	case "z":
+		if value != "1" {
+			return errInvalid
+		}
+		p.Compress = true
+	case "fr":
+		if value != "1" {
+			return errInvalid
+		}
+		p.Frames = true
+	case "ec":
+		p.Erasure, err = parseErasure(value)
+	default:
+		name, ok := strings.CutPrefix(key, "e.")
+		if !ok {
+			return errInvalid
+		}
+		if p.Extensions == nil {
+			p.Extensions = make(map[string]string)
+		}
+		p.Extensions[name] = value
 	}
 
-	return &params, nil
+	return err
 }
@@ -0,0 +1,441 @@
+package encdec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestFingerprint(t *testing.T) {
+	fingerprint := func(p *Params) string {
+		t.Helper()
+		s, err := p.Fingerprint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	salt := make([]byte, SaltSize)
+	a := &Params{Salt: salt, ArgonMemory: 1024}
+	b := &Params{Salt: bytes.Clone(salt), ArgonMemory: 1024}
+	if fingerprint(a) != fingerprint(b) {
+		t.Fatal("equal params have different fingerprints")
+	}
+	if len(fingerprint(a)) != 64 {
+		t.Fatalf("fingerprint has %d characters, want 64", len(fingerprint(a)))
+	}
+	if a.ArgonType != "" || a.Cipher != "" {
+		t.Fatal("Fingerprint modified params")
+	}
+
+	c := &Params{Salt: salt, ArgonMemory: 2048}
+	d := &Params{Salt: make([]byte, SaltSize+1), SaltSize: SaltSize + 1, ArgonMemory: 1024}
+	if fingerprint(a) == fingerprint(c) || fingerprint(a) == fingerprint(d) {
+		t.Fatal("different params have the same fingerprint")
+	}
+
+	_, err := (&Params{Salt: salt, ArgonType: "x"}).Fingerprint()
+	if !errors.Is(err, ErrArgonType) {
+		t.Fatalf("invalid params: got error %v, want ErrArgonType", err)
+	}
+	_, err = NewParams().Fingerprint()
+	if !errors.Is(err, ErrNoSalt) {
+		t.Fatalf("params without a salt: got error %v, want ErrNoSalt", err)
+	}
+}
+
+func TestLabel(t *testing.T) {
+	for _, label := range []string{"", "db-backup-2024-06", strings.Repeat("a", maxLabelSize)} {
+		params := testParams()
+		params.Label = label
+		blob, err := EncryptBytes([]byte("password"), []byte("plaintext"), params)
+		if err != nil {
+			t.Fatalf("label %q: %v", label, err)
+		}
+		parsed, err := ParseHeader(bytes.NewReader(blob))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Label != label {
+			t.Fatalf("parsed label %q, want %q", parsed.Label, label)
+		}
+		_, err = DecryptBytes([]byte("password"), blob)
+		if err != nil {
+			t.Fatalf("label %q: %v", label, err)
+		}
+		if label == "" {
+			continue
+		}
+
+		i := bytes.Index(blob, []byte("$l=")) + len("$l=")
+		tampered := bytes.Clone(blob)
+		tampered[i] ^= 'a' ^ 'b'
+		_, err = DecryptBytes([]byte("password"), tampered)
+		if err == nil {
+			t.Fatalf("label %q: tampered label decrypted", label)
+		}
+	}
+
+	for _, label := range []string{strings.Repeat("a", maxLabelSize+1), "a$b", "a\nb"} {
+		params := testParams()
+		params.Label = label
+		err := params.Check()
+		if !errors.Is(err, ErrLabel) {
+			t.Fatalf("label %q: got error %v, want ErrLabel", label, err)
+		}
+	}
+
+	params := testParams()
+	params.Format = FormatV2
+	params.Label = "label"
+	err := params.Check()
+	if !errors.Is(err, ErrLabel) {
+		t.Fatalf("label with FormatV2: got error %v, want ErrLabel", err)
+	}
+}
+
+func TestParseHeaderConsumesHeaderOnly(t *testing.T) {
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	header, err := params.MarshalHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := append(header, "payload"...)
+
+	// A bytes.Reader is read through ReadByte, the other one a byte at a
+	// time through Read.
+	for _, src := range []io.Reader{
+		bytes.NewReader(blob),
+		iotest.HalfReader(bytes.NewReader(blob)),
+	} {
+		parsed, err := ParseHeader(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !parsed.Equal(params) {
+			t.Fatalf("parsed %v, want %v", parsed, params)
+		}
+		rest, err := io.ReadAll(src)
+		if err != nil || string(rest) != "payload" {
+			t.Fatalf("read %q after the header, %v", rest, err)
+		}
+	}
+
+	_, err = ParseHeader(bytes.NewReader(header[:len(header)-1]))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("got error %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestParseSalt(t *testing.T) {
+	// The salt holds the bytes that are encoded as '+' and '/' in the
+	// standard alphabet, and '-' and '_' in the URL-safe one, and isn't a
+	// multiple of 3 bytes long, so it is padded.
+	salt := bytes.Repeat([]byte{0xfb, 0xff}, 8)
+	params := testParams()
+	params.Salt = salt
+	header, err := params.MarshalHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := "$s=" + base64.RawStdEncoding.EncodeToString(salt) + "$"
+	if !bytes.Contains(header, []byte(written)) {
+		t.Fatalf("header %q doesn't hold the salt as %q", header, written)
+	}
+
+	// Padded URL-safe base64 is none of the encodings tried, the last of
+	// which fails at the padding.
+	padded := base64.URLEncoding.EncodeToString(salt)
+	_, errPadded := base64.RawURLEncoding.DecodeString(padded)
+
+	for _, tt := range []struct {
+		name string
+		salt string
+		err  error
+	}{
+		{"RawStdEncoding", base64.RawStdEncoding.EncodeToString(salt), nil},
+		{"StdEncoding", base64.StdEncoding.EncodeToString(salt), nil},
+		{"RawURLEncoding", base64.RawURLEncoding.EncodeToString(salt), nil},
+		{"URLEncoding", padded, errPadded},
+		{"7 bytes", base64.RawStdEncoding.EncodeToString(salt[:7]), ErrSaltTooSmall},
+		{"empty", "", ErrSaltTooSmall},
+		{"256 bytes", base64.RawStdEncoding.EncodeToString(make([]byte, 256)), ErrSalt},
+	} {
+		var p Params
+		err := p.parseSalt("s=" + tt.salt)
+		if tt.err == nil && (err != nil || !bytes.Equal(p.Salt, salt)) {
+			t.Fatalf("%s: parsed %x, %v", tt.name, p.Salt, err)
+		}
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Fatalf("%s: got error %v, want %v", tt.name, err, tt.err)
+		}
+
+		// The whole header is parsed the same.
+		tampered := bytes.Replace(header, []byte(written), []byte("$s="+tt.salt+"$"), 1)
+		parsed, err := ParseHeader(bytes.NewReader(tampered))
+		if tt.err == nil && (err != nil || !bytes.Equal(parsed.Salt, salt)) {
+			t.Fatalf("%s: ParseHeader returned %v, %v", tt.name, parsed, err)
+		}
+		if tt.err != nil && err == nil {
+			t.Fatalf("%s: ParseHeader accepted the salt", tt.name)
+		}
+	}
+}
+
+func TestParseHeaderSaltTooSmall(t *testing.T) {
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	header, err := params.MarshalHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := "$s=" + base64.RawStdEncoding.EncodeToString(params.Salt) + "$"
+
+	for _, n := range []int{1, minSaltSize - 1, minSaltSize} {
+		salt := base64.RawStdEncoding.EncodeToString(bytes.Repeat([]byte{1}, n))
+		crafted := bytes.Replace(header, []byte(written), []byte("$s="+salt+"$"), 1)
+		parsed, err := ParseHeader(bytes.NewReader(crafted))
+		if n < minSaltSize && !errors.Is(err, ErrSaltTooSmall) {
+			t.Fatalf("salt of %d bytes: got error %v, want ErrSaltTooSmall", n, err)
+		}
+		if n >= minSaltSize && (err != nil || len(parsed.Salt) != n) {
+			t.Fatalf("salt of %d bytes: parsed %v, %v", n, parsed, err)
+		}
+
+		_, err = ParsePHC("$argon2id$v=19$m=65536,t=3,p=4$" + salt)
+		if n < minSaltSize && !errors.Is(err, ErrSaltTooSmall) {
+			t.Fatalf("salt of %d bytes: got error %v from ParsePHC, want ErrSaltTooSmall", n, err)
+		}
+	}
+}
+
+func TestCheckStrict(t *testing.T) {
+	// The defaults pass, both as set by NewParams and as filled in.
+	err := NewParams().CheckStrict()
+	if err != nil {
+		t.Fatalf("NewParams: %v", err)
+	}
+	params := &Params{ChunkSize: 1024}
+	err = params.CheckStrict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Cipher != Cipher || params.Format != Format || params.ArgonMemory != ArgonMemory || params.ChunkSize != 1024 {
+		t.Fatalf("CheckStrict left %v", params)
+	}
+
+	// An unset chunk size is an error rather than the default, which
+	// Check still sets.
+	params = &Params{}
+	err = params.CheckStrict()
+	if !errors.Is(err, ErrChunkSizeUnset) || params.ChunkSize != 0 {
+		t.Fatalf("got error %v and chunk size %d, want ErrChunkSizeUnset", err, params.ChunkSize)
+	}
+	err = params.Check()
+	if err != nil || params.ChunkSize != ChunkSize {
+		t.Fatalf("Check set chunk size %d, %v", params.ChunkSize, err)
+	}
+
+	// The checks of Check are still run.
+	for _, tt := range []struct {
+		params *Params
+		err    error
+	}{
+		{&Params{ChunkSize: -1}, ErrChunkSize},
+		{&Params{ChunkSize: 1024, Cipher: "des"}, ErrCipherMismatch},
+		{&Params{ChunkSize: 1024, ArgonType: "argon2i"}, ErrArgonType},
+	} {
+		err := tt.params.CheckStrict()
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("params %v: got error %v, want %v", tt.params, err, tt.err)
+		}
+	}
+}
+
+func TestParseHeaderStrict(t *testing.T) {
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	header, err := params.MarshalHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseHeaderStrict(bytes.NewReader(header))
+	if err != nil || !parsed.Equal(params) {
+		t.Fatalf("parsed %v, %v", parsed, err)
+	}
+
+	// Fields left empty or zero, which Check defaults, pass the lenient
+	// parser only.
+	for _, tt := range []struct{ old, new, missing string }{
+		{"$argon2id$", "$$", "type"},
+		{"$v=19$", "$v=0$", "v"},
+		{"$t=1,", "$t=0,", "t"},
+		{",m=64,", ",m=0,", "m"},
+		{",p=1$", ",p=0$", "p"},
+		{"$b=64", "$b=0", "b"},
+	} {
+		crafted := bytes.Replace(header, []byte(tt.old), []byte(tt.new), 1)
+		if bytes.Equal(crafted, header) {
+			t.Fatalf("%q isn't in the header %q", tt.old, header)
+		}
+		_, err := ParseHeader(bytes.NewReader(crafted))
+		if err != nil {
+			t.Fatalf("%q: lenient parser returned %v", crafted, err)
+		}
+		_, err = ParseHeaderStrict(bytes.NewReader(crafted))
+		if !errors.Is(err, ErrIncompleteHeader) || !strings.HasSuffix(err.Error(), ": "+tt.missing) {
+			t.Fatalf("%q: got error %v, want ErrIncompleteHeader naming %s", crafted, err, tt.missing)
+		}
+	}
+
+	// A salt can't be left empty in a header, but params parsed without
+	// one are incomplete all the same.
+	err = (&Params{ArgonType: ArgonType, ArgonVersion: 19, ArgonTime: 1, ArgonMemory: 64, ArgonThreads: 1, ChunkSize: 64}).checkComplete()
+	if !errors.Is(err, ErrIncompleteHeader) || !strings.HasSuffix(err.Error(), ": s") {
+		t.Fatalf("got error %v, want ErrIncompleteHeader naming s", err)
+	}
+}
+
+func TestParseHeaderScan(t *testing.T) {
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	header, err := params.MarshalHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, junk := range []string{
+		"",
+		"junk",
+		"$",
+		"$argon",
+		"$argon2i$",
+		"$$argon2id",
+		"$argon2id",
+		"x$argon2id$",
+		"argon2id$",
+		"$argon2\n$argon2id\n",
+		strings.Repeat("$argon2", 10),
+	} {
+		src := strings.NewReader(junk + string(header) + "payload")
+		parsed, err := ParseHeaderScan(src, len(junk))
+		if err != nil || !parsed.Equal(params) {
+			t.Fatalf("junk %q: parsed %v, %v", junk, parsed, err)
+		}
+		rest, _ := io.ReadAll(src)
+		if string(rest) != "payload" {
+			t.Fatalf("junk %q: read %q after the header", junk, rest)
+		}
+
+		if junk == "" {
+			continue
+		}
+		_, err = ParseHeaderScan(strings.NewReader(junk+string(header)), len(junk)-1)
+		if !errors.Is(err, ErrMagicNotFound) {
+			t.Fatalf("junk %q: got error %v past the limit, want ErrMagicNotFound", junk, err)
+		}
+		_, err = ParseHeaderScan(strings.NewReader(junk), 100)
+		if !errors.Is(err, ErrMagicNotFound) {
+			t.Fatalf("junk %q: got error %v without a header, want ErrMagicNotFound", junk, err)
+		}
+	}
+}
+
+func TestMarshalHeader(t *testing.T) {
+	for _, params := range []*Params{NewParams(), testParams()} {
+		_, err := params.MarshalHeader()
+		if !errors.Is(err, ErrNoSalt) {
+			t.Fatalf("params %v: got error %v, want ErrNoSalt", params, err)
+		}
+	}
+
+	// Key generates the salt the header is then marshaled with.
+	params := testParams()
+	_, err := Key([]byte("password"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := params.MarshalHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseHeader(bytes.NewReader(header))
+	if err != nil || len(parsed.Salt) != SaltSize || !parsed.Equal(params) {
+		t.Fatalf("parsed %v, %v, want %v", parsed, err, params)
+	}
+}
+
+func TestParseHeaderRaw(t *testing.T) {
+	salt := bytes.Repeat([]byte{1}, SaltSize)
+	for _, params := range []*Params{
+		{ArgonMemory: 64, ArgonThreads: 1, ChunkSize: 64, Salt: salt},
+		{ArgonMemory: 64, ArgonThreads: 1, ChunkSize: 64, Salt: salt, Format: FormatV1},
+		{ArgonMemory: 64, ArgonThreads: 1, ChunkSize: 64, Salt: salt, Cipher: AES256GCM, Label: "backup", Trailer: true},
+		{ArgonMemory: 64, ArgonThreads: 1, ChunkSize: 64, Salt: salt, Digest: make([]byte, DigestSize), Compress: true},
+	} {
+		header, err := params.MarshalHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := bytes.NewReader(append(bytes.Clone(header), "payload"...))
+		parsed, raw, err := ParseHeaderRaw(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(raw, header) {
+			t.Fatalf("got raw header %q, want %q", raw, header)
+		}
+		if !parsed.Equal(params) {
+			t.Fatalf("parsed %v, want %v", parsed, params)
+		}
+		if src.Len() != len("payload") {
+			t.Fatalf("%d bytes left after the header, want %d", src.Len(), len("payload"))
+		}
+	}
+
+	// A salt in padded base64 is kept as read, though marshaling the
+	// params again doesn't pad it.
+	params := testParams()
+	params.Salt = salt
+	header, err := params.MarshalHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	padded := bytes.Replace(header,
+		[]byte(base64.RawStdEncoding.EncodeToString(salt)),
+		[]byte(base64.StdEncoding.EncodeToString(salt)), 1)
+	parsed, raw, err := ParseHeaderRaw(bytes.NewReader(padded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	remarshaled, err := parsed.MarshalHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, padded) || !bytes.Equal(remarshaled, header) {
+		t.Fatalf("got raw header %q and marshaled %q, want %q and %q", raw, remarshaled, padded, header)
+	}
+}
+
+func TestParamsString(t *testing.T) {
+	var params *Params
+	if s := params.String(); s != "<nil>" {
+		t.Fatalf("nil params are shown as %q", s)
+	}
+
+	params = NewParams()
+	params.Salt = bytes.Repeat([]byte{0xab}, SaltSize)
+	s := params.String()
+	if !strings.Contains(s, "salt=16 bytes") {
+		t.Fatalf("%q doesn't show the salt length", s)
+	}
+	if strings.Contains(s, "abab") || strings.Contains(s, base64.RawStdEncoding.EncodeToString(params.Salt)) {
+		t.Fatalf("%q shows the salt", s)
+	}
+}
@@ -0,0 +1,212 @@
+package encdec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+var (
+	ErrPasswordTimeout = errors.New("timed out waiting for password")
+	ErrNoTTY           = errors.New("no terminal available")
+)
+
+// ReadPassword reads the password from stdin without local echo,
+// displaying message before reading the password.
+// It is safe to interrupt the program with SIGINT when blocked
+// by this function as it will restore the previous state of terminal on exit.
+func ReadPassword(message string, repeat bool) ([]byte, error) {
+	return ReadPasswordFrom(os.Stdin, os.Stdout, message, repeat)
+}
+
+// ReadPasswordTimeout works like ReadPasswordContext, giving up waiting for
+// the password after d and returning ErrPasswordTimeout.
+func ReadPasswordTimeout(message string, repeat bool, d time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	password, err := ReadPasswordContext(ctx, message, repeat)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, ErrPasswordTimeout
+	}
+	return password, err
+}
+
+// ReadPasswordContext works like ReadPasswordTTY, but gives up waiting for
+// the password once ctx is done, returning ctx.Err(). The read is ended
+// through a deadline on the terminal, which is then restored and closed,
+// so nothing typed afterwards is consumed or echoed. On Windows, where the
+// console doesn't support deadlines, the read still waits for a line.
+//
+// The terminal is read in raw mode, so Ctrl-C ends the read with io.EOF
+// instead of interrupting the program. A SIGINT sent otherwise restores
+// the terminal and exits, as with ReadPassword.
+func ReadPasswordContext(ctx context.Context, message string, repeat bool) ([]byte, error) {
+	tty, err := openTTY()
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+
+	// The descriptor is only used through conn, as calling Fd would
+	// make it blocking, out of reach of the deadline.
+	conn, err := tty.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var state *term.State
+	err = control(conn, func(fd int) (err error) {
+		state, err = term.MakeRaw(fd)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	restore := func() {
+		control(conn, func(fd int) error {
+			return term.Restore(fd, state)
+		})
+	}
+
+	signalCtx, stopSignal := signal.NotifyContext(ctx, os.Interrupt)
+	defer stopSignal()
+	stop := context.AfterFunc(signalCtx, func() {
+		tty.SetReadDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	t := term.NewTerminal(tty, "")
+	password, err := readPassword(io.Discard, "", repeat, func() ([]byte, error) {
+		line, err := t.ReadPassword(message)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(line), nil
+	})
+	restore()
+	if err != nil {
+		fmt.Fprintln(tty, "")
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if signalCtx.Err() != nil {
+			os.Exit(1)
+		}
+	}
+	return password, err
+}
+
+// control calls f with the descriptor of conn, returning its error.
+func control(conn syscall.RawConn, f func(fd int) error) error {
+	var ferr error
+	err := conn.Control(func(fd uintptr) {
+		ferr = f(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	return ferr
+}
+
+// ReadPasswordTTY works like ReadPassword, but reads the password from the
+// terminal of the process, so stdin is free to carry data. If there is no
+// terminal, the returned error wraps ErrNoTTY, while other errors opening
+// it, such as lacking permission, are returned as they are.
+func ReadPasswordTTY(message string, repeat bool) ([]byte, error) {
+	tty, err := openTTY()
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+
+	return ReadPasswordFrom(tty, tty, message, repeat)
+}
+
+// ReadPasswordFrom works like ReadPassword, reading the password from in
+// and writing message to out. If in is not a terminal, such as a pipe,
+// the password is read as a line of text.
+func ReadPasswordFrom(in *os.File, out io.Writer, message string, repeat bool) ([]byte, error) {
+	fd := int(in.Fd())
+	if !term.IsTerminal(fd) {
+		return readPassword(out, message, repeat, func() ([]byte, error) {
+			return readLine(in)
+		})
+	}
+
+	passwordCtx, passwordCancel := context.WithCancel(context.Background())
+	defer passwordCancel()
+	state, err := term.GetState(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	signalCtx, signalCancel := signal.NotifyContext(passwordCtx, os.Interrupt)
+	go func() {
+		<-signalCtx.Done()
+		signalCancel()
+		if passwordCtx.Err() != nil {
+			return
+		}
+		term.Restore(fd, state)
+		passwordCancel()
+		fmt.Fprintln(out, "")
+		os.Exit(1)
+	}()
+	return readPassword(out, message, repeat, func() ([]byte, error) {
+		return term.ReadPassword(fd)
+	})
+}
+
+func readPassword(out io.Writer, message string, repeat bool, read func() ([]byte, error)) ([]byte, error) {
+	fmt.Fprint(out, message)
+	password, err := read()
+	fmt.Fprintln(out, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if repeat {
+		fmt.Fprint(out, message)
+		password_check, err := read()
+		fmt.Fprintln(out, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(password, password_check) {
+			return nil, errors.New("passwords don't match")
+		}
+	}
+
+	return password, nil
+}
+
+// readLine reads a line from r, without the line terminator.
+// It reads one byte at a time so nothing past the line is consumed.
+func readLine(r io.Reader) ([]byte, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			if len(line) > 0 && errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+
+	return bytes.TrimSuffix(line, []byte("\r")), nil
+}
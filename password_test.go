@@ -0,0 +1,84 @@
+package encdec
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+// pipeWith returns the read end of a pipe holding input, which is not a
+// terminal, so ReadPasswordFrom reads lines of text from it.
+func pipeWith(t *testing.T, input string) *os.File {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	_, err = io.WriteString(w, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return r
+}
+
+func TestReadPasswordFromPipe(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		repeat   bool
+		want     string
+		wantErr  bool
+		prompted string
+	}{
+		{"line", "secret\n", false, "secret", false, "Password: \n"},
+		{"crlf", "secret\r\n", false, "secret", false, "Password: \n"},
+		{"no newline", "secret", false, "secret", false, "Password: \n"},
+		{"empty line", "\n", false, "", false, "Password: \n"},
+		{"repeat", "secret\nsecret\n", true, "secret", false, "Password: \nPassword: \n"},
+		{"repeat mismatch", "secret\nother\n", true, "", true, "Password: \nPassword: \n"},
+		{"repeat missing", "secret\n", true, "", true, "Password: \nPassword: \n"},
+		{"empty", "", false, "", true, "Password: \n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			password, err := ReadPasswordFrom(pipeWith(t, test.input), &out, "Password: ", test.repeat)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("got password %q, want an error", password)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if string(password) != test.want {
+				t.Fatalf("got password %q, want %q", password, test.want)
+			}
+			if out.String() != test.prompted {
+				t.Fatalf("wrote %q, want %q", out.String(), test.prompted)
+			}
+		})
+	}
+}
+
+func TestReadPasswordFromPipeLeavesRest(t *testing.T) {
+	// Only the line of the password is consumed, so the rest of the
+	// input is left for the caller.
+	in := pipeWith(t, "secret\nrest of the input")
+	password, err := ReadPasswordFrom(in, io.Discard, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(password) != "secret" {
+		t.Fatalf("got password %q", password)
+	}
+	rest, err := io.ReadAll(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "rest of the input" {
+		t.Fatalf("left %q", rest)
+	}
+}
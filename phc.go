@@ -0,0 +1,171 @@
+package encdec
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MarshalPHC returns the params as a string in the PHC string format,
+// such as "$argon2id$v=19$m=2097152,t=1,p=4$<salt>", which argon2 tools
+// can read the KDF params from.
+//
+// Unlike a header, the string has no hash and the m, t and p parameters
+// follow the order of the argon2 reference implementation. The fields
+// specific to encdec are only appended to the parameters when they differ
+// from their default values: the chunk size as b, the cipher as c and the
+// format as f. So the string of params with the default values of those
+// is a standard one, while tools that reject parameters they don't know
+// need the others removed first. Unlike in a header, a missing format is
+// the default one, not FormatV1. Params with a Normalization, Context,
+// Label, Digest, Signature, Extensions, Trailer, Compress, Frames,
+// Erasure or Profile, or a KeySize other than 32, can't be represented
+// and return an error naming them.
+func (p *Params) MarshalPHC() (string, error) {
+	err := p.checkFormatted()
+	if err != nil {
+		return "", err
+	}
+	unsupported := p.phcUnsupported()
+	if len(unsupported) != 0 {
+		return "", fmt.Errorf("params: %s can't be represented in PHC format", strings.Join(unsupported, ", "))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(
+		&b,
+		"$%s$v=%d$m=%d,t=%d,p=%d",
+		p.ArgonType,
+		p.ArgonVersion,
+		p.ArgonMemory,
+		p.ArgonTime,
+		p.ArgonThreads,
+	)
+	if p.ChunkSize != ChunkSize {
+		fmt.Fprintf(&b, ",b=%d", p.ChunkSize)
+	}
+	if p.Cipher != Cipher {
+		fmt.Fprintf(&b, ",c=%s", p.Cipher)
+	}
+	if p.Format != Format {
+		fmt.Fprintf(&b, ",f=%d", p.Format)
+	}
+	fmt.Fprintf(&b, "$%s", base64.RawStdEncoding.EncodeToString(p.Salt))
+
+	return b.String(), nil
+}
+
+// phcUnsupported returns the names of the fields set in p that can't be
+// represented in PHC format.
+func (p *Params) phcUnsupported() []string {
+	var names []string
+	for _, field := range []struct {
+		name string
+		set  bool
+	}{
+		{"normalization", p.Normalization != ""},
+		{"context", p.Context != ""},
+		{"label", p.Label != ""},
+		{"digest", len(p.Digest) != 0},
+		{"signature", len(p.Signature) != 0},
+		{"extensions", len(p.Extensions) != 0},
+		{"trailer", p.Trailer},
+		{"compression", p.Compress},
+		{"frames", p.Frames},
+		{"erasure coding", p.Erasure != (Erasure{})},
+		{"profile", p.Profile != ""},
+		{fmt.Sprintf("key size %d", p.KeySize), p.KeySize != keySize},
+	} {
+		if field.set {
+			names = append(names, field.name)
+		}
+	}
+	return names
+}
+
+// ParsePHC parses params from s, a string in the PHC string format as
+// returned by MarshalPHC or written by other argon2 tools. The hash, if
+// any, is ignored, and the fields specific to encdec that are missing
+// take their default values.
+func ParsePHC(s string) (*Params, error) {
+	errParsing := errors.New("parsing PHC string: corrupted string")
+
+	args := strings.Split(s, "$")
+	if len(args) < 5 || len(args) > 6 || args[0] != "" {
+		return nil, errParsing
+	}
+
+	params := Params{
+		ArgonType: args[1],
+		Cipher:    Cipher,
+		Format:    Format,
+	}
+
+	key, value, ok := strings.Cut(args[2], "=")
+	if !ok || key != "v" {
+		return nil, errParsing
+	}
+	u, err := strconv.ParseUint(value, 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("parsing PHC string: parsing argon2 version: %w", err)
+	}
+	params.ArgonVersion = uint8(u)
+
+	seen := make(map[string]bool)
+	for _, param := range strings.Split(args[3], ",") {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok || seen[key] {
+			return nil, errParsing
+		}
+		seen[key] = true
+
+		switch key {
+		case "m":
+			u, err = strconv.ParseUint(value, 10, 32)
+			params.ArgonMemory = uint32(u)
+		case "t":
+			u, err = strconv.ParseUint(value, 10, 32)
+			params.ArgonTime = uint32(u)
+		case "p":
+			u, err = strconv.ParseUint(value, 10, 8)
+			params.ArgonThreads = uint8(u)
+		case "b":
+			params.ChunkSize, err = strconv.ParseInt(value, 10, 64)
+		case "c":
+			params.Cipher = value
+		case "f":
+			u, err = strconv.ParseUint(value, 10, 8)
+			if err == nil && u == 0 {
+				return nil, errParsing
+			}
+			params.Format = uint8(u)
+		default:
+			return nil, errParsing
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing PHC string: parsing %s: %w", key, err)
+		}
+	}
+	if !seen["m"] || !seen["t"] || !seen["p"] {
+		return nil, errParsing
+	}
+
+	params.Salt, err = decodeSalt(args[4])
+	if err != nil {
+		return nil, fmt.Errorf("parsing PHC string: parsing salt: %w", err)
+	}
+	err = checkSaltLength(len(params.Salt))
+	if err != nil {
+		return nil, fmt.Errorf("parsing PHC string: parsing salt: %w", err)
+	}
+	params.SaltSize = uint8(len(params.Salt))
+
+	err = params.Check()
+	if err != nil {
+		return nil, fmt.Errorf("parsing PHC string: %w", err)
+	}
+
+	return &params, nil
+}
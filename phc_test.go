@@ -0,0 +1,96 @@
+package encdec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMarshalPHC(t *testing.T) {
+	salt := bytes.Repeat([]byte{1}, SaltSize)
+	params := &Params{ArgonMemory: 65536, ArgonTime: 3, Salt: salt}
+	err := params.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// With the default chunk size, cipher and format, the string is
+	// a standard one, without any parameter specific to encdec.
+	s, err := params.MarshalPHC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "$argon2id$v=19$m=65536,t=3,p=4$AQEBAQEBAQEBAQEBAQEBAQ"
+	if s != want {
+		t.Fatalf("got %q, want %q", s, want)
+	}
+	parsed, err := ParsePHC(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Equal(params) {
+		t.Fatalf("parsed %v, want %v", parsed, params)
+	}
+
+	params = &Params{ArgonMemory: 65536, ArgonTime: 3, Salt: salt, ChunkSize: 1024, Cipher: AES256GCM, Format: FormatV1}
+	err = params.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = params.MarshalPHC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "$argon2id$v=19$m=65536,t=3,p=4,b=1024,c=aes256gcm,f=1$AQEBAQEBAQEBAQEBAQEBAQ"
+	if s != want {
+		t.Fatalf("got %q, want %q", s, want)
+	}
+	parsed, err = ParsePHC(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Equal(params) {
+		t.Fatalf("parsed %v, want %v", parsed, params)
+	}
+
+	// The error names every field that can't be represented.
+	params = &Params{Salt: salt, Normalization: NormalizationNFC, Label: "label", Compress: true}
+	err = params.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = params.MarshalPHC()
+	if err == nil || !strings.Contains(err.Error(), "normalization, label, compression can't") {
+		t.Fatalf("got error %v, naming the normalization, label and compression", err)
+	}
+}
+
+func TestParsePHC(t *testing.T) {
+	// A string written by the argon2 reference implementation, whose
+	// hash is ignored.
+	params, err := ParsePHC("$argon2id$v=19$m=65536,t=3,p=4$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.ArgonMemory != 65536 || params.ArgonTime != 3 || params.ArgonThreads != 4 || string(params.Salt) != "somesalt" {
+		t.Fatalf("parsed %v", params)
+	}
+	if params.ChunkSize != ChunkSize || params.Cipher != Cipher || params.Format != Format {
+		t.Fatalf("parsed %v, without the default chunk size, cipher and format", params)
+	}
+
+	for _, s := range []string{
+		"",
+		"argon2id$v=19$m=65536,t=3,p=4$c29tZXNhbHQ",
+		"$argon2id$v=19$m=65536,t=3$c29tZXNhbHQ",
+		"$argon2id$v=19$m=65536,t=3,p=4,m=1$c29tZXNhbHQ",
+		"$argon2id$v=19$m=65536,t=3,p=4,x=1$c29tZXNhbHQ",
+		"$argon2id$v=19$m=65536,t=3,p=4,f=0$c29tZXNhbHQ",
+		"$argon2id$v=19$m=65536,t=3,p=4$c2FsdA",
+	} {
+		_, err = ParsePHC(s)
+		if err == nil {
+			t.Fatalf("%q was parsed", s)
+		}
+	}
+}
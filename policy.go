@@ -0,0 +1,125 @@
+package encdec
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var ErrPolicyViolation = errors.New("params violate policy")
+
+// ErrArgonCostTooHigh is returned for params whose key derivation is
+// estimated to take longer than the MaxArgonDuration of a Policy.
+// It wraps ErrPolicyViolation.
+var ErrArgonCostTooHigh = fmt.Errorf("%w: argon2 cost too high", ErrPolicyViolation)
+
+// Policy restricts the params accepted when decrypting, such as the params
+// parsed from the header of an untrusted file. Fields with the zero value
+// impose no restriction.
+type Policy struct {
+	// MinChunkSize and MaxChunkSize bound the chunk size, inclusive.
+	MinChunkSize int64
+	MaxChunkSize int64
+
+	// Ciphers lists the allowed ciphers.
+	Ciphers []string
+
+	// ArgonTypes lists the allowed Argon2 variants.
+	ArgonTypes []string
+
+	// MaxArgonDuration bounds how long deriving the key is estimated to
+	// take on this machine, so a header with a huge ArgonTime or
+	// ArgonMemory can't keep the derivation running for hours. As the key
+	// is derived before NewReader is called, the policy must be checked
+	// by CheckParams right after parsing the header to be of use.
+	MaxArgonDuration time.Duration
+}
+
+// Check returns an error wrapping ErrPolicyViolation if params aren't
+// allowed by the policy.
+func (p *Policy) Check(params *Params) error {
+	if params == nil {
+		return ErrNilParams
+	}
+
+	if p.MinChunkSize != 0 && params.ChunkSize < p.MinChunkSize {
+		return fmt.Errorf("%w: chunk size %d is less than %d", ErrPolicyViolation, params.ChunkSize, p.MinChunkSize)
+	}
+	if p.MaxChunkSize != 0 && params.ChunkSize > p.MaxChunkSize {
+		return fmt.Errorf("%w: chunk size %d is greater than %d", ErrPolicyViolation, params.ChunkSize, p.MaxChunkSize)
+	}
+
+	if p.Ciphers != nil && !slices.Contains(p.Ciphers, params.Cipher) {
+		return fmt.Errorf("%w: cipher %q is not allowed", ErrPolicyViolation, params.Cipher)
+	}
+
+	if p.ArgonTypes != nil && !slices.Contains(p.ArgonTypes, params.ArgonType) {
+		return fmt.Errorf("%w: argon type %q is not allowed", ErrPolicyViolation, params.ArgonType)
+	}
+
+	if p.MaxArgonDuration != 0 {
+		d := estimateArgonDuration(params)
+		if d > p.MaxArgonDuration {
+			return fmt.Errorf("%w: estimated %v, more than %v", ErrArgonCostTooHigh, d.Round(time.Millisecond), p.MaxArgonDuration)
+		}
+	}
+
+	return nil
+}
+
+// CheckParams works like Check, first checking that params are valid, and
+// leaves params unchanged. It is meant to be called right after parsing a
+// header, before Key, so params that are too costly to derive the key of
+// are refused before the derivation starts rather than after it, as they
+// are by NewReader. A nil policy only checks that params are valid.
+func (p *Policy) CheckParams(params *Params) error {
+	if params == nil {
+		return ErrNilParams
+	}
+
+	checked := *params
+	err := checked.checkFormatted()
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return nil
+	}
+	return p.Check(&checked)
+}
+
+// argonCalibrationMemory is the memory, in KiB, of the derivation timed to
+// calibrate estimateArgonDuration, small enough to take a few milliseconds.
+const argonCalibrationMemory = 8 << 10
+
+var argonCalibration struct {
+	once sync.Once
+
+	// cost is the time taken per KiB of memory per pass by one thread.
+	cost float64
+}
+
+// estimateArgonDuration estimates how long deriving the key of params
+// takes, from the time taken by a small derivation timed once per process.
+// Argon2 takes time in proportion to its passes times its memory, divided
+// among its threads while there are CPUs to run them.
+func estimateArgonDuration(params *Params) time.Duration {
+	argonCalibration.once.Do(func() {
+		start := time.Now()
+		argon2.IDKey(nil, make([]byte, SaltSize), 1, argonCalibrationMemory, 1, keySize)
+		argonCalibration.cost = float64(time.Since(start)) / argonCalibrationMemory
+	})
+
+	threads := max(1, min(int(params.ArgonThreads), runtime.NumCPU()))
+	d := argonCalibration.cost * float64(max(params.ArgonTime, 1)) * float64(params.ArgonMemory) / float64(threads)
+	if d >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(d)
+}
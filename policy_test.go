@@ -0,0 +1,65 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPolicyArgonTime(t *testing.T) {
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	header, err := params.MarshalHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &Policy{MaxArgonDuration: time.Minute}
+
+	// withTime returns header with t= set to s.
+	withTime := func(s string) []byte {
+		crafted := bytes.Replace(header, []byte("$t=1,"), []byte("$t="+s+","), 1)
+		if bytes.Equal(crafted, header) {
+			t.Fatalf("no t=1 in the header %q", header)
+		}
+		return crafted
+	}
+
+	// The most passes a header can hold are refused before any key is
+	// derived, by CheckParams, and by NewReader.
+	huge, err := ParseHeaderBytes(withTime(strconv.FormatUint(1<<32-1, 10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = policy.CheckParams(huge)
+	if !errors.Is(err, ErrArgonCostTooHigh) {
+		t.Fatalf("t=%d: got error %v, want ErrArgonCostTooHigh", huge.ArgonTime, err)
+	}
+	_, err = NewReader(make([]byte, keySize), bytes.NewReader(nil), huge, WithPolicy(policy))
+	if !errors.Is(err, ErrArgonCostTooHigh) {
+		t.Fatalf("t=%d: got error %v from NewReader, want ErrArgonCostTooHigh", huge.ArgonTime, err)
+	}
+
+	// One more doesn't fit the field.
+	_, err = ParseHeaderBytes(withTime("4294967296"))
+	if !errors.Is(err, strconv.ErrRange) {
+		t.Fatalf("t=4294967296: got error %v, want strconv.ErrRange", err)
+	}
+
+	// No passes is the default number once parsed, which strict parsing
+	// refuses. CheckParams checks the default without setting it.
+	zero, err := ParseHeaderBytes(withTime("0"))
+	if err != nil || zero.ArgonTime != ArgonTime {
+		t.Fatalf("t=0: parsed %v, %v", zero, err)
+	}
+	_, err = ParseHeaderStrict(bytes.NewReader(withTime("0")))
+	if !errors.Is(err, ErrIncompleteHeader) {
+		t.Fatalf("t=0: got error %v, want ErrIncompleteHeader", err)
+	}
+	unset := testParams()
+	err = policy.CheckParams(unset)
+	if err != nil || unset.ArgonTime != 0 {
+		t.Fatalf("t=0: got error %v, left t=%d", err, unset.ArgonTime)
+	}
+}
@@ -0,0 +1,33 @@
+package encdec
+
+import "sync"
+
+// chunkPools holds a *sync.Pool of chunk buffers for every buffer capacity
+// in use, so short-lived Writers and Readers reuse the buffers of closed
+// ones instead of allocating their own.
+var chunkPools sync.Map
+
+// getChunkBuffer returns an empty buffer with capacity size.
+func getChunkBuffer(size int) []byte {
+	pool, _ := chunkPools.LoadOrStore(size, new(sync.Pool))
+	b, ok := pool.(*sync.Pool).Get().(*[]byte)
+	if !ok {
+		return make([]byte, 0, size)
+	}
+	return (*b)[:0]
+}
+
+// putChunkBuffer zeroes b, as it may hold plaintext, and returns it
+// to the pool of its capacity. b must not be used afterwards.
+func putChunkBuffer(b []byte) {
+	if b == nil {
+		return
+	}
+
+	b = b[:cap(b)]
+	clear(b)
+	pool, ok := chunkPools.Load(cap(b))
+	if ok {
+		pool.(*sync.Pool).Put(&b)
+	}
+}
@@ -0,0 +1,153 @@
+package encdec
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestChunkBufferZeroed(t *testing.T) {
+	b := getChunkBuffer(100)
+	b = append(b, bytes.Repeat([]byte{0xff}, 100)...)
+	putChunkBuffer(b)
+
+	// The pool may drop the buffer, but any buffer it returns must be
+	// empty and zeroed.
+	b = getChunkBuffer(100)
+	if len(b) != 0 || cap(b) != 100 {
+		t.Fatalf("got a buffer of length %d and capacity %d", len(b), cap(b))
+	}
+	if !bytes.Equal(b[:cap(b)], make([]byte, 100)) {
+		t.Fatal("a pooled buffer still holds its data")
+	}
+}
+
+// usesPooled reports whether b is held in the pooled buffer, checking that
+// both end at the same place of the same array.
+func usesPooled(b []byte, pooled []byte) bool {
+	b, pooled = b[:cap(b)], pooled[:cap(pooled)]
+	return len(b) > 0 && &b[len(b)-1] == &pooled[len(pooled)-1]
+}
+
+func TestReaderWriterPooledBuffer(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	plaintext := bytes.Repeat([]byte("plaintext"), 50)
+
+	for name, params := range map[string]*Params{
+		"fixed":    {ChunkSize: 64},
+		"frames":   {ChunkSize: 64, Frames: true},
+		"compress": {ChunkSize: 64, Compress: true},
+		"erasure":  {ChunkSize: 64, Erasure: Erasure{Data: 2, Parity: 1}},
+	} {
+		params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+		var out bytes.Buffer
+		w, err := NewWriter(key, &out, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !usesPooled(w.buff.Bytes(), w.pooled) {
+			t.Fatalf("%s: Writer buffers outside its pooled buffer", name)
+		}
+		pooled := w.pooled[:cap(w.pooled)]
+		err = w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(pooled, make([]byte, len(pooled))) {
+			t.Fatalf("%s: Writer didn't clear its buffer on Close", name)
+		}
+
+		// Every chunk, full or not, is decrypted into the pooled
+		// buffer, read a byte at a time so it is never empty.
+		r, err := NewReader(key, &out, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pooled = r.pooled[:cap(r.pooled)]
+		var got []byte
+		b := make([]byte, 1)
+		for {
+			_, err = r.Read(b)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, b[0])
+			if r.buff.Len() > 0 && !usesPooled(r.buff.Bytes(), pooled) {
+				t.Fatalf("%s: chunk ending at %d decrypted outside the pooled buffer", name, len(got)+r.buff.Len())
+			}
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("%s: read data doesn't match the plaintext", name)
+		}
+		err = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(pooled, make([]byte, len(pooled))) {
+			t.Fatalf("%s: Reader didn't clear its buffer on Close", name)
+		}
+	}
+}
+
+// BenchmarkShortStreams encrypts and decrypts many short streams, each one
+// with its own Writer and Reader, with their buffers pooled or, as before
+// pooling, allocated for every stream.
+func BenchmarkShortStreams(b *testing.B) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := &Params{Salt: bytes.Repeat([]byte{1}, SaltSize)}
+	err := params.Check()
+	if err != nil {
+		b.Fatal(err)
+	}
+	plaintext := []byte("a short message")
+
+	for _, pooled := range []bool{true, false} {
+		name := "pooled"
+		if !pooled {
+			name = "unpooled"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			var ciphertext bytes.Buffer
+			for range b.N {
+				if !pooled {
+					chunkPools.Clear()
+				}
+
+				ciphertext.Reset()
+				w, err := NewWriter(key, &ciphertext, params)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, err = w.Write(plaintext)
+				if err != nil {
+					b.Fatal(err)
+				}
+				err = w.Close()
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				r, err := NewReader(key, &ciphertext, params)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, err = io.Copy(io.Discard, r)
+				if err != nil {
+					b.Fatal(err)
+				}
+				err = r.Close()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package encdec
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrUnknownProfile = errors.New("unknown profile")
+
+var (
+	profilesMu sync.RWMutex
+	profiles   = make(map[string]*Params)
+)
+
+// RegisterProfile registers params under name, so headers of params with
+// Profile set to name only hold the name and the salt, while the other
+// fields are taken from the registered params when parsing. Both sides
+// must register the same params under the same name.
+//
+// The salt of params is ignored, and a name can only be registered once.
+func RegisterProfile(name string, params *Params) error {
+	if params == nil {
+		return ErrNilParams
+	}
+	if name == "" || len(name) > maxContextSize || !isHeaderText(name) {
+		return fmt.Errorf("invalid profile name %q", name)
+	}
+
+	profile := *params
+	profile.Salt = nil
+	profile.Profile = name
+	err := profile.checkFormatted()
+	if err != nil {
+		return err
+	}
+
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+	if _, ok := profiles[name]; ok {
+		return fmt.Errorf("profile %q already registered", name)
+	}
+	profiles[name] = &profile
+	return nil
+}
+
+// ProfileParams returns a copy of the params registered under name, without
+// a salt, or an error wrapping ErrUnknownProfile if there are none.
+func ProfileParams(name string) (*Params, error) {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProfile, name)
+	}
+
+	params := *profile
+	return &params, nil
+}
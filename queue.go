@@ -0,0 +1,124 @@
+package encdec
+
+import (
+	"io"
+	"sync"
+)
+
+// WithBufferedChunks makes the Writer hand the encrypted chunks to a
+// goroutine writing them to the underlying writer, holding up to n of
+// them while it does. Write only blocks once n chunks are waiting to be
+// written, so a slow underlying writer holds up Write after n chunks,
+// rather than after each one, bounding memory to n+1 chunks in total.
+// This lets a proxy trade memory for latency when the destination stalls.
+//
+// By default, and with n <= 0, every chunk is written as it is filled,
+// so Write blocks on the underlying writer itself and only the chunk
+// being filled is held in memory. An error of the underlying writer is
+// returned by the Write or Close following it, and Close waits for every
+// chunk to be written. It can't be combined with WithChunkLog, as chunks
+// are recorded before being written.
+func WithBufferedChunks(n int) WriterOption {
+	return writerOptionFunc(func(c *writerConfig) {
+		c.bufferedChunks = n
+	})
+}
+
+// chunkQueue writes to dst from its own goroutine, holding up to as many
+// chunks as given to newChunkQueue while it does. The goroutine is only
+// started by the first write, so a Writer dropped by a failing constructor
+// doesn't leave it behind.
+type chunkQueue struct {
+	dst     io.Writer
+	pending chan []byte
+	free    chan []byte
+	done    chan struct{}
+	queued  sync.WaitGroup
+	start   sync.Once
+	stop    sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+func newChunkQueue(dst io.Writer, chunks int, size int) *chunkQueue {
+	q := &chunkQueue{
+		dst:     dst,
+		pending: make(chan []byte, chunks),
+		free:    make(chan []byte, chunks),
+		done:    make(chan struct{}),
+	}
+	for range chunks {
+		q.free <- make([]byte, 0, size)
+	}
+	return q
+}
+
+func (q *chunkQueue) run() {
+	defer close(q.done)
+	for p := range q.pending {
+		// After a failure the chunks are dropped, so Write never
+		// blocks on a queue that isn't drained.
+		if q.failed() == nil {
+			_, err := q.dst.Write(p)
+			if err != nil {
+				q.mu.Lock()
+				q.err = err
+				q.mu.Unlock()
+			}
+		}
+		q.free <- p[:0]
+		q.queued.Done()
+	}
+}
+
+func (q *chunkQueue) failed() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+// Write queues a copy of p, blocking while the queue is full.
+func (q *chunkQueue) Write(p []byte) (int, error) {
+	err := q.failed()
+	if err != nil {
+		return 0, err
+	}
+
+	q.start.Do(func() { go q.run() })
+	buff := <-q.free
+	q.queued.Add(1)
+	q.pending <- append(buff, p...)
+	return len(p), nil
+}
+
+// wait waits for every chunk queued to be written, returning the error
+// of the underlying writer, if any.
+func (q *chunkQueue) wait() error {
+	q.queued.Wait()
+	return q.failed()
+}
+
+// close waits for every chunk queued to be written, returning the error
+// of the underlying writer, if any. It is safe to call more than once.
+func (q *chunkQueue) close() error {
+	q.start.Do(func() { go q.run() })
+	q.stop.Do(func() { close(q.pending) })
+	<-q.done
+	return q.failed()
+}
+
+// Flush waits for every chunk queued to be written, and flushes dst if it
+// has a Flush() error method.
+func (q *chunkQueue) Flush() error {
+	err := q.close()
+	if err != nil {
+		return err
+	}
+
+	flusher, ok := q.dst.(interface{ Flush() error })
+	if !ok {
+		return nil
+	}
+	return flusher.Flush()
+}
@@ -0,0 +1,83 @@
+package encdec
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gateWriter stalls every write until release is closed.
+type gateWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	buff    bytes.Buffer
+}
+
+func (w *gateWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buff.Write(p)
+}
+
+func TestWithBufferedChunks(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	const chunks = 20
+
+	for _, n := range []int{0, 1, 4} {
+		dst := &gateWriter{release: make(chan struct{})}
+		w, err := NewWriter(key, dst, params, WithBufferedChunks(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Each Write fills a chunk, which is sealed and handed on.
+		var accepted atomic.Int32
+		done := make(chan error)
+		go func() {
+			for range chunks {
+				_, err := w.Write(bytes.Repeat([]byte{'x'}, 64))
+				if err != nil {
+					done <- err
+					return
+				}
+				accepted.Add(1)
+			}
+			done <- w.Close()
+		}()
+
+		// With dst stalled, Write returns for the n chunks queued, while
+		// the goroutine of the queue holds the first one, so n+1 chunks
+		// are held besides the one being filled.
+		deadline := time.Now().Add(10 * time.Second)
+		for int(accepted.Load()) < n && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(20 * time.Millisecond)
+		if got := int(accepted.Load()); got != n {
+			t.Fatalf("%d chunks buffered: Write returned for %d chunks while dst stalled, want %d", n, got, n)
+		}
+		if w.queue != nil && (cap(w.queue.pending) != n || len(w.queue.free) != 0) {
+			t.Fatalf("%d chunks buffered: queue of %d chunks, %d free", n, cap(w.queue.pending), len(w.queue.free))
+		}
+
+		close(dst.release)
+		err = <-done
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewReader(key, &dst.buff, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || len(got) != chunks*64 {
+			t.Fatalf("%d chunks buffered: read %d bytes, %v", n, len(got), err)
+		}
+	}
+}
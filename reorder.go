@@ -0,0 +1,205 @@
+package encdec
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+)
+
+var ErrReorderWindow = errors.New("chunk outside the reorder window")
+
+// ReorderingReader decrypts the chunks of a stream written by Writer that
+// arrive out of order, such as over datagrams, each one along with its
+// index, and reads the plaintext back in order. Every chunk is decrypted
+// on its own, as its nonce is derived from its index, and held until the
+// chunks before it arrive, up to a window of chunks.
+//
+// Push and Read may be called from different goroutines, with Read
+// blocking until the next chunk in order has arrived.
+type ReorderingReader struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	cipher *chunkCipher
+	window uint64
+	digest hash.Hash
+	want   []byte
+
+	// chunks holds the plaintext of the chunks received and not yet
+	// read, from index base. They are in order up to next, the first
+	// chunk not received yet.
+	chunks  map[uint64][]byte
+	base    uint64
+	next    uint64
+	current []byte
+
+	// last is the index of the last chunk, once lastKnown.
+	last      uint64
+	lastKnown bool
+	err       error
+}
+
+// NewReorderingReader creates a ReorderingReader using a 256-bit key,
+// holding up to window chunks received but not yet read. Of opts, only
+// WithAAD and WithPolicy have an effect. It requires FormatV2 or later,
+// where the last chunk is authenticated, and params without Trailer,
+// Compress, Frames or Erasure, whose chunks depend on the ones before.
+func NewReorderingReader(key []byte, params *Params, window int, opts ...ReaderOption) (*ReorderingReader, error) {
+	if params == nil {
+		return nil, ErrNilParams
+	}
+	err := params.checkFormatted()
+	if err != nil {
+		return nil, err
+	}
+	err = params.checkStreamOnly()
+	if err != nil {
+		return nil, err
+	}
+	if params.Format < FormatV2 {
+		return nil, fmt.Errorf("%w: reordering requires format %d, not %d", ErrFormat, FormatV2, params.Format)
+	}
+	if window < 1 {
+		return nil, fmt.Errorf("%w: window of %d chunks", ErrReorderWindow, window)
+	}
+
+	config := newReaderConfig(opts)
+	err = config.checkPolicy(params)
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := newChunkCipher(key, params, config.aad)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ReorderingReader{
+		cipher: cipher,
+		window: uint64(window),
+		chunks: make(map[uint64][]byte),
+	}
+	r.cond.L = &r.mu
+	if len(params.Digest) != 0 {
+		r.digest = sha256.New()
+		r.want = params.Digest
+	}
+	return r, nil
+}
+
+// Push decrypts chunk, the ciphertext of the chunk at index, holding its
+// plaintext until it is read. Chunks already received are ignored, so
+// they can be delivered more than once. A chunk at or past the window of
+// the first chunk not read yet returns an error wrapping ErrReorderWindow,
+// and should be pushed again once more is read. A chunk failing
+// authentication returns an error, leaving r as it was, so a forged
+// chunk doesn't stop the stream.
+func (r *ReorderingReader) Push(index uint64, chunk []byte) error {
+	if r == nil || r.cipher == nil {
+		return ErrUninitialized
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+
+	_, held := r.chunks[index]
+	if index < r.next || held {
+		return nil
+	}
+	if index-r.base >= r.window {
+		return fmt.Errorf("%w: chunk %d, window from %d", ErrReorderWindow, index, r.base)
+	}
+	if r.lastKnown && index > r.last {
+		return fmt.Errorf("chunk %d is past the last chunk %d", index, r.last)
+	}
+	if len(chunk) > r.cipher.sealedSize {
+		return fmt.Errorf("chunk %d has %d bytes, more than %d", index, len(chunk), r.cipher.sealedSize)
+	}
+
+	// Every chunk is full except the last one.
+	last := len(chunk) < r.cipher.sealedSize
+	plaintext, err := r.cipher.openAt(nil, chunk, index, last)
+	if err != nil {
+		return err
+	}
+	if last {
+		r.last = index
+		r.lastKnown = true
+	}
+	r.chunks[index] = plaintext
+	for {
+		_, ok := r.chunks[r.next]
+		if !ok {
+			break
+		}
+		r.next++
+	}
+	r.cond.Broadcast()
+	return nil
+}
+
+// Read reads up to len(p) bytes of plaintext in order, blocking until the
+// next chunk has been pushed. It returns io.EOF once the last chunk is
+// read. If params have a Digest, it is checked once the last chunk is
+// pushed, and Read returns ErrDigestMismatch instead of its plaintext if
+// they don't match.
+func (r *ReorderingReader) Read(p []byte) (int, error) {
+	if r == nil || r.cipher == nil {
+		return 0, ErrUninitialized
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for len(r.current) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.lastKnown && r.base > r.last {
+			return 0, io.EOF
+		}
+		if r.base == r.next {
+			r.cond.Wait()
+			continue
+		}
+
+		r.current = r.chunks[r.base]
+		delete(r.chunks, r.base)
+		r.base++
+		if r.digest != nil {
+			r.digest.Write(r.current)
+			if r.lastKnown && r.base > r.last && !hmac.Equal(r.digest.Sum(nil), r.want) {
+				r.current = nil
+				r.err = ErrDigestMismatch
+			}
+		}
+	}
+
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+	return n, nil
+}
+
+// Close releases the ReorderingReader, making a Read blocked waiting for
+// a chunk return ErrClosed, along with any later call to Read or Push.
+func (r *ReorderingReader) Close() error {
+	if r == nil || r.cipher == nil {
+		return ErrUninitialized
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == nil {
+		r.err = ErrClosed
+	}
+	r.chunks = nil
+	r.current = nil
+	r.cond.Broadcast()
+	return nil
+}
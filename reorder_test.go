@@ -0,0 +1,114 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestReorderingReader(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	plaintext := make([]byte, 1000)
+	for i := range plaintext {
+		plaintext[i] = byte(i * 31)
+	}
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	var err error
+	params.Digest, err = Digest(bytes.NewReader(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := encryptWithKey(key, plaintext, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The chunks of 64+16 bytes, the last one shorter.
+	var chunks [][]byte
+	for ciphertext := blob[bytes.IndexByte(blob, '\n')+1:]; len(ciphertext) > 0; {
+		n := min(80, len(ciphertext))
+		chunks = append(chunks, ciphertext[:n])
+		ciphertext = ciphertext[n:]
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for trial := range 10 {
+		r, err := NewReorderingReader(key, params, len(chunks))
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan []byte)
+		go func() {
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Error(err)
+			}
+			done <- got
+		}()
+
+		// The chunks are pushed shuffled, some of them twice.
+		for _, i := range rng.Perm(len(chunks)) {
+			err := r.Push(uint64(i), chunks[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if trial%2 == 0 {
+				err = r.Push(uint64(i), chunks[i])
+				if err != nil {
+					t.Fatalf("chunk %d pushed twice: %v", i, err)
+				}
+			}
+		}
+		got := <-done
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("trial %d: read data doesn't match the plaintext", trial)
+		}
+	}
+
+	r, err := NewReorderingReader(key, params, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A forged chunk is refused, and the real one still accepted.
+	forged := bytes.Clone(chunks[1])
+	forged[0] ^= 1
+	err = r.Push(1, forged)
+	if err == nil {
+		t.Fatal("a forged chunk was accepted")
+	}
+	err = r.Push(1, chunks[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Push(4, chunks[4])
+	if !errors.Is(err, ErrReorderWindow) {
+		t.Fatalf("chunk past the window: got error %v, want ErrReorderWindow", err)
+	}
+
+	// Reading moves the window on, once the gap is filled.
+	err = r.Push(0, chunks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 64)
+	_, err = io.ReadFull(r, got)
+	if err != nil || !bytes.Equal(got, plaintext[:64]) {
+		t.Fatalf("read %q, %v", got, err)
+	}
+	err = r.Push(4, chunks[4])
+	if err != nil {
+		t.Fatalf("chunk in the moved window: %v", err)
+	}
+
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Read(make([]byte, 1))
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("Read after Close: got error %v, want ErrClosed", err)
+	}
+}
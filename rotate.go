@@ -0,0 +1,115 @@
+package encdec
+
+import (
+	"fmt"
+	"io"
+)
+
+// RotateSalt re-encrypts src, a header followed by its encrypted data,
+// into dst with the same password and params, except for a freshly
+// generated salt. So the data is encrypted with a new key without
+// changing the password.
+//
+// The data is streamed from src to dst, chunk by chunk, so it is never
+// held in memory as a whole. If an error is returned, dst may hold a
+// partial result and should be discarded.
+func RotateSalt(password []byte, src io.Reader, dst io.Writer) error {
+	err := recrypt(password, src, dst, func(*Params) {})
+	if err != nil {
+		return fmt.Errorf("rotating salt: %w", err)
+	}
+	return nil
+}
+
+// ChangeChunkSize works like RotateSalt, also changing the chunk size to
+// chunkSize. The chunks of src are decrypted and their plaintext split
+// again at the new boundaries as it is written, so at most a chunk of each
+// size is held in memory, whether chunkSize is larger or smaller than the
+// old one or not a multiple of it.
+//
+// A new salt is needed, as reusing the key with the new chunk boundaries
+// would encrypt different data under the same nonces. If src was encrypted
+// with a profile, the result isn't, as its params no longer match it.
+func ChangeChunkSize(password []byte, src io.Reader, dst io.Writer, chunkSize int64) error {
+	if chunkSize <= 0 {
+		return fmt.Errorf("%w: %d", ErrChunkSize, chunkSize)
+	}
+
+	err := recrypt(password, src, dst, func(p *Params) {
+		p.ChunkSize = chunkSize
+		p.Profile = ""
+	})
+	if err != nil {
+		return fmt.Errorf("changing chunk size: %w", err)
+	}
+	return nil
+}
+
+// ChangeCipher works like RotateSalt, also changing the cipher to
+// newCipher, such as to move files to AES256GCM where it is faster. The
+// key size follows the new cipher, and a new salt is used, like every
+// re-encryption does, so no key ever encrypts under two ciphers. If src
+// was encrypted with a profile, the result isn't, as its params no longer
+// match it.
+func ChangeCipher(password []byte, src io.Reader, dst io.Writer, newCipher string) error {
+	err := checkCipher(newCipher)
+	if err != nil {
+		return fmt.Errorf("changing cipher: %w", err)
+	}
+
+	err = recrypt(password, src, dst, func(p *Params) {
+		p.Cipher = newCipher
+		p.KeySize = 0
+		p.Profile = ""
+	})
+	if err != nil {
+		return fmt.Errorf("changing cipher: %w", err)
+	}
+	return nil
+}
+
+// recrypt re-encrypts src, a header followed by its encrypted data, into
+// dst with the same password, a fresh salt, and the params of src as
+// changed by change.
+func recrypt(password []byte, src io.Reader, dst io.Writer, change func(*Params)) error {
+	params, err := ParseHeader(src)
+	if err != nil {
+		return err
+	}
+	key, err := Key(password, params)
+	if err != nil {
+		return err
+	}
+	r, err := NewReader(key, src, params)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	newParams := *params
+	newParams.Salt = nil
+	change(&newParams)
+	newKey, err := Key(password, &newParams)
+	if err != nil {
+		return err
+	}
+	header, err := newParams.MarshalHeader()
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(header)
+	if err != nil {
+		return err
+	}
+
+	w, err := NewWriter(newKey, dst, &newParams)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err != nil {
+		return err
+	}
+
+	return w.Close()
+}
@@ -0,0 +1,156 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestRotateSalt(t *testing.T) {
+	password := []byte("password")
+	plaintext := bytes.Repeat([]byte{'x'}, 300)
+	params := testParams()
+	params.Label = "rotated"
+	blob, err := EncryptBytes(password, plaintext, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = RotateSalt(password, bytes.NewReader(blob), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := ParseHeader(bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := ParseHeader(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(old.Salt, rotated.Salt) {
+		t.Fatal("the salt wasn't rotated")
+	}
+	rotated.Salt = old.Salt
+	if !rotated.Equal(old) {
+		t.Fatalf("params changed from %v to %v", old, rotated)
+	}
+
+	got, err := DecryptBytes(password, out.Bytes())
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("decrypted %d bytes, %v", len(got), err)
+	}
+
+	err = RotateSalt([]byte("wrong"), bytes.NewReader(blob), &out)
+	if !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("wrong password: got error %v, want ErrWrongKey", err)
+	}
+}
+
+func TestChangeChunkSize(t *testing.T) {
+	password := []byte("password")
+	plaintext := make([]byte, 1000)
+	for i := range plaintext {
+		plaintext[i] = byte(i)
+	}
+	blob, err := EncryptBytes(password, plaintext, testParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Smaller, the same, a multiple, not a multiple, and larger than
+	// the whole plaintext.
+	for _, chunkSize := range []int64{24, 64, 128, 100, 4096} {
+		var out bytes.Buffer
+		err = ChangeChunkSize(password, bytes.NewReader(blob), &out, chunkSize)
+		if err != nil {
+			t.Fatalf("chunk size %d: %v", chunkSize, err)
+		}
+		params, err := ParseHeader(bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if params.ChunkSize != chunkSize {
+			t.Fatalf("header has chunk size %d, want %d", params.ChunkSize, chunkSize)
+		}
+
+		got, err := DecryptBytes(password, out.Bytes())
+		if err != nil {
+			t.Fatalf("chunk size %d: %v", chunkSize, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("chunk size %d: decrypted data doesn't match the plaintext", chunkSize)
+		}
+	}
+
+	err = ChangeChunkSize(password, bytes.NewReader(blob), io.Discard, 0)
+	if !errors.Is(err, ErrChunkSize) {
+		t.Fatalf("chunk size 0: got error %v, want ErrChunkSize", err)
+	}
+}
+
+func TestChangeCipher(t *testing.T) {
+	password := []byte("password")
+	plaintext := bytes.Repeat([]byte{'x'}, 300)
+
+	for _, tt := range []struct{ from, to string }{
+		{ChaCha20Poly1305, AES256GCM},
+		{AES256GCM, ChaCha20Poly1305},
+	} {
+		params := testParams()
+		params.Cipher = tt.from
+		blob, err := EncryptBytes(password, plaintext, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		err = ChangeCipher(password, bytes.NewReader(blob), &out, tt.to)
+		if err != nil {
+			t.Fatalf("%s to %s: %v", tt.from, tt.to, err)
+		}
+		old, err := ParseHeader(bytes.NewReader(blob))
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := bytes.NewReader(out.Bytes())
+		changed, err := ParseHeader(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed.Cipher != tt.to || bytes.Equal(changed.Salt, old.Salt) {
+			t.Fatalf("%s to %s: got params %v", tt.from, tt.to, changed)
+		}
+
+		got, err := DecryptBytes(password, out.Bytes())
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Fatalf("%s to %s: decrypted %d bytes, %v", tt.from, tt.to, len(got), err)
+		}
+
+		// The key of the old salt doesn't decrypt the data anymore.
+		oldKey, err := Key(password, old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewReader(oldKey, src, changed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadAll(r)
+		if !errors.Is(err, ErrWrongKey) {
+			t.Fatalf("%s to %s: old key got error %v, want ErrWrongKey", tt.from, tt.to, err)
+		}
+
+		err = ChangeCipher([]byte("wrong"), bytes.NewReader(blob), io.Discard, tt.to)
+		if !errors.Is(err, ErrWrongKey) {
+			t.Fatalf("%s to %s: wrong password got error %v, want ErrWrongKey", tt.from, tt.to, err)
+		}
+	}
+
+	err := ChangeCipher(password, bytes.NewReader(nil), io.Discard, "des")
+	if !errors.Is(err, ErrCipherMismatch) {
+		t.Fatalf("got error %v, want ErrCipherMismatch", err)
+	}
+}
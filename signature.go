@@ -0,0 +1,105 @@
+package encdec
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrSignature    = errors.New("invalid signature")
+	ErrBadSignature = errors.New("signature verification failed")
+)
+
+// errSignatureUnsupported is returned for params with a Signature by the
+// functions other than Writer and Reader.
+var errSignatureUnsupported = fmt.Errorf("%w: only supported by Writer and Reader", ErrSignature)
+
+// Sign signs the digest of the plaintext in p with privateKey, setting
+// the PublicKey and Signature fields, so Reader can check who encrypted
+// the data. The Digest field must already be set.
+func (p *Params) Sign(privateKey ed25519.PrivateKey) error {
+	if len(p.Digest) != DigestSize {
+		return fmt.Errorf("%w: the digest must be set before signing", ErrSignature)
+	}
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return fmt.Errorf("%w: private key length %d is not %d", ErrSignature, len(privateKey), ed25519.PrivateKeySize)
+	}
+
+	p.PublicKey = bytes.Clone(privateKey.Public().(ed25519.PublicKey))
+	p.Signature = ed25519.Sign(privateKey, signedMessage(p.Digest))
+	return nil
+}
+
+// signedMessage returns the message signed for digest, prefixed so the
+// signature can't be mistaken for one made for another purpose.
+func signedMessage(digest []byte) []byte {
+	return append([]byte("encdec signature\x00"), digest...)
+}
+
+// checkSignature checks the fields of a signature in p, if any.
+func (p *Params) checkSignature() error {
+	if len(p.PublicKey) == 0 && len(p.Signature) == 0 {
+		return nil
+	}
+	if len(p.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: public key length %d is not %d", ErrSignature, len(p.PublicKey), ed25519.PublicKeySize)
+	}
+	if len(p.Signature) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: length %d is not %d", ErrSignature, len(p.Signature), ed25519.SignatureSize)
+	}
+	if len(p.Digest) == 0 {
+		return fmt.Errorf("%w: requires a digest", ErrSignature)
+	}
+	if p.Format < FormatV3 {
+		return fmt.Errorf("%w: requires format %d, not %d", ErrSignature, FormatV3, p.Format)
+	}
+	return nil
+}
+
+// WithPublicKey makes the Reader require the data to be signed by the
+// private key of publicKey, returning ErrBadSignature after the last
+// chunk otherwise. Without it, a signature is checked against the public
+// key in the header, which only proves who encrypted the data if that
+// key is known to be theirs.
+func WithPublicKey(publicKey ed25519.PublicKey) ReaderOption {
+	publicKey = bytes.Clone(publicKey)
+	return readerOptionFunc(func(c *readerConfig) {
+		c.publicKey = publicKey
+	})
+}
+
+// signatureCheck checks the signature of a stream, once its digest
+// was checked.
+type signatureCheck struct {
+	publicKey ed25519.PublicKey
+	signature []byte
+	digest    []byte
+	want      ed25519.PublicKey
+}
+
+func newSignatureCheck(params *Params, config *readerConfig) *signatureCheck {
+	if len(params.Signature) == 0 && config.publicKey == nil {
+		return nil
+	}
+	return &signatureCheck{
+		publicKey: params.PublicKey,
+		signature: params.Signature,
+		digest:    params.Digest,
+		want:      config.publicKey,
+	}
+}
+
+func (s *signatureCheck) check() error {
+	if len(s.signature) == 0 {
+		return fmt.Errorf("%w: not signed", ErrBadSignature)
+	}
+	if s.want != nil && !bytes.Equal(s.want, s.publicKey) {
+		return fmt.Errorf("%w: signed by another key", ErrBadSignature)
+	}
+	if !ed25519.Verify(s.publicKey, signedMessage(s.digest), s.signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package encdec
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestReaderSignature(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	plaintext := bytes.Repeat([]byte{'x'}, 200)
+	digest, err := Digest(bytes.NewReader(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, otherPrivate, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// encrypt encrypts plaintext signed with privateKey, if not nil,
+	// letting tamper change the params before.
+	encrypt := func(privateKey ed25519.PrivateKey, tamper func(*Params)) []byte {
+		params := testParams()
+		params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+		params.Digest = digest
+		if privateKey != nil {
+			err := params.Sign(privateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tamper != nil {
+			tamper(params)
+		}
+		blob, err := encryptWithKey(key, plaintext, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return blob
+	}
+	// decrypt decrypts blob with a Reader created with opts.
+	decrypt := func(blob []byte, opts ...ReaderOption) error {
+		src := bytes.NewReader(blob)
+		params, err := ParseHeader(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewReader(key, src, params, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err == nil && !bytes.Equal(got, plaintext) {
+			t.Fatalf("got %d bytes of plaintext, want %d", len(got), len(plaintext))
+		}
+		return err
+	}
+
+	signed := encrypt(private, nil)
+	otherSigned := encrypt(otherPrivate, nil)
+	unsigned := encrypt(nil, nil)
+	badSignature := encrypt(private, func(p *Params) { p.Signature[0] ^= 1 })
+	// The public key in the header isn't the one that signed.
+	wrongKey := encrypt(otherPrivate, func(p *Params) { p.PublicKey = public })
+
+	for _, tt := range []struct {
+		name      string
+		blob      []byte
+		publicKey ed25519.PublicKey
+		err       error
+	}{
+		{"signed", signed, nil, nil},
+		{"signed", signed, public, nil},
+		{"signed by another key", otherSigned, nil, nil},
+		{"signed by another key", otherSigned, public, ErrBadSignature},
+		{"signed by another key", otherSigned, otherPublic, nil},
+		{"unsigned", unsigned, nil, nil},
+		{"unsigned", unsigned, public, ErrBadSignature},
+		{"bad signature", badSignature, nil, ErrBadSignature},
+		{"bad signature", badSignature, public, ErrBadSignature},
+		{"wrong public key in header", wrongKey, nil, ErrBadSignature},
+		{"wrong public key in header", wrongKey, public, ErrBadSignature},
+	} {
+		var opts []ReaderOption
+		if tt.publicKey != nil {
+			opts = append(opts, WithPublicKey(tt.publicKey))
+		}
+		err := decrypt(tt.blob, opts...)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("%s, WithPublicKey %v: got error %v, want %v", tt.name, tt.publicKey != nil, err, tt.err)
+		}
+	}
+
+	// The signature is authenticated along with the header, so one
+	// corrupted after encrypting fails before it is checked.
+	corrupted := bytes.Clone(signed)
+	header := bytes.IndexByte(corrupted, '\n')
+	sig := bytes.LastIndex(corrupted[:header], []byte("$sig=")) + len("$sig=")
+	if sig < len("$sig=") {
+		t.Fatal("no signature in the header")
+	}
+	if corrupted[sig] == 'A' {
+		corrupted[sig] = 'B'
+	} else {
+		corrupted[sig] = 'A'
+	}
+	err = decrypt(corrupted, WithPublicKey(public))
+	if !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("corrupted header: got error %v, want ErrWrongKey", err)
+	}
+}
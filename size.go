@@ -0,0 +1,80 @@
+package encdec
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrSize is returned for sizes that can't be parsed.
+var ErrSize = errors.New("invalid size")
+
+// sizeUnits are the suffixes accepted by ParseSize, with the number of
+// bytes they stand for. The binary ones go first, so "KiB" isn't taken
+// for "K" followed by garbage.
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"K", 1e3},
+	{"M", 1e6},
+	{"G", 1e9},
+	{"T", 1e12},
+	{"B", 1},
+}
+
+// ParseSize parses s as a number of bytes: a decimal integer, optionally
+// followed by a unit, K, M, G and T for powers of 1000 and KiB, MiB, GiB
+// and TiB for powers of 1024, such as "64KiB" for 65536 bytes, or B for
+// bytes. Units are case-sensitive and must follow the number without a
+// space, so a lowercase "m" or "1 MiB" are refused rather than guessed
+// at, as are fractions and negative sizes. The returned error wraps
+// ErrSize.
+func ParseSize(s string) (int64, error) {
+	number, unit := s, int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			number, unit = strings.TrimSuffix(s, u.suffix), u.bytes
+			break
+		}
+	}
+
+	// ParseInt would take a sign, which isn't part of a size.
+	if number == "" || number[0] < '0' || number[0] > '9' {
+		return 0, fmt.Errorf("%w: %q", ErrSize, s)
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrSize, s)
+	}
+	if n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("%w: %q overflows", ErrSize, s)
+	}
+	return n * unit, nil
+}
+
+// ParseChunkSize works like ParseSize, also checking that the size is
+// a valid chunk size: not zero, returning ErrChunkSize, and not so large
+// that a chunk can't be held in memory, returning ErrChunkSizeTooLarge.
+// Params may still refuse it with options lowering the limit, such as
+// Compress.
+func ParseChunkSize(s string) (int64, error) {
+	n, err := ParseSize(s)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%w: %d", ErrChunkSize, n)
+	}
+	_, _, err = chunkSizes(n, tagSize)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
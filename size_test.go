@@ -0,0 +1,61 @@
+package encdec
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{
+		"0":     0,
+		"42":    42,
+		"42B":   42,
+		"007K":  7000,
+		"1K":    1000,
+		"1M":    1e6,
+		"2G":    2e9,
+		"3T":    3e12,
+		"1KiB":  1 << 10,
+		"64KiB": 64 << 10,
+		"1MiB":  1 << 20,
+		"1GiB":  1 << 30,
+		"2TiB":  2 << 40,
+	} {
+		got, err := ParseSize(s)
+		if err != nil || got != want {
+			t.Fatalf("%q: got %d, %v, want %d", s, got, err, want)
+		}
+	}
+
+	// Anything ambiguous is refused rather than guessed at.
+	for _, s := range []string{
+		"", "B", "K", "KiB",
+		"-1", "+1", " 1", "1 MiB", "0x10", "1e3", "1.5M",
+		"1m", "1k", "1kib", "1KB", "1MB", "1Ki", "1iB", "1BB", "1KiBB",
+		"9223372036854775807K", "99999999999999999999",
+	} {
+		_, err := ParseSize(s)
+		if !errors.Is(err, ErrSize) {
+			t.Fatalf("%q: got error %v, want ErrSize", s, err)
+		}
+	}
+}
+
+func TestParseChunkSize(t *testing.T) {
+	n, err := ParseChunkSize("1MiB")
+	if err != nil || n != 1<<20 {
+		t.Fatalf("1MiB: got %d, %v", n, err)
+	}
+	_, err = ParseChunkSize("0")
+	if !errors.Is(err, ErrChunkSize) {
+		t.Fatalf("0: got error %v, want ErrChunkSize", err)
+	}
+	_, err = ParseChunkSize("9223372036854775807")
+	if !errors.Is(err, ErrChunkSizeTooLarge) {
+		t.Fatalf("9223372036854775807: got error %v, want ErrChunkSizeTooLarge", err)
+	}
+	_, err = ParseChunkSize("1MB")
+	if !errors.Is(err, ErrSize) {
+		t.Fatalf("1MB: got error %v, want ErrSize", err)
+	}
+}
@@ -2,25 +2,50 @@ package encdec
 
 import (
 	"bytes"
-	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
+)
 
-	"golang.org/x/crypto/chacha20poly1305"
+var (
+	ErrLimitExceeded = errors.New("ciphertext limit exceeded")
+	ErrUninitialized = errors.New("not created by NewWriter or NewReader")
+	ErrClosed        = errors.New("operation on closed writer or reader")
 )
 
 // Writer writes to underlying writer encrypting the data.
 type Writer struct {
-	aead      cipher.AEAD
-	chunkSize int64
-	dst       io.Writer
-	nonce     [chacha20poly1305.NonceSize]byte
-	buff      bytes.Buffer
-	err       error
+	cipher     *chunkCipher
+	chunkSize  int64
+	dst        io.Writer
+	underlying io.Writer
+	queue      *chunkQueue
+	buff       bytes.Buffer
+	pooled     []byte
+	trailer    *trailerHash
+	config     *writerConfig
+	err        error
+
+	// frames is only set with Params.Frames or Params.Compress,
+	// and compressor only with Params.Compress.
+	frames     *frameWriter
+	compressor *chunkCompressor
+
+	// erasure is only set with Params.Erasure.
+	erasure *erasureWriter
+
+	// plaintextOffset and ciphertextOffset are where the next chunk
+	// starts, for WithChunkOffsets.
+	plaintextOffset  int64
+	ciphertextOffset int64
 }
 
 // NewWriter creates a new Writer using a 256-bit key.
-func NewWriter(key []byte, dst io.Writer, params *Params) (*Writer, error) {
+// The behavior of the Writer can be customized with opts.
+func NewWriter(key []byte, dst io.Writer, params *Params, opts ...WriterOption) (*Writer, error) {
 	if params == nil {
 		return nil, ErrNilParams
 	}
@@ -29,28 +54,87 @@ func NewWriter(key []byte, dst io.Writer, params *Params) (*Writer, error) {
 		return nil, err
 	}
 
-	aead, err := chacha20poly1305.New(key)
+	config := newWriterConfig(opts)
+	if config.blockSize > 0 && config.chunkLog != nil {
+		return nil, errors.New("block size can't be combined with a chunk log")
+	}
+	if config.bufferedChunks > 0 && config.chunkLog != nil {
+		return nil, errors.New("buffered chunks can't be combined with a chunk log")
+	}
+	cipher, err := newChunkCipher(key, params, config.aad)
 	if err != nil {
 		return nil, err
 	}
 	w := &Writer{
-		aead:      aead,
-		dst:       dst,
-		chunkSize: params.ChunkSize,
+		cipher:     cipher,
+		dst:        dst,
+		underlying: dst,
+		chunkSize:  params.ChunkSize,
+		config:     config,
+	}
+	if config.blockSize > 0 {
+		w.dst = newBlockWriter(dst, config.blockSize)
+	}
+	if params.Erasure != (Erasure{}) {
+		w.erasure, err = newErasureWriter(params.Erasure, cipher.sealedSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.bufferedChunks > 0 {
+		w.queue = newChunkQueue(w.dst, config.bufferedChunks, cipher.sealedSize)
+		w.dst = w.queue
+	}
+	w.pooled = getChunkBuffer(cipher.sealedSize)
+	w.buff = *bytes.NewBuffer(w.pooled)
+	if params.Trailer {
+		w.trailer = newTrailerHash()
+	}
+	if params.framed() {
+		w.frames = &frameWriter{cipher: cipher}
+	}
+	if params.Compress {
+		w.compressor = newChunkCompressor()
 	}
-	w.buff.Grow(int(w.chunkSize + chacha20poly1305.Overhead))
 	return w, nil
 }
 
-func (w *Writer) flush() error {
-	ciphertext := w.aead.Seal(w.buff.Bytes()[:0], w.nonce[:], w.buff.Bytes(), nil)
-	_, err := w.dst.Write(ciphertext)
+func (w *Writer) flush(last bool) error {
+	index := w.cipher.index
+	length := w.buff.Len()
+	var ciphertext []byte
+	var err error
+	if w.frames != nil {
+		ciphertext, err = w.sealFrame(last)
+	} else {
+		ciphertext, err = w.cipher.seal(w.buff.Bytes()[:0], w.buff.Bytes(), last)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.dst.Write(ciphertext)
+	if err != nil {
+		return fmt.Errorf("writing ciphertext chunk %d: %w", index, err)
+	}
+	err = w.reportChunk(index, length, len(ciphertext), last)
 	if err != nil {
 		return err
 	}
+	if w.erasure != nil {
+		err = w.writeParity(ciphertext, last)
+		if err != nil {
+			return err
+		}
+	}
+	if w.config.chunkLog != nil {
+		err = w.logChunk(index, ciphertext, last)
+		if err != nil {
+			return err
+		}
+	}
+	w.config.logf("encdec: wrote chunk of %d bytes", len(ciphertext))
 	w.buff.Reset()
-	err = incNonce(w.nonce[:])
-	return err
+	return nil
 }
 
 // Write writes len(p) bytes from p to the buffer.
@@ -59,17 +143,28 @@ func (w *Writer) flush() error {
 // It returns the number of bytes written to the buffer and an error,
 // if any.
 func (w *Writer) Write(p []byte) (int, error) {
+	if w == nil || w.cipher == nil {
+		return 0, ErrUninitialized
+	}
 	if w.err != nil {
 		return 0, w.err
 	}
 
+	if w.trailer != nil {
+		w.trailer.Write(p)
+	}
+	return w.write(p)
+}
+
+// write buffers p, flushing every chunk filled as it goes.
+func (w *Writer) write(p []byte) (int, error) {
 	total := len(p)
 	for len(p) > 0 {
 		size := min(int(w.chunkSize)-w.buff.Len(), len(p))
 		n, _ := w.buff.Write(p[:size])
 		p = p[n:]
 		if w.buff.Len() == int(w.chunkSize) {
-			err := w.flush()
+			err := w.flush(false)
 			if err != nil {
 				w.err = err
 				return 0, w.err
@@ -80,34 +175,106 @@ func (w *Writer) Write(p []byte) (int, error) {
 }
 
 // Close encrypt and write any remaning data in the buffer plus the AEAD tag,
-// to the underlying writer. Close returns an error if it has already been called.
+// to the underlying writer. Once closed, Close, Write and FlushChunk return
+// ErrClosed.
+//
+// If the underlying writer has a Flush() error method, such as a
+// bufio.Writer, it is called, so every byte has left it once Close returns.
+// If the Writer was created with WithCloseUnderlying, the underlying writer
+// is also closed.
 func (w *Writer) Close() error {
+	if w == nil || w.cipher == nil {
+		return ErrUninitialized
+	}
 	if w.err != nil {
+		w.release()
 		return w.err
 	}
 
-	w.err = w.flush()
+	if w.trailer != nil {
+		_, w.err = w.write(w.trailer.marshal())
+		if w.err != nil {
+			w.release()
+			return w.err
+		}
+	}
+	w.err = w.flush(true)
+	w.release()
 	if w.err != nil {
 		return w.err
 	}
+	flusher, ok := w.dst.(interface{ Flush() error })
+	if ok {
+		w.err = flusher.Flush()
+		if w.err != nil {
+			return w.err
+		}
+	}
 
-	w.err = errors.New("operation on closed writer")
-	return nil
+	w.err = ErrClosed
+	return closeUnderlying(w.underlying, &w.config.config)
+}
+
+// release returns the buffer of w to its pool, and stops its queue.
+func (w *Writer) release() {
+	if w.queue != nil {
+		w.queue.close()
+	}
+	putChunkBuffer(w.pooled)
+	w.pooled = nil
+	w.buff = bytes.Buffer{}
+}
+
+func closeUnderlying(v any, c *config) error {
+	if !c.closeUnderlying {
+		return nil
+	}
+	closer, ok := v.(io.Closer)
+	if !ok {
+		return nil
+	}
+	return closer.Close()
 }
 
 // Reader reads encrypted data from the underlying reader.
 type Reader struct {
-	aead      cipher.AEAD
-	chunkSize int
-	src       io.Reader
-	nonce     [chacha20poly1305.NonceSize]byte
-	buff      bytes.Buffer
-	lastChunk bool
-	err       error
+	cipher     *chunkCipher
+	chunkSize  int
+	src        io.Reader
+	underlying io.Reader
+	buff       bytes.Buffer
+	pooled     []byte
+	digest     hash.Hash
+	want       []byte
+	signature  *signatureCheck
+	trailer    *trailerReader
+	lastChunk  bool
+	config     *readerConfig
+	err        error
+
+	// frames is only set with Params.Frames or Params.Compress,
+	// and decompressor only with Params.Compress.
+	frames       *frameReader
+	decompressor *chunkDecompressor
+
+	// erasure is only set with Params.Erasure.
+	erasure *erasureReader
+
+	// held and rest are only used with WithTrailingData, holding the
+	// ciphertext of the chunk being decrypted and the data read past
+	// the last chunk.
+	held []byte
+	rest []byte
 }
 
 // NewReader creates a new Reader using a 256-bit key.
-func NewReader(key []byte, src io.Reader, params *Params) (*Reader, error) {
+// The behavior of the Reader can be customized with opts.
+//
+// If params have a Digest, the digest of the plaintext is checked once the
+// last chunk is decrypted, and Read returns ErrDigestMismatch instead of
+// the plaintext of the last chunk if they don't match. A Signature is then
+// checked as well, returning ErrBadSignature if it is wrong.
+func NewReader(key []byte, src io.Reader, params *Params, opts ...ReaderOption) (*Reader, error) {
 	if params == nil {
 		return nil, ErrNilParams
 	}
@@ -116,54 +283,137 @@ func NewReader(key []byte, src io.Reader, params *Params) (*Reader, error) {
 		return nil, err
 	}
 
-	aead, err := chacha20poly1305.New(key)
+	config := newReaderConfig(opts)
+	err = config.checkPolicy(params)
+	if err != nil {
+		return nil, err
+	}
+	err = config.checkTrailingData(params)
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := newChunkCipher(key, params, config.aad)
 	if err != nil {
 		return nil, err
 	}
 
 	r := &Reader{
-		aead:      aead,
-		src:       src,
-		chunkSize: int(params.ChunkSize),
+		cipher:     cipher,
+		src:        src,
+		underlying: src,
+		chunkSize:  cipher.chunkSize,
+		config:     config,
+	}
+	if config.maxCiphertext > 0 {
+		r.src = &limitedReader{src: src, n: config.maxCiphertext}
+	}
+	r.pooled = getChunkBuffer(cipher.sealedSize)
+	if len(params.Digest) != 0 {
+		r.digest = sha256.New()
+		r.want = params.Digest
+	}
+	r.signature = newSignatureCheck(params, config)
+	if params.Trailer {
+		r.trailer = newTrailerReader()
+	}
+	if params.framed() {
+		r.frames = &frameReader{
+			cipher:     cipher,
+			src:        r.src,
+			chunkSize:  cipher.chunkSize,
+			compressed: params.Compress,
+		}
+	}
+	if params.Compress {
+		r.decompressor = newChunkDecompressor()
+	}
+	if params.Erasure != (Erasure{}) {
+		r.erasure, err = newErasureReader(cipher, r.src, params.Erasure, config.maxErasure)
+		if err != nil {
+			return nil, err
+		}
 	}
-	r.buff.Grow(r.chunkSize + chacha20poly1305.Overhead)
 	return r, nil
 }
 
 // readChunk reads the next chunk from src and decrypt it.
 // Returns true if it is the last chunk.
 func (r *Reader) readChunk() (bool, error) {
+	var plaintext []byte
 	var last bool
-	r.buff.Reset()
-	n, err := io.CopyN(&r.buff, r.src, int64(r.chunkSize)+chacha20poly1305.Overhead)
+	var err error
+	switch {
+	case r.frames != nil:
+		plaintext, last, err = r.readFrame()
+	case r.erasure != nil:
+		plaintext, last, err = r.readErasure()
+	default:
+		plaintext, last, err = r.openChunk()
+	}
 	if err != nil {
-		if err != io.EOF {
+		return false, err
+	}
+
+	if r.trailer != nil {
+		plaintext, err = r.trailer.next(plaintext, plaintext, last)
+		if err != nil {
 			return false, err
 		}
-		last = true
 	}
+	if r.digest != nil {
+		r.digest.Write(plaintext)
+		if last && !hmac.Equal(r.digest.Sum(nil), r.want) {
+			return false, ErrDigestMismatch
+		}
+	}
+	if last && r.signature != nil {
+		err = r.signature.check()
+		if err != nil {
+			return false, err
+		}
+	}
+	r.buff = *bytes.NewBuffer(plaintext)
+	return last, nil
+}
 
-	if n < (int64(r.chunkSize) + chacha20poly1305.Overhead) {
+// openChunk reads the next chunk into the pooled buffer of r and decrypts
+// it in place, returning its plaintext. The chunk is read into the buffer
+// itself, rather than through a bytes.Buffer, which would grow into a new
+// one once full, leaving the plaintext in memory that is never cleared.
+func (r *Reader) openChunk() ([]byte, bool, error) {
+	var last bool
+	chunk := r.pooled[:r.cipher.sealedSize]
+	n, err := io.ReadFull(r.src, chunk)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
 		last = true
+	} else if err != nil {
+		return nil, false, err
 	}
+	chunk = chunk[:n]
 
-	plaintext, err := r.aead.Open(r.buff.Bytes()[:0], r.nonce[:], r.buff.Bytes(), nil)
-	if err != nil {
-		return false, err
+	// Decrypting in place destroys the ciphertext if it fails, which is
+	// kept to look for the last chunk among trailing data.
+	if r.config.trailingData {
+		r.held = append(r.held[:0], chunk...)
 	}
-	r.buff.Truncate(len(plaintext))
-
-	err = incNonce(r.nonce[:])
+	plaintext, err := r.cipher.open(chunk[:0], chunk, last)
 	if err != nil {
-		return false, err
+		if r.config.trailingData {
+			return r.findLastChunk(err, last)
+		}
+		return nil, false, err
 	}
-	return last, nil
+	r.config.logf("encdec: read chunk of %d bytes", n)
+	return plaintext, last, nil
 }
 
 // Read up to len(p) bytes, decrypting they and storing them in p.
 // It returns the number of bytes read and any error encountered.
 // At the end of file, Read returns 0 and io.EOF.
 func (r *Reader) Read(p []byte) (int, error) {
+	if r == nil || r.cipher == nil {
+		return 0, ErrUninitialized
+	}
 	if r.err != nil {
 		return 0, r.err
 	}
@@ -172,12 +422,17 @@ func (r *Reader) Read(p []byte) (int, error) {
 	for len(p) > 0 {
 		if r.buff.Len() == 0 {
 			if r.lastChunk {
-				r.err = io.EOF
+				r.err = r.endOfStream()
 				if total == 0 {
 					return 0, r.err
 				}
 				return total, nil
 			}
+			// Frames may be flushed before they are full, so what was
+			// read is returned rather than waiting for the next one.
+			if r.frames != nil && total > 0 {
+				return total, nil
+			}
 
 			last, err := r.readChunk()
 			if err != nil {
@@ -194,3 +449,67 @@ func (r *Reader) Read(p []byte) (int, error) {
 
 	return total, nil
 }
+
+// Close releases the Reader. Subsequent calls to Read and Close return
+// ErrClosed.
+//
+// If the Reader was created with WithCloseUnderlying, the underlying reader
+// is also closed.
+func (r *Reader) Close() error {
+	if r == nil || r.cipher == nil {
+		return ErrUninitialized
+	}
+	r.release()
+	if r.err != nil && r.err != io.EOF && r.err != ErrTrailingData {
+		return r.err
+	}
+
+	r.err = ErrClosed
+	return closeUnderlying(r.underlying, &r.config.config)
+}
+
+// release returns the buffer of r to its pool.
+func (r *Reader) release() {
+	putChunkBuffer(r.pooled)
+	r.pooled = nil
+	r.buff = bytes.Buffer{}
+}
+
+// Trailer returns the Trailer of the encrypted data, once Read has returned
+// io.EOF, or nil otherwise or if its params have no Trailer.
+func (r *Reader) Trailer() *Trailer {
+	if r == nil || r.trailer == nil {
+		return nil
+	}
+	return r.trailer.trailer
+}
+
+// limitedReader reads up to n bytes from src, returning ErrLimitExceeded
+// if src holds more.
+type limitedReader struct {
+	src io.Reader
+	n   int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if l.n <= 0 {
+		// src may end right at the limit, which is only
+		// known by reading past it.
+		var b [1]byte
+		n, err := io.ReadFull(l.src, b[:])
+		if n > 0 {
+			return 0, ErrLimitExceeded
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.src.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
@@ -0,0 +1,421 @@
+package encdec
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGoldenFiles decrypts files written by earlier versions in every
+// format, so changes that break reading them are caught.
+func TestGoldenFiles(t *testing.T) {
+	plaintext, err := os.ReadFile(filepath.Join("testdata", "plaintext.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for format, name := range []string{"v1", "v2", "v3", "v4"} {
+		blob, err := os.ReadFile(filepath.Join("testdata", name+".enc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		params, err := ParseHeader(bytes.NewReader(blob))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if params.Format != uint8(format+1) {
+			t.Fatalf("%s: format %d, want %d", name, params.Format, format+1)
+		}
+
+		got, err := DecryptBytes([]byte("password"), blob)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("%s: decrypted %q, want %q", name, got, plaintext)
+		}
+
+		_, err = DecryptBytes([]byte("wrong"), blob)
+		if !errors.Is(err, ErrWrongKey) {
+			t.Fatalf("%s: wrong password: got error %v, want ErrWrongKey", name, err)
+		}
+	}
+}
+
+func TestDecryptSignature(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := testParams()
+	digest := sha256.Sum256([]byte("data"))
+	params.Digest = digest[:]
+	err = params.Sign(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := EncryptBytes([]byte("password"), []byte("data"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := bytes.NewReader(blob)
+	params, err = ParseHeader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := Key([]byte("password"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	err = Decrypt(key, src, &out, params)
+	if !errors.Is(err, ErrSignature) {
+		t.Fatalf("got error %v, want ErrSignature", err)
+	}
+
+	// Reader checks the signature instead.
+	got, err := DecryptBytes([]byte("password"), blob)
+	if err != nil || string(got) != "data" {
+		t.Fatalf("DecryptBytes returned %q, %v", got, err)
+	}
+}
+
+// encryptWithKey returns plaintext encrypted by a Writer with key and
+// params, preceded by the header of params.
+func encryptWithKey(key []byte, plaintext []byte, params *Params) ([]byte, error) {
+	header, err := params.MarshalHeader()
+	if err != nil {
+		return nil, err
+	}
+	out := bytes.NewBuffer(header)
+	w, err := NewWriter(key, out, params)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// decryptWithKey decrypts blob, preceded by its header, with key.
+func decryptWithKey(key []byte, blob []byte) ([]byte, error) {
+	src := bytes.NewReader(blob)
+	params, err := ParseHeader(src)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(key, src, params)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestMaxCiphertext(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	plaintext := bytes.Repeat([]byte{'x'}, 200)
+	blob, err := encryptWithKey(key, plaintext, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := blob[bytes.IndexByte(blob, '\n')+1:]
+	size := int64(len(ciphertext))
+
+	// Chunks of 64+16 bytes: a limit of 160 allows exactly two.
+	for _, tt := range []struct {
+		limit int64
+		read  int
+		err   error
+	}{
+		{size, 200, io.EOF},
+		{size + 1, 200, io.EOF},
+		{size - 1, 192, ErrLimitExceeded},
+		{160, 128, ErrLimitExceeded},
+		{159, 64, ErrLimitExceeded},
+	} {
+		r, err := NewReader(key, bytes.NewReader(ciphertext), params, WithMaxCiphertext(tt.limit))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// One chunk is read at a time, so the chunks before the limit
+		// are returned before its error.
+		var got []byte
+		buff := make([]byte, 64)
+		for {
+			var n int
+			n, err = r.Read(buff)
+			got = append(got, buff[:n]...)
+			if err != nil {
+				break
+			}
+		}
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("limit %d: got error %v, want %v", tt.limit, err, tt.err)
+		}
+		if !bytes.Equal(got, plaintext[:tt.read]) {
+			t.Fatalf("limit %d: read %d bytes, want %d", tt.limit, len(got), tt.read)
+		}
+	}
+}
+
+// failingWriter accepts n bytes, failing with err from then on.
+type failingWriter struct {
+	n   int
+	err error
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, w.err
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriterWriteError(t *testing.T) {
+	errFull := errors.New("disk full")
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+
+	// Chunks take 64+16 bytes, so the third one is cut short.
+	w, err := NewWriter(key, &failingWriter{n: 200, err: errFull}, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(bytes.Repeat([]byte{'x'}, 1000))
+	if !errors.Is(err, errFull) {
+		t.Fatalf("got error %v, want %v", err, errFull)
+	}
+	if !strings.Contains(err.Error(), "writing ciphertext chunk 2") {
+		t.Fatalf("error %q doesn't name the chunk", err)
+	}
+	_, err2 := w.Write([]byte("more"))
+	if err2 != err {
+		t.Fatalf("Write after the error returned %v, want %v", err2, err)
+	}
+	err = w.Close()
+	if !errors.Is(err, errFull) {
+		t.Fatalf("Close returned %v, want %v", err, errFull)
+	}
+}
+
+// closeRecorder records whether it was closed.
+type closeRecorder struct {
+	io.ReadWriter
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestLoggerAndCloseUnderlying(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	plaintext := bytes.Repeat([]byte{'x'}, 100)
+
+	for _, closeUnderlying := range []bool{false, true} {
+		var logged bytes.Buffer
+		writerOpts := []WriterOption{WithLogger(log.New(&logged, "", 0))}
+		readerOpts := []ReaderOption{WithLogger(log.New(&logged, "", 0))}
+		if closeUnderlying {
+			writerOpts = append(writerOpts, WithCloseUnderlying())
+			readerOpts = append(readerOpts, WithCloseUnderlying())
+		}
+
+		dst := &closeRecorder{ReadWriter: new(bytes.Buffer)}
+		w, err := NewWriter(key, dst, params, writerOpts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(plaintext)
+		err = w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dst.closed != closeUnderlying {
+			t.Fatalf("WithCloseUnderlying %v: Writer closed dst: %v", closeUnderlying, dst.closed)
+		}
+
+		src := &closeRecorder{ReadWriter: dst.ReadWriter}
+		r, err := NewReader(key, src, params, readerOpts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if src.closed != closeUnderlying {
+			t.Fatalf("WithCloseUnderlying %v: Reader closed src: %v", closeUnderlying, src.closed)
+		}
+
+		// Both the Writer and the Reader log each of the two chunks.
+		want := "encdec: wrote chunk of 80 bytes\n" +
+			"encdec: wrote chunk of 52 bytes\n" +
+			"encdec: read chunk of 80 bytes\n" +
+			"encdec: read chunk of 52 bytes\n"
+		if logged.String() != want {
+			t.Fatalf("logged %q, want %q", logged.String(), want)
+		}
+	}
+}
+
+func TestWriterCloseFlushes(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	plaintext := bytes.Repeat([]byte{'x'}, 200)
+
+	var out bytes.Buffer
+	buffered := bufio.NewWriterSize(&out, 4096)
+	w, err := NewWriter(key, buffered, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plaintext)
+	if out.Len() != 0 || buffered.Buffered() == 0 {
+		t.Fatalf("%d bytes written through the bufio.Writer before Close", out.Len())
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buffered.Buffered() != 0 {
+		t.Fatalf("%d bytes left in the bufio.Writer after Close", buffered.Buffered())
+	}
+
+	r, err := NewReader(key, &out, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("read %d bytes, %v", len(got), err)
+	}
+
+	// An error flushing dst is returned by Close.
+	errFull := errors.New("disk full")
+	w, err = NewWriter(key, bufio.NewWriterSize(&failingWriter{n: 100, err: errFull}, 4096), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plaintext)
+	err = w.Close()
+	if !errors.Is(err, errFull) {
+		t.Fatalf("got error %v, want %v", err, errFull)
+	}
+}
+
+func TestUninitialized(t *testing.T) {
+	for name, w := range map[string]*Writer{"zero": {}, "nil": nil} {
+		_, err := w.Write([]byte("data"))
+		if !errors.Is(err, ErrUninitialized) {
+			t.Fatalf("Write on a %s Writer: got error %v, want ErrUninitialized", name, err)
+		}
+		err = w.FlushChunk()
+		if !errors.Is(err, ErrUninitialized) {
+			t.Fatalf("FlushChunk on a %s Writer: got error %v, want ErrUninitialized", name, err)
+		}
+		err = w.Close()
+		if !errors.Is(err, ErrUninitialized) {
+			t.Fatalf("Close on a %s Writer: got error %v, want ErrUninitialized", name, err)
+		}
+	}
+
+	for name, r := range map[string]*Reader{"zero": {}, "nil": nil} {
+		_, err := r.Read(make([]byte, 10))
+		if !errors.Is(err, ErrUninitialized) {
+			t.Fatalf("Read on a %s Reader: got error %v, want ErrUninitialized", name, err)
+		}
+		err = r.Close()
+		if !errors.Is(err, ErrUninitialized) {
+			t.Fatalf("Close on a %s Reader: got error %v, want ErrUninitialized", name, err)
+		}
+		if r.Trailer() != nil || r.Rest() != nil {
+			t.Fatalf("%s Reader: got a trailer or the rest of its data", name)
+		}
+	}
+}
+
+func TestClosed(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+
+	var out bytes.Buffer
+	w, err := NewWriter(key, &out, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("data"))
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := out.Len()
+	n, err := w.Write([]byte("more"))
+	if n != 0 || !errors.Is(err, ErrClosed) {
+		t.Fatalf("Write after Close returned %d, %v, want ErrClosed", n, err)
+	}
+	err = w.FlushChunk()
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("FlushChunk after Close returned %v, want ErrClosed", err)
+	}
+	err = w.Close()
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("second Close returned %v, want ErrClosed", err)
+	}
+	if out.Len() != written {
+		t.Fatalf("%d bytes written after Close", out.Len()-written)
+	}
+
+	r, err := NewReader(key, &out, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	_, err = r.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The Reader can be closed before reading everything.
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = r.Read(buf)
+	if n != 0 || !errors.Is(err, ErrClosed) {
+		t.Fatalf("Read after Close returned %d, %v, want ErrClosed", n, err)
+	}
+	err = r.Close()
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("second Close returned %v, want ErrClosed", err)
+	}
+}
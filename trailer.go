@@ -0,0 +1,93 @@
+package encdec
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// trailerSize is the length of a trailer: the length of the plaintext
+// followed by its SHA-256 digest.
+const trailerSize = 8 + sha256.Size
+
+var ErrTrailer = errors.New("invalid trailer")
+
+// errTrailerUnsupported is returned for params with a Trailer by the
+// functions other than Writer and Reader.
+var errTrailerUnsupported = fmt.Errorf("%w: only supported by Writer and Reader", ErrTrailer)
+
+// Trailer holds what is only known once all the plaintext was written,
+// stored at the end of the encrypted data when Params.Trailer is set.
+type Trailer struct {
+	// Length is the length of the plaintext.
+	Length int64
+
+	// Digest is the SHA-256 digest of the plaintext.
+	Digest []byte
+}
+
+// trailerHash tracks the length and digest of the plaintext written to it.
+type trailerHash struct {
+	length int64
+	hash   hash.Hash
+}
+
+func newTrailerHash() *trailerHash {
+	return &trailerHash{hash: sha256.New()}
+}
+
+func (t *trailerHash) Write(p []byte) (int, error) {
+	t.length += int64(len(p))
+	return t.hash.Write(p)
+}
+
+func (t *trailerHash) marshal() []byte {
+	b := binary.BigEndian.AppendUint64(nil, uint64(t.length))
+	return t.hash.Sum(b)
+}
+
+// trailerReader holds back the last trailerSize bytes of the plaintext
+// read so far, which are the trailer if no more follows, until the last
+// chunk is read.
+type trailerReader struct {
+	held    []byte
+	sum     *trailerHash
+	trailer *Trailer
+}
+
+func newTrailerReader() *trailerReader {
+	return &trailerReader{sum: newTrailerHash()}
+}
+
+// next takes the plaintext of the next chunk, returning what comes before
+// the trailer, appended to dst. Once the last chunk is given, the trailer
+// is checked against the plaintext returned.
+func (t *trailerReader) next(dst []byte, plaintext []byte, last bool) ([]byte, error) {
+	t.held = append(t.held, plaintext...)
+	n := len(t.held) - trailerSize
+	if n < 0 {
+		if last {
+			return nil, fmt.Errorf("%w: missing", ErrTrailer)
+		}
+		return dst[:0], nil
+	}
+
+	dst = append(dst[:0], t.held[:n]...)
+	t.held = append(t.held[:0], t.held[n:]...)
+	t.sum.Write(dst)
+	if !last {
+		return dst, nil
+	}
+
+	if !hmac.Equal(t.held, t.sum.marshal()) {
+		return nil, fmt.Errorf("%w: doesn't match the plaintext", ErrTrailer)
+	}
+	t.trailer = &Trailer{
+		Length: t.sum.length,
+		Digest: t.sum.hash.Sum(nil),
+	}
+	return dst, nil
+}
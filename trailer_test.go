@@ -0,0 +1,148 @@
+package encdec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+)
+
+// pipeIn returns a reader of b written through an io.Pipe, n bytes at a
+// time, so its length isn't known until it ends.
+func pipeIn(b []byte, n int) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		for len(b) > 0 {
+			m := min(n, len(b))
+			_, err := pw.Write(b[:m])
+			if err != nil {
+				return
+			}
+			b = b[m:]
+		}
+		pw.Close()
+	}()
+	return pr
+}
+
+func TestTrailer(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	params.Trailer = true
+	header, err := params.MarshalHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// With chunks of 64 bytes, the trailer of 40 bytes is split between
+	// the last two chunks for plaintexts of 25 to 63 bytes.
+	for _, n := range []int{0, 10, trailerSize - 1, 30, 64, 100, 1000} {
+		plaintext := make([]byte, n)
+		for i := range plaintext {
+			plaintext[i] = byte(i)
+		}
+
+		var out bytes.Buffer
+		w, err := NewWriter(key, &out, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.Copy(w, pipeIn(plaintext, 7))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+
+		src := pipeIn(append(bytes.Clone(header), out.Bytes()...), 13)
+		readParams, err := ParseHeader(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewReader(key, src, readParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Trailer() != nil {
+			t.Fatalf("%d bytes: got a trailer before reading", n)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("%d bytes: got %d bytes of plaintext, want %d", n, len(got), n)
+		}
+		digest := sha256.Sum256(plaintext)
+		trailer := r.Trailer()
+		if trailer == nil || trailer.Length != int64(n) || !bytes.Equal(trailer.Digest, digest[:]) {
+			t.Fatalf("%d bytes: got trailer %+v, want length %d and digest %x", n, trailer, n, digest)
+		}
+	}
+}
+
+func TestTrailerMissing(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	params.Trailer = true
+
+	// A stream cut short of its trailer, as a Writer that stopped before
+	// writing it would leave, still authenticates its last chunk. Shorter
+	// streams miss a trailer, longer ones lose their last bytes of
+	// plaintext to a trailer that doesn't match.
+	for _, n := range []int{0, 10, 100} {
+		var out bytes.Buffer
+		w, err := NewWriter(key, &out, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.trailer = nil
+		w.Write(make([]byte, n))
+		err = w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := NewReader(key, bytes.NewReader(out.Bytes()), params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadAll(r)
+		if !errors.Is(err, ErrTrailer) {
+			t.Fatalf("%d bytes: got error %v, want ErrTrailer", n, err)
+		}
+		if r.Trailer() != nil {
+			t.Fatalf("%d bytes: got a trailer", n)
+		}
+	}
+
+	// Cutting the ciphertext short fails before the trailer is checked.
+	var out bytes.Buffer
+	w, err := NewWriter(key, &out, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(make([]byte, 100))
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{80, 100, out.Len() - 1} {
+		r, err := NewReader(key, bytes.NewReader(out.Bytes()[:size]), params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadAll(r)
+		if err == nil {
+			t.Fatalf("cut to %d bytes: read without error", size)
+		}
+		if r.Trailer() != nil {
+			t.Fatalf("cut to %d bytes: got a trailer", size)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrTrailingData = errors.New("trailing data after the encrypted stream")
+
+// WithTrailingData makes the Reader stop at the last chunk of the stream,
+// even if src holds more data after it, returning ErrTrailingData instead
+// of io.EOF if it does. Reader.Rest then returns the data following the
+// stream. Without it, data appended to a stream fails authentication.
+//
+// The end of a stream of fixed size chunks is only marked by its last
+// chunk being authenticated as such, so finding it among trailing data
+// means trying to decrypt it at every length up to the chunk size, which
+// costs as much as decrypting about ChunkSize/2 chunks, up to a few
+// seconds with the default chunk size. To bound this, it is only tried
+// once, where src ends within the chunk failing authentication, and never
+// for the first chunk, whose failure is returned as ErrWrongKey. So the
+// stream must have more than one chunk, and the trailing data must end
+// within the length of a full chunk from the start of the last one. It
+// requires FormatV2 or later, where the last chunk is authenticated.
+func WithTrailingData() ReaderOption {
+	return readerOptionFunc(func(c *readerConfig) {
+		c.trailingData = true
+	})
+}
+
+// checkTrailingData returns an error if params don't support
+// WithTrailingData.
+func (c *readerConfig) checkTrailingData(params *Params) error {
+	if !c.trailingData {
+		return nil
+	}
+	if params.Erasure != (Erasure{}) {
+		return fmt.Errorf("%w: trailing data is not supported with erasure coding", ErrErasure)
+	}
+	if params.Format >= FormatV2 {
+		return nil
+	}
+	return fmt.Errorf("%w: trailing data requires format %d, not %d", ErrFormat, FormatV2, params.Format)
+}
+
+// findLastChunk looks for the last chunk at the beginning of the ciphertext
+// kept in r.held, which failed authentication with openErr, followed by
+// trailing data. The plaintext is left in the pooled buffer of r. atEnd reports
+// whether src is known to end within r.held.
+func (r *Reader) findLastChunk(openErr error, atEnd bool) ([]byte, bool, error) {
+	if r.cipher.index == 0 || errors.Is(openErr, ErrShortChunk) {
+		return nil, false, openErr
+	}
+	if !atEnd {
+		// Data following a full chunk means the stream doesn't end
+		// within it.
+		var b [1]byte
+		n, err := io.ReadFull(r.src, b[:])
+		if n > 0 {
+			return nil, false, openErr
+		}
+		if !errors.Is(err, io.EOF) {
+			return nil, false, err
+		}
+	}
+
+	// The last chunk is always shorter than a full one, so the whole
+	// ciphertext was already tried as the last chunk if it could be it.
+	for n := len(r.held) - 1; n >= r.cipher.aead.Overhead(); n-- {
+		plaintext, err := r.cipher.open(r.pooled[:0], r.held[:n], true)
+		if err == nil {
+			r.rest = bytes.Clone(r.held[n:])
+			r.config.logf("encdec: found last chunk of %d bytes before %d bytes", n, len(r.rest))
+			return plaintext, true, nil
+		}
+	}
+	return nil, false, openErr
+}
+
+// endOfStream returns the error of reading past the last chunk:
+// io.EOF, or ErrTrailingData if src holds more data.
+func (r *Reader) endOfStream() error {
+	if !r.config.trailingData {
+		return io.EOF
+	}
+
+	if len(r.rest) == 0 {
+		var b [1]byte
+		n, err := io.ReadFull(r.src, b[:])
+		if n > 0 {
+			r.rest = b[:]
+		} else if !errors.Is(err, io.EOF) {
+			return err
+		}
+	}
+	if len(r.rest) > 0 {
+		return ErrTrailingData
+	}
+	return io.EOF
+}
+
+// Rest returns the data following the encrypted stream in the underlying
+// reader, once Read has returned ErrTrailingData, or nil otherwise.
+func (r *Reader) Rest() io.Reader {
+	if r == nil || r.err != ErrTrailingData {
+		return nil
+	}
+	return io.MultiReader(bytes.NewReader(r.rest), r.src)
+}
@@ -0,0 +1,79 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// trailingStream returns a stream of size bytes of plaintext encrypted with
+// key and params, followed by junk.
+func trailingStream(t *testing.T, key []byte, params *Params, size int, junk []byte) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := NewWriter(key, &out, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(bytes.Repeat([]byte{'x'}, size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(out.Bytes(), junk...)
+}
+
+func TestTrailingData(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	err := params.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// errAny stands for any error, such as that of a chunk failing
+	// authentication.
+	errAny := errors.New("any error")
+	for _, tt := range []struct {
+		name string
+		size int
+		junk []byte
+		key  []byte
+		err  error
+	}{
+		{"no junk", 100, nil, key, nil},
+		{"junk", 100, []byte("junk"), key, ErrTrailingData},
+		{"single chunk", 10, []byte("junk"), key, ErrWrongKey},
+		{"wrong key", 100, []byte("junk"), make([]byte, keySize), ErrWrongKey},
+		{"junk past a chunk", 100, bytes.Repeat([]byte{1}, 100), key, errAny},
+	} {
+		blob := trailingStream(t, key, params, tt.size, tt.junk)
+		r, err := NewReader(tt.key, bytes.NewReader(blob), params, WithTrailingData())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		ok := errors.Is(err, tt.err)
+		if tt.err == errAny {
+			ok = err != nil && !errors.Is(err, ErrTrailingData)
+		}
+		if !ok {
+			t.Fatalf("%s: got error %v, want %v", tt.name, err, tt.err)
+		}
+		if tt.err != ErrTrailingData {
+			continue
+		}
+		if len(got) != tt.size {
+			t.Fatalf("%s: read %d bytes, want %d", tt.name, len(got), tt.size)
+		}
+		rest, _ := io.ReadAll(r.Rest())
+		if !bytes.Equal(rest, tt.junk) {
+			t.Fatalf("%s: Rest returned %q, want %q", tt.name, rest, tt.junk)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package encdec
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// openTTY opens the controlling terminal of the process.
+func openTTY() (*os.File, error) {
+	f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		// ENXIO and ENODEV mean there is no controlling terminal,
+		// while errors such as EACCES are kept as they are, so the
+		// reason the terminal couldn't be opened is not lost.
+		if errors.Is(err, syscall.ENXIO) || errors.Is(err, syscall.ENODEV) || errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %w", ErrNoTTY, err)
+		}
+		return nil, fmt.Errorf("opening terminal: %w", err)
+	}
+
+	return f, nil
+}
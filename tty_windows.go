@@ -0,0 +1,18 @@
+//go:build windows
+
+package encdec
+
+import (
+	"fmt"
+	"os"
+)
+
+// openTTY opens the console of the process.
+func openTTY() (*os.File, error) {
+	f, err := os.OpenFile("CONIN$", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoTTY, err)
+	}
+
+	return f, nil
+}
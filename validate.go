@@ -0,0 +1,38 @@
+package encdec
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidateHeader checks the header in data, with or without its trailing
+// newline, returning every problem found instead of stopping at the first
+// one like ParseHeader, or nil if it is valid. Fields failing to parse are
+// taken as unset for the checks of the others. No key is derived, so it is
+// cheap enough to validate headers given by users, such as in requests to
+// an API.
+func ValidateHeader(data []byte) []error {
+	line := strings.TrimSuffix(string(data), "\n")
+
+	var errs []error
+	if !strings.HasPrefix(line, headerMagic) {
+		errs = append(errs, fmt.Errorf("parsing header: %w", ErrMagicNotFound))
+		if !strings.HasPrefix(line, "$") {
+			return errs
+		}
+	}
+
+	params, parseErrs := parseHeaderFieldsAll(line)
+	errs = append(errs, parseErrs...)
+	if params == nil {
+		return errs
+	}
+	for _, check := range paramsChecks {
+		err := check(params)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parsing header: %w", err))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,45 @@
+package encdec
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestValidateHeader(t *testing.T) {
+	params := testParams()
+	params.Salt = bytes.Repeat([]byte{1}, SaltSize)
+	header, err := params.MarshalHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{header, header[:len(header)-1]} {
+		errs := ValidateHeader(data)
+		if errs != nil {
+			t.Fatalf("%q: got errors %v", data, errs)
+		}
+	}
+
+	// The type, the salt, the chunk size and the cipher are all wrong,
+	// and the threads field doesn't parse.
+	data := []byte("$argon2d$v=19$t=1,m=64,p=x$s=AQ$b=-5$c=des\n")
+	errs := ValidateHeader(data)
+	for _, want := range []error{ErrMagicNotFound, ErrSaltTooSmall, ErrArgonType, ErrChunkSize, ErrCipherMismatch} {
+		found := false
+		for _, err := range errs {
+			found = found || errors.Is(err, want)
+		}
+		if !found {
+			t.Fatalf("got errors %v, missing %v", errs, want)
+		}
+	}
+	if len(errs) != 6 {
+		t.Fatalf("got %d errors, want 6: %v", len(errs), errs)
+	}
+
+	// ParseHeader stops at the first problem.
+	_, err = ParseHeaderBytes(data)
+	if err == nil || err.Error() != errs[1].Error() {
+		t.Fatalf("ParseHeader returned %v, want %v", err, errs[1])
+	}
+}
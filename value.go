@@ -0,0 +1,26 @@
+package encdec
+
+import "encoding/json"
+
+// EncryptValue encodes v as JSON and encrypts it with EncryptBytes.
+func EncryptValue[T any](password []byte, v T, params *Params) ([]byte, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return EncryptBytes(password, plaintext, params)
+}
+
+// DecryptValue decrypts blob, as returned by EncryptValue, with
+// DecryptBytes, decoding the result as JSON into a value of type T.
+func DecryptValue[T any](password []byte, blob []byte) (T, error) {
+	var v T
+	plaintext, err := DecryptBytes(password, blob)
+	if err != nil {
+		return v, err
+	}
+
+	err = json.Unmarshal(plaintext, &v)
+	return v, err
+}